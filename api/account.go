@@ -1,9 +1,14 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/Edbeer/paymentapi/pkg/db/psql"
 	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/google/uuid"
@@ -11,6 +16,14 @@ import (
 	"github.com/opentracing/opentracing-go"
 )
 
+var (
+	errAccount         = types.NewError(types.CodeNotFound, "account doesn't exist")
+	errDepositAccount  = types.NewError(types.CodeNotFound, "account doesn't exist or doesn't hold the currency")
+	errDepositCard     = types.NewError(types.CodeForbidden, "card isn't the card of the account")
+	errNoCookie        = types.NewError(types.CodeInvalid, "Cookie doesn't exist")
+	errMerchantSetting = types.NewError(types.CodeForbidden, "authorization_ttl can be set only for merchant accounts")
+)
+
 // createAccount godoc
 // @Summary Create new account
 // @Description register new account, returns account
@@ -29,29 +42,36 @@ func (s *JSONApiServer) createAccount(w http.ResponseWriter, r *http.Request) er
 
 	req := &types.RequestCreate{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
 	// validate request
 	if err := utils.ValidateCreateRequest(req); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	card, err := s.vault.Tokenize(ctx, req.CardNumber)
+	if err != nil {
+		return s.writeError(w, err)
 	}
-	account, err := s.storage.CreateAccount(ctx, req)
+	account, err := s.storage.CreateAccount(ctx, req, card)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	if req.Password != "" {
+		if err := s.savePassword(ctx, account.ID, req.Password); err != nil {
+			return s.writeError(w, err)
+		}
 	}
 	// jwt-token
-	tokenString, err := utils.CreateJWT(account)
+	tokenString, err := s.tokens.CreateJWT(account)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	w.Header().Add("x-jwt-token", tokenString)
 	// refreshToken
-	refreshToken, err := s.redisStorage.CreateSession(ctx, &types.Session{
-		UserID: account.ID,
-	}, 86400)
+	refreshToken, err := s.redisStorage.CreateSession(ctx, newSession(r, account.ID), s.refreshTokenTTL())
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "error"})
+		return s.writeError(w, err)
 	}
 	// cookie
 	cookie := &http.Cookie{
@@ -59,7 +79,7 @@ func (s *JSONApiServer) createAccount(w http.ResponseWriter, r *http.Request) er
 		Value:      refreshToken,
 		Path:       "/",
 		RawExpires: "",
-		MaxAge:     86400,
+		MaxAge:     s.refreshTokenTTL(),
 		Secure:     false,
 		HttpOnly:   true,
 		SameSite:   0,
@@ -85,7 +105,7 @@ func (s *JSONApiServer) getAccount(w http.ResponseWriter, r *http.Request) error
 
 	accounts, err := s.storage.GetAccount(ctx)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, accounts)
 }
@@ -107,11 +127,15 @@ func (s *JSONApiServer) getAccountByID(w http.ResponseWriter, r *http.Request) e
 
 	uuid, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	account, err := s.storage.GetAccountByID(ctx, uuid)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	account.Balances, err = s.storage.GetBalances(ctx, uuid)
+	if err != nil {
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, account)
 }
@@ -126,6 +150,7 @@ func (s *JSONApiServer) getAccountByID(w http.ResponseWriter, r *http.Request) e
 // @Param input body types.RequestUpdate true "update account info"
 // @Success 200 {object} types.Account
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
 // @Router /account/{id} [put]
@@ -135,20 +160,34 @@ func (s *JSONApiServer) updateAccount(w http.ResponseWriter, r *http.Request) er
 
 	uuid, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	reqUpd := &types.RequestUpdate{}
 	if err := json.NewDecoder(r.Body).Decode(reqUpd); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
 	// validate request
 	if err := utils.ValidateUpdateRequest(reqUpd); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	account, err := s.storage.UpdateAccount(ctx, reqUpd, uuid)
+	// merchant setting
+	if reqUpd.AuthorizationTTL != nil {
+		account, err := s.storage.GetAccountByID(ctx, uuid)
+		if err != nil {
+			return s.writeError(w, err)
+		}
+		if !types.Can(account.Role, types.PermissionAcceptPayments, true) {
+			return s.writeError(w, errMerchantSetting)
+		}
+	}
+	card, err := s.vault.Tokenize(ctx, reqUpd.CardNumber)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	account, err := s.storage.UpdateAccount(ctx, reqUpd, card, uuid)
+	if err != nil {
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, account)
 }
@@ -170,50 +209,182 @@ func (s *JSONApiServer) deleteAccount(w http.ResponseWriter, r *http.Request) er
 
 	uuid, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	if err := s.storage.DeleteAccount(ctx, uuid); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, "account was deleted")
 }
 
+// updateRole godoc
+// @Summary Change account role
+// @Description change role of the account, admins only, returns updated account
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "account id"
+// @Param input body types.RequestRole true "account role info"
+// @Success 200 {object} types.Account
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/role [put]
+func (s *JSONApiServer) updateRole(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.updateRole")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestRole{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	if err := utils.ValidateRoleRequest(req); err != nil {
+		return s.writeError(w, err)
+	}
+	account, err := s.storage.UpdateRole(ctx, id, req.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.writeError(w, errAccount)
+		}
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, account)
+}
+
 // depositAccount godoc
 // @Summary Deposit money
-// @Description deposit money to account, returns account
+// @Description deposit money with the card of the account to its balance in the currency, account currency by default, returns balance
 // @Tags Account
 // @Accept json
 // @Produce json
+// @Param id path string true "account id"
 // @Param input body types.RequestDeposit true "deposit account info"
-// @Success 200 {object} types.Account
+// @Param Idempotency-Key header string false "idempotency key"
+// @Success 200 {object} types.Balance
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
-// @Router /account/deposit [post]
+// @Router /account/deposit/{id} [post]
 func (s *JSONApiServer) depositAccount(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.depositAccount")
 	defer span.Finish()
 
 	reqDep := &types.RequestDeposit{}
 	if err := json.NewDecoder(r.Body).Decode(reqDep); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, "account doesn't exist")
+		return s.writeError(w, types.InvalidError(err))
 	}
 	// validate request
 	if err := utils.ValidateDepositRequest(reqDep); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	acc, err := s.storage.GetAccountByCard(ctx, reqDep.CardNumber)
+	// deposits to other accounts need the permission on any account
+	id, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, "account doesn't exist")
+		return s.writeError(w, err)
 	}
-	acc.Balance = acc.Balance + reqDep.Balance
-	reqDep.Balance = acc.Balance
-	updatedAccount, err := s.storage.DepositAccount(ctx, reqDep)
+	account, err := s.storage.GetAccountByID(ctx, id)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, "account doesn't exist")
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.writeError(w, errDepositAccount)
+		}
+		return s.writeError(w, err)
+	}
+	// several accounts may hold the card, it only has to be the card of the account
+	cardToken, err := s.vault.Lookup(ctx, reqDep.CardNumber)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return s.writeError(w, err)
+	}
+	if cardToken == "" || cardToken != account.CardToken {
+		return s.writeError(w, errDepositCard)
+	}
+	var balance *types.Balance
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		balance, err = s.storage.DepositAccount(ctx, tx, reqDep, account.ID)
+		if err != nil {
+			return err
+		}
+		// ledger
+		_, err = s.storage.SaveJournalEntry(ctx, tx, types.DepositEntry(balance.AccountID, balance.Currency, reqDep.Balance))
+		return err
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.writeError(w, errDepositAccount)
+		}
+		return s.writeError(w, err)
 	}
 
-	return WriteJSON(w, http.StatusOK, updatedAccount)
+	return WriteJSON(w, http.StatusOK, balance)
+}
+
+// getBalances godoc
+// @Summary Get account balances
+// @Description get account balances in all currencies, returns balances
+// @Tags Account
+// @Produce json
+// @Param id path string true "get balances info"
+// @Success 200 {object} []types.Balance
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/balance/{id} [get]
+func (s *JSONApiServer) getBalances(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.getBalances")
+	defer span.Finish()
+
+	uuid, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	balances, err := s.storage.GetBalances(ctx, uuid)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, balances)
+}
+
+// openBalance godoc
+// @Summary Open account balance
+// @Description open account balance in a new currency, returns balance
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "open balance info"
+// @Param input body types.RequestBalance true "open balance info"
+// @Success 200 {object} types.Balance
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/balance/{id} [post]
+func (s *JSONApiServer) openBalance(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.openBalance")
+	defer span.Finish()
+
+	uuid, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestBalance{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	// validate request
+	if err := utils.ValidateCurrency(req.Currency); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	balance, err := s.storage.OpenBalance(ctx, uuid, req.Currency)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, balance)
 }
 
 // getStatement godoc
@@ -233,26 +404,77 @@ func (s *JSONApiServer) getStatement(w http.ResponseWriter, r *http.Request) err
 
 	uuid, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	statement, err := s.storage.GetAccountStatement(ctx, uuid)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, statement)
 }
 
+// getLedgerBalance godoc
+// @Summary Get account ledger balance
+// @Description get account balance derived from the ledger and reconcile it with the account balance
+// @Tags Account
+// @Produce json
+// @Param id path string true "get ledger balance info"
+// @Param currency query string false "currency, account currency by default"
+// @Success 200 {object} types.LedgerBalance
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/ledger/{id} [get]
+func (s *JSONApiServer) getLedgerBalance(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.getLedgerBalance")
+	defer span.Finish()
+
+	uuid, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	account, err := s.storage.GetAccountByID(ctx, uuid)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = account.Currency
+	}
+	if err := utils.ValidateCurrency(currency); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	account.Balances, err = s.storage.GetBalances(ctx, uuid)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	balance, err := s.storage.GetLedgerBalance(ctx, uuid, currency)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	wallet, ok := account.Wallet(currency)
+	if !ok {
+		wallet = &types.Balance{}
+	}
+	balance.Reconciled = balance.Balance == int64(wallet.Balance) &&
+		balance.BlockedMoney == int64(wallet.BlockedMoney)
+	return WriteJSON(w, http.StatusOK, balance)
+}
+
 // signIn godoc
 // @Summary Login
-// @Description log in to your account, returns account
+// @Description log in to your account with its password, returns account or the challenge of two-factor authentication
 // @Tags Account
 // @Accept json
 // @Produce json
 // @Param input body types.LoginRequest true "login account info"
 // @Success 200 {object} types.Account
+// @Success 202 {object} types.SignInChallenge
 // @Failure 400  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
+// @Failure 401  {object}  api.ApiError
+// @Failure 423  {object}  api.ApiError
 // @Router /account/sign-in [post]
 func (s *JSONApiServer) signIn(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.signIn")
@@ -260,26 +482,63 @@ func (s *JSONApiServer) signIn(w http.ResponseWriter, r *http.Request) error {
 
 	req := &types.LoginRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
+	// unknown accounts and accounts without password
+	// get the same error as a wrong password
 	account, err := s.storage.GetAccountByID(ctx, req.ID)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, errCredentials)
+	}
+	credential, err := s.storage.GetCredential(ctx, req.ID)
+	if err != nil {
+		return s.writeError(w, errCredentials)
+	}
+	now := time.Now()
+	if credential.Locked(now) {
+		return s.writeError(w, errLocked)
+	}
+	if !utils.CheckPassword(credential.PasswordHash, req.Password) {
+		if _, err := s.storage.SaveFailedLogin(ctx, req.ID, s.maxFailedLogins(), now.Add(s.lockout())); err != nil {
+			return s.writeError(w, err)
+		}
+		return s.writeError(w, errCredentials)
+	}
+	// accounts with two-factor authentication continue with the code,
+	// failed sign-ins are cleared after it
+	totp, err := s.storage.GetTOTP(ctx, req.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return s.writeError(w, err)
+	}
+	if err == nil && totp.Enabled() {
+		challenge, err := s.redisStorage.CreateSignInChallenge(ctx, req.ID, signInChallengeExpire)
+		if err != nil {
+			return s.writeError(w, err)
+		}
+		return WriteJSON(w, http.StatusAccepted, &types.SignInChallenge{Challenge: challenge})
+	}
+	return s.startSession(ctx, w, r, account, credential)
+}
+
+// Issue access and refresh tokens of the signed in account
+func (s *JSONApiServer) startSession(ctx context.Context, w http.ResponseWriter, r *http.Request, account *types.Account, credential *types.Credential) error {
+	if credential.FailedLogins > 0 {
+		if err := s.storage.ResetFailedLogins(ctx, account.ID); err != nil {
+			return s.writeError(w, err)
+		}
 	}
 
 	// jwt-token
-	tokenString, err := utils.CreateJWT(account)
+	tokenString, err := s.tokens.CreateJWT(account)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	w.Header().Add("x-jwt-token", tokenString)
 
 	// refreshToken
-	refreshToken, err := s.redisStorage.CreateSession(ctx, &types.Session{
-		UserID: account.ID,
-	}, 86400)
+	refreshToken, err := s.redisStorage.CreateSession(ctx, newSession(r, account.ID), s.refreshTokenTTL())
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// cookie
 	cookie := &http.Cookie{
@@ -287,7 +546,7 @@ func (s *JSONApiServer) signIn(w http.ResponseWriter, r *http.Request) error {
 		Value:      refreshToken,
 		Path:       "/",
 		RawExpires: "",
-		MaxAge:     86400,
+		MaxAge:     s.refreshTokenTTL(),
 		Secure:     false,
 		HttpOnly:   true,
 		SameSite:   0,
@@ -314,12 +573,12 @@ func (s *JSONApiServer) signOut(w http.ResponseWriter, r *http.Request) error {
 	cookie, err := r.Cookie("refresh-token")
 	if err != nil {
 		if err == http.ErrNoCookie {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "Cookie doesn't exist"})
+			return s.writeError(w, errNoCookie)
 		}
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	if err := s.redisStorage.DeleteSession(ctx, cookie.Value); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, "LogOut")
 }
@@ -333,6 +592,7 @@ func (s *JSONApiServer) signOut(w http.ResponseWriter, r *http.Request) error {
 // @Param input body types.RefreshRequest true "refresh tokens account info"
 // @Success 200 {object} types.RefreshResponse
 // @Failure 400  {object}  api.ApiError
+// @Failure 401  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
 // @Router /account/refresh [post]
@@ -342,40 +602,33 @@ func (s *JSONApiServer) refreshTokens(w http.ResponseWriter, r *http.Request) er
 
 	req := &types.RefreshRequest{}
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
-	uid, err := s.redisStorage.GetUserID(ctx, req.RefreshToken)
+	// the refresh token is replaced, a replaced token can't be used again
+	session, err := s.redisStorage.RotateSession(ctx, req.RefreshToken, s.refreshTokenTTL())
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, errRefreshToken)
 	}
 
-	account, err := s.storage.GetAccountByID(ctx, uid)
+	account, err := s.storage.GetAccountByID(ctx, session.UserID)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 
 	// jwt-token
-	tokenString, err := utils.CreateJWT(account)
+	tokenString, err := s.tokens.CreateJWT(account)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	w.Header().Add("x-jwt-token", tokenString)
 
-	// refreshToken
-	refreshToken, err := s.redisStorage.CreateSession(ctx, &types.Session{
-		UserID: account.ID,
-	}, 86400)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-
 	// cookie
 	cookie := &http.Cookie{
 		Name:       "refresh-token",
-		Value:      refreshToken,
+		Value:      session.RefreshToken,
 		Path:       "/",
 		RawExpires: "",
-		MaxAge:     86400,
+		MaxAge:     s.refreshTokenTTL(),
 		Secure:     false,
 		HttpOnly:   true,
 		SameSite:   0,
@@ -383,17 +636,42 @@ func (s *JSONApiServer) refreshTokens(w http.ResponseWriter, r *http.Request) er
 	http.SetCookie(w, cookie)
 
 	return WriteJSON(w, http.StatusOK, &types.RefreshResponse{
-		RefreshToken: refreshToken,
+		RefreshToken: session.RefreshToken,
 		AccessToken:  tokenString,
 	})
 }
 
+// getJWKS godoc
+// @Summary Access token keys
+// @Description public keys to verify access tokens, returns JWK set
+// @Tags Account
+// @Produce json
+// @Success 200 {object} types.JWKS
+// @Router /.well-known/jwks.json [get]
+func (s *JSONApiServer) getJWKS(w http.ResponseWriter, r *http.Request) error {
+	span, _ := opentracing.StartSpanFromContext(r.Context(), "Account.getJWKS")
+	defer span.Finish()
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return WriteJSON(w, http.StatusOK, s.tokens.JWKS())
+}
+
+// refresh token lifetime in seconds if the config doesn't set it
+const defaultRefreshTokenTTL = 86400
+
+func (s *JSONApiServer) refreshTokenTTL() int {
+	if s.config.Server.RefreshTokenTTL > 0 {
+		return s.config.Server.RefreshTokenTTL
+	}
+	return defaultRefreshTokenTTL
+}
+
 // Get id from url
 func GetUUID(r *http.Request) (uuid.UUID, error) {
 	id := mux.Vars(r)["id"]
 	uid, err := uuid.Parse(id)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, types.InvalidError(err)
 	}
 
 	return uid, nil
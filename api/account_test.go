@@ -1,8 +1,20 @@
 package api
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,8 +24,10 @@ import (
 	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/alicebob/miniredis"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/opentracing/opentracing-go"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
@@ -39,15 +53,16 @@ func Test_CreateAccount(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	mockVault := mockstore.NewMockCardVault(ctrl)
 
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil)
+	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, mockVault, nil, nil, testTokens(t), nil)
 	req := &types.RequestCreate{
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
-		CardNumber:       "4444444444444444",
+		CardNumber:       "4242424242424242",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
+		CardExpiryYear:   "30",
 		CardSecurityCode: "924",
 	}
 	err = utils.ValidateCreateRequest(req)
@@ -62,23 +77,26 @@ func Test_CreateAccount(t *testing.T) {
 	defer span.Finish()
 
 	recorder := httptest.NewRecorder()
-	reqAcc := types.NewAccount(req)
-	mockStorage.EXPECT().CreateAccount(ctxWithTrace, gomock.Eq(req)).Return(&types.Account{
+	card := &types.Card{Token: "tok_1", CardNumber: "424242******4242"}
+	reqAcc := types.NewAccount(req, card)
+	// the storage gets the token and the masked number of the card
+	mockVault.EXPECT().Tokenize(ctxWithTrace, "4242424242424242").Return(card, nil)
+	mockStorage.EXPECT().CreateAccount(ctxWithTrace, gomock.Eq(req), card).Return(&types.Account{
 		ID:               reqAcc.ID,
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
-		CardNumber:       "4444444444444444",
+		CardToken:        "tok_1",
+		CardNumber:       "424242******4242",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        reqAcc.Statement,
 		CreatedAt:        reqAcc.CreatedAt,
 	}, nil).AnyTimes()
 
 	sess := &types.Session{
 		UserID: reqAcc.ID,
+		IP:     "192.0.2.1",
 	}
 	token := "refresh-token"
 	mockRedis.EXPECT().CreateSession(ctxWithTrace, gomock.Eq(sess), 86400).Return(token, nil)
@@ -87,6 +105,9 @@ func Test_CreateAccount(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, err)
 	require.NotNil(t, reqAcc.ID)
+	require.Contains(t, recorder.Body.String(), `"card_token":"tok_1"`)
+	require.Contains(t, recorder.Body.String(), `"card_number":"424242******4242"`)
+	require.NotContains(t, recorder.Body.String(), req.CardNumber)
 }
 
 func Test_SignIn(t *testing.T) {
@@ -111,11 +132,14 @@ func Test_SignIn(t *testing.T) {
 	mockRedis := mockstore.NewMockRedisStorage(ctrl)
 
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil)
+	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil, nil, nil, testTokens(t), nil)
 
 	req := &types.LoginRequest{
-		ID: uuid.New(),
+		ID:       uuid.New(),
+		Password: "correct horse",
 	}
+	passwordHash, err := utils.HashPassword(req.Password)
+	require.NoError(t, err)
 
 	buffer, err := utils.AnyToBytesBuffer(req)
 	require.NoError(t, err)
@@ -131,19 +155,23 @@ func Test_SignIn(t *testing.T) {
 		ID:               req.ID,
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
-		CardNumber:       "4444444444444444",
+		CardToken:        "tok_4242424242424242",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        []string{},
 		CreatedAt:        time.Now(),
 	}
 
 	mockStorage.EXPECT().GetAccountByID(ctxWithTrace, req.ID).Return(account, nil).AnyTimes()
+	mockStorage.EXPECT().GetCredential(ctxWithTrace, req.ID).Return(&types.Credential{
+		AccountID:    req.ID,
+		PasswordHash: passwordHash,
+	}, nil)
+	mockStorage.EXPECT().GetTOTP(ctxWithTrace, req.ID).Return(nil, sql.ErrNoRows)
 	sess := &types.Session{
 		UserID: req.ID,
+		IP:     "192.0.2.1",
 	}
 	token := "refresh-token"
 	mockRedis.EXPECT().CreateSession(ctxWithTrace, gomock.Eq(sess), 86400).Return(token, nil)
@@ -152,6 +180,66 @@ func Test_SignIn(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, err)
 	require.NotNil(t, req.ID)
+	require.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func Test_SignInFailed(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	id := uuid.New()
+	passwordHash, err := utils.HashPassword("correct horse")
+	require.NoError(t, err)
+	newRequest := func(password string) *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(&types.LoginRequest{ID: id, Password: password})
+		require.NoError(t, err)
+		return httptest.NewRequest(http.MethodPost, "/account/sign-in", buffer)
+	}
+	mockStorage.EXPECT().GetAccountByID(gomock.Any(), id).Return(&types.Account{ID: id}, nil).AnyTimes()
+
+	t.Run("Wrong password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{
+			AccountID:    id,
+			PasswordHash: passwordHash,
+		}, nil)
+		mockStorage.EXPECT().SaveFailedLogin(gomock.Any(), id, defaultMaxFailedLogins, gomock.Any()).DoAndReturn(
+			func(_ any, _ uuid.UUID, _ int, lockedUntil time.Time) (*types.Credential, error) {
+				require.WithinDuration(t, time.Now().Add(defaultLockout), lockedUntil, time.Minute)
+				return &types.Credential{FailedLogins: 1}, nil
+			})
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signIn(recorder, newRequest("wrong horse")))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Empty(t, recorder.Header().Get("x-jwt-token"))
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		lockedUntil := time.Now().Add(time.Minute)
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{
+			AccountID:    id,
+			PasswordHash: passwordHash,
+			LockedUntil:  &lockedUntil,
+		}, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signIn(recorder, newRequest("correct horse")))
+		require.Equal(t, http.StatusLocked, recorder.Code)
+	})
+
+	t.Run("No password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signIn(recorder, newRequest("")))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errCredentials.Error())
+	})
 }
 
 func Test_SignOut(t *testing.T) {
@@ -176,7 +264,7 @@ func Test_SignOut(t *testing.T) {
 	mockRedis := mockstore.NewMockRedisStorage(ctrl)
 
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil)
+	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil, nil, nil, nil, nil)
 
 	request := httptest.NewRequest(http.MethodPost, "/account/sign-out", nil)
 	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.signOut")
@@ -224,7 +312,7 @@ func Test_RefreshTokens(t *testing.T) {
 	mockRedis := mockstore.NewMockRedisStorage(ctrl)
 	config := &config.Config{}
 	
-	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil)
+	server := NewJSONApiServer(config, db, client, mockStorage, mockRedis, nil, nil, nil, testTokens(t), nil)
 
 	req := &types.RefreshRequest{
 		RefreshToken: "cookieValue",
@@ -241,18 +329,20 @@ func Test_RefreshTokens(t *testing.T) {
 
 	recorder := httptest.NewRecorder()
 	uid := uuid.New()
-	mockRedis.EXPECT().GetUserID(ctxWithTrace, req.RefreshToken).Return(uid, nil).AnyTimes()
+	mockRedis.EXPECT().RotateSession(ctxWithTrace, req.RefreshToken, 86400).Return(&types.Session{
+		ID:           uuid.New(),
+		RefreshToken: "refresh-token",
+		UserID:       uid,
+	}, nil)
 
 	account := &types.Account{
 		ID:               uid,
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
-		CardNumber:       "4444444444444444",
+		CardToken:        "tok_4242424242424242",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        []string{},
 		CreatedAt:        time.Now(),
 	}
@@ -260,11 +350,6 @@ func Test_RefreshTokens(t *testing.T) {
 	mockStorage.EXPECT().GetAccountByID(ctxWithTrace, uid).Return(account, nil).AnyTimes()
 
 	token := "refresh-token"
-	sess := &types.Session{
-		UserID: uid,
-	}
-	mockRedis.EXPECT().CreateSession(ctxWithTrace, gomock.Eq(sess), 86400).Return(token, nil)
-
 	request.AddCookie(&http.Cookie{Name: token, Value: req.RefreshToken})
 
 	cookie, err := request.Cookie(token)
@@ -290,7 +375,7 @@ func Test_GetAccount(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 
 	request := httptest.NewRequest(http.MethodGet, "/account", nil)
 	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.getAccount")
@@ -303,12 +388,10 @@ func Test_GetAccount(t *testing.T) {
 			ID:               uuid.New(),
 			FirstName:        "Pasha",
 			LastName:         "volkov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		},
@@ -316,12 +399,10 @@ func Test_GetAccount(t *testing.T) {
 			ID:               uuid.New(),
 			FirstName:        "Pasha1",
 			LastName:         "volkov1",
-			CardNumber:       "444444444444442",
+			CardToken:        "tok_444444444444442",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		},
@@ -329,12 +410,10 @@ func Test_GetAccount(t *testing.T) {
 			ID:               uuid.New(),
 			FirstName:        "Pasha12",
 			LastName:         "volkov12",
-			CardNumber:       "444444444444443",
+			CardToken:        "tok_444444444444443",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		},
@@ -360,7 +439,7 @@ func Test_GetAccountByID(t *testing.T) {
 	mockStorage := mockstore.NewMockStorage(ctrl)
 
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	request := httptest.NewRequest(http.MethodGet, "/accounе/{id}", nil)
 	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.getAccountByID")
 	defer span.Finish()
@@ -372,12 +451,10 @@ func Test_GetAccountByID(t *testing.T) {
 		ID:               uid,
 		FirstName:        "Pasha",
 		LastName:         "volkov",
-		CardNumber:       "444444444444444",
+		CardToken:        "tok_444444444444444",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        make([]string, 1),
 		CreatedAt:        time.Now(),
 	}
@@ -389,6 +466,44 @@ func Test_GetAccountByID(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func Test_MaskedCardNumber(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid := uuid.New()
+	account := &types.Account{
+		ID:              uid,
+		CardToken:       "tok_1",
+		CardNumber:      "4444333322221111",
+		CardExpiryMonth: "12",
+		CardExpiryYear:  "24",
+		Currency:        "RUB",
+	}
+	mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+	mockStorage.EXPECT().GetBalances(gomock.Any(), uid).Return([]*types.Balance{}, nil)
+
+	request := httptest.NewRequest(http.MethodGet, "/account/"+uid.String(), nil)
+	request = mux.SetURLVars(request, map[string]string{"id": uid.String()})
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.getAccountByID(recorder, request))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Body.String(), `"card_number":"444433******1111"`)
+	require.NotContains(t, recorder.Body.String(), account.CardNumber)
+	require.NotContains(t, recorder.Body.String(), "card_security_code")
+
+	// payments, in lists too
+	payment := &types.Payment{ID: uuid.New(), CardToken: "tok_1", CardNumber: account.CardNumber}
+	recorder = httptest.NewRecorder()
+	require.NoError(t, WriteJSON(recorder, http.StatusOK, []*types.Payment{payment}))
+	require.Contains(t, recorder.Body.String(), `"card_number":"444433******1111"`)
+	require.NotContains(t, recorder.Body.String(), account.CardNumber)
+}
+
 func Test_UpdateAccount(t *testing.T) {
 	t.Parallel()
 
@@ -402,14 +517,13 @@ func Test_UpdateAccount(t *testing.T) {
 	mockStorage := mockstore.NewMockStorage(ctrl)
 
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	reqUp := &types.RequestUpdate{
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
 		CardNumber:       "444444444444444",
 		CardExpiryMonth:  "",
 		CardExpiryYear:   "",
-		CardSecurityCode: "",
 	}
 	err = utils.ValidateUpdateRequest(reqUp)
 	buffer, err := utils.AnyToBytesBuffer(reqUp)
@@ -427,26 +541,70 @@ func Test_UpdateAccount(t *testing.T) {
 		ID:               uid,
 		FirstName:        "Pasha1",
 		LastName:         "volkov1",
-		CardNumber:       "444444444444444",
+		CardToken:        "tok_444444444444444",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        make([]string, 1),
 		CreatedAt:        time.Now(),
 	}
 
-	mockStorage.EXPECT().UpdateAccount(ctxWithTrace, reqUp, uid).Return(account, nil).AnyTimes()
+	mockStorage.EXPECT().UpdateAccount(ctxWithTrace, reqUp, gomock.Any(), uid).Return(account, nil).AnyTimes()
 	require.Equal(t, reqUp.FirstName, account.FirstName)
 	require.Equal(t, reqUp.LastName, account.LastName)
-	require.Equal(t, reqUp.CardNumber, account.CardNumber)
 	
 	err = server.updateAccount(recorder, request)
 	require.NoError(t, err)
 	require.Nil(t, err)
 }
 
+func Test_UpdateAccountAuthorizationTTL(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockVault := mockstore.NewMockCardVault(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, mockVault, nil, nil, nil, nil)
+
+	uid := uuid.New()
+	newRequest := func(t *testing.T, ttl uint32) *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(&types.RequestUpdate{
+			CardNumber:       "4242424242424242",
+			AuthorizationTTL: &ttl,
+		})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPut, "/account/{id}", buffer)
+		return mux.SetURLVars(request, map[string]string{"id": uid.String()})
+	}
+
+	t.Run("Customer", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(&types.Account{ID: uid, Role: types.RoleCustomer}, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.updateAccount(recorder, newRequest(t, 24)))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+
+	t.Run("Merchant reset", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(&types.Account{ID: uid, Role: types.RoleMerchant}, nil)
+		card := &types.Card{Token: "tok_4242", CardNumber: "424242******4242"}
+		mockVault.EXPECT().Tokenize(gomock.Any(), "4242424242424242").Return(card, nil)
+		mockStorage.EXPECT().UpdateAccount(gomock.Any(), gomock.Any(), card, uid).
+			DoAndReturn(func(_ context.Context, reqUp *types.RequestUpdate, _ *types.Card, _ uuid.UUID) (*types.Account, error) {
+				// 0 goes back to the default, it isn't ignored
+				require.NotNil(t, reqUp.AuthorizationTTL)
+				require.Zero(t, *reqUp.AuthorizationTTL)
+				return &types.Account{ID: uid, Role: types.RoleMerchant}, nil
+			})
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.updateAccount(recorder, newRequest(t, 0)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
+
 func Test_DeleteAccount(t *testing.T) {
 	t.Parallel()
 
@@ -459,7 +617,7 @@ func Test_DeleteAccount(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 
 	request := httptest.NewRequest(http.MethodDelete, "/account/{id}", nil)
 	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.deleteAccount")
@@ -476,67 +634,132 @@ func Test_DeleteAccount(t *testing.T) {
 	require.Nil(t, err)
 }
 
+func Test_UpdateRole(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid := uuid.New()
+	newRequest := func(body string) *http.Request {
+		request := httptest.NewRequest(http.MethodPut, "/account/"+uid.String()+"/role", strings.NewReader(body))
+		return mux.SetURLVars(request, map[string]string{"id": uid.String()})
+	}
+
+	t.Run("Support", func(t *testing.T) {
+		account := &types.Account{ID: uid, Role: types.RoleSupport}
+		mockStorage.EXPECT().UpdateRole(gomock.Any(), uid, types.RoleSupport).Return(account, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.updateRole(recorder, newRequest(`{"role":"support"}`)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		updated := &types.Account{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(updated))
+		require.Equal(t, types.RoleSupport, updated.Role)
+	})
+
+	t.Run("Invalid role", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.updateRole(recorder, newRequest(`{"role":"root"}`)))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		mockStorage.EXPECT().UpdateRole(gomock.Any(), uid, types.RoleAdmin).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.updateRole(recorder, newRequest(`{"role":"admin"}`)))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
 func Test_DepositAccount(t *testing.T) {
 	t.Parallel()
 
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockVault := mockstore.NewMockCardVault(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, mockVault, nil, nil, nil, nil)
 	reqDep := &types.RequestDeposit{
-		CardNumber: "4444444444424323",
+		CardNumber: "4111111111111111",
 		Balance:    44,
 	}
 	err = utils.ValidateDepositRequest(reqDep)
 	require.NoError(t, err)
-	buffer, err := utils.AnyToBytesBuffer(reqDep)
-	require.NoError(t, err)
-	require.NotNil(t, buffer)
-	require.Nil(t, err)
-	request := httptest.NewRequest(http.MethodPost, "/account/deposit", buffer)
-	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.depositAccount")
-	defer span.Finish()
 
-	recorder := httptest.NewRecorder()
 	uid := uuid.New()
-	account := &types.Account{
-		ID:               uid,
-		FirstName:        "Pavel",
-		LastName:         "volkov",
-		CardNumber:       "4444444444424323",
-		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
-		Statement:        make([]string, 1),
-		CreatedAt:        time.Now(),
-	}
-	account2 := &types.Account{
-		ID:               uid,
-		FirstName:        "Pavel",
-		LastName:         "volkov",
-		CardNumber:       "4444444444424323",
-		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          reqDep.Balance,
-		BlockedMoney:     0,
-		Statement:        make([]string, 1),
-		CreatedAt:        time.Now(),
+	account := &types.Account{ID: uid, CardToken: "tok_1", Role: types.RoleCustomer}
+	newRequest := func() *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(reqDep)
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/account/deposit/"+uid.String(), buffer)
+		return mux.SetURLVars(request, map[string]string{"id": uid.String()})
 	}
-	mockStorage.EXPECT().GetAccountByCard(ctxWithTrace, reqDep.CardNumber).Return(account, nil).AnyTimes()
-	mockStorage.EXPECT().DepositAccount(ctxWithTrace, reqDep).Return(account2, nil).AnyTimes()
 
-	err = server.depositAccount(recorder, request)
-	require.NoError(t, err)
-	require.Nil(t, err)
+	t.Run("Deposit", func(t *testing.T) {
+		balance := &types.Balance{
+			AccountID: uid,
+			Currency:  "RUB",
+			Balance:   reqDep.Balance,
+		}
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockVault.EXPECT().Lookup(gomock.Any(), reqDep.CardNumber).Return("tok_1", nil)
+		mock.ExpectBegin()
+		// only the balance of the account is changed
+		mockStorage.EXPECT().DepositAccount(gomock.Any(), gomock.Any(), reqDep, uid).Return(balance, nil)
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				require.True(t, entry.Balanced())
+				require.Equal(t, reqDep.Balance, entry.Lines[1].Credit)
+				require.Equal(t, uid, entry.Lines[1].AccountID)
+				require.Equal(t, "RUB", entry.Lines[1].Currency)
+				return entry, nil
+			})
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.depositAccount(recorder, newRequest()))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Card of another account", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockVault.EXPECT().Lookup(gomock.Any(), reqDep.CardNumber).Return("tok_2", nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.depositAccount(recorder, newRequest()))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errDepositCard.Error())
+	})
+
+	t.Run("Card isn't in the vault", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockVault.EXPECT().Lookup(gomock.Any(), reqDep.CardNumber).Return("", sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.depositAccount(recorder, newRequest()))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+
+	t.Run("No account", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.depositAccount(recorder, newRequest()))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
 }
 
 func Test_GetStatement(t *testing.T) {
@@ -551,7 +774,7 @@ func Test_GetStatement(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	request := httptest.NewRequest(http.MethodGet, "/accounе/statement/{id}", nil)
 	span, ctxWithTrace := opentracing.StartSpanFromContext(request.Context(), "Account.getStatement")
 	defer span.Finish()
@@ -563,12 +786,10 @@ func Test_GetStatement(t *testing.T) {
 		ID:               uid,
 		FirstName:        "Pasha",
 		LastName:         "volkov",
-		CardNumber:       "444444444444444",
+		CardToken:        "tok_444444444444444",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
-		CardSecurityCode: "924",
-		Balance:          0,
-		BlockedMoney:     0,
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
 		Statement:        make([]string, 1),
 		CreatedAt:        time.Now(),
 	}
@@ -578,4 +799,65 @@ func Test_GetStatement(t *testing.T) {
 	err = server.getAccountByID(recorder, request)
 	require.NoError(t, err)
 	require.Nil(t, err)
-}
\ No newline at end of file
+}
+func Test_GetJWKS(t *testing.T) {
+	t.Parallel()
+
+	// the old RSA key and the new Ed25519 key
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	for name, key := range map[string]interface{}{"2023-01": rsaKey, "2023-06": edKey} {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), data, 0600))
+	}
+
+	tokens, err := utils.NewJWTManager(config.Server{JwtKeysDir: dir})
+	require.NoError(t, err)
+	oldTokens, err := utils.NewJWTManager(config.Server{JwtKeysDir: dir, JwtSigningKeyID: "2023-01"})
+	require.NoError(t, err)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, nil, nil, nil, nil, tokens, nil)
+	account := &types.Account{ID: uuid.New()}
+
+	t.Run("Rotation", func(t *testing.T) {
+		// the last key signs, tokens of the old key are still valid
+		token, err := tokens.CreateJWT(account)
+		require.NoError(t, err)
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.RegisteredClaims{})
+		require.NoError(t, err)
+		require.Equal(t, "2023-06", parsed.Header["kid"])
+		require.Equal(t, "EdDSA", parsed.Header["alg"])
+
+		oldToken, err := oldTokens.CreateJWT(account)
+		require.NoError(t, err)
+		claims, err := tokens.ValidateJWT(oldToken)
+		require.NoError(t, err)
+		require.Equal(t, account.ID.String(), claims.Subject)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+		require.NoError(t, server.getJWKS(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		jwks := &types.JWKS{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(jwks))
+		require.Len(t, jwks.Keys, 2)
+		require.Equal(t, "RSA", jwks.Keys[0].Kty)
+		require.Equal(t, "RS256", jwks.Keys[0].Alg)
+		require.Equal(t, "AQAB", jwks.Keys[0].E)
+		require.Equal(t, "OKP", jwks.Keys[1].Kty)
+		require.Equal(t, "Ed25519", jwks.Keys[1].Crv)
+		require.Equal(t, base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)), jwks.Keys[1].X)
+	})
+
+	t.Run("Secret", func(t *testing.T) {
+		// the HS256 secret isn't published
+		require.Empty(t, testTokens(t).JWKS().Keys)
+	})
+}
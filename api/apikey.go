@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+)
+
+// createAPIKey godoc
+// @Summary Create API key
+// @Description create merchant API key, returns the key and the signing secret of secret keys, which aren't shown again
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "account id"
+// @Param input body types.RequestAPIKey true "create api key info"
+// @Success 200 {object} types.APIKeyResponse
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/keys [post]
+func (s *JSONApiServer) createAPIKey(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.createAPIKey")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestAPIKey{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	// validate request
+	if err := utils.ValidateAPIKeyRequest(req); err != nil {
+		return s.writeError(w, err)
+	}
+	key, apiKey, err := utils.CreateAPIKey(id, req.Type, req.Mode)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	apiKey, err = s.storage.SaveAPIKey(ctx, apiKey)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, &types.APIKeyResponse{
+		APIKey:        apiKey,
+		Key:           key,
+		SigningSecret: apiKey.SigningSecret,
+	})
+}
+
+// getAPIKeys godoc
+// @Summary Get API keys
+// @Description get merchant API keys including revoked ones, returns keys without secrets
+// @Tags Account
+// @Produce json
+// @Param id path string true "account id"
+// @Success 200 {array} types.APIKey
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/keys [get]
+func (s *JSONApiServer) getAPIKeys(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.getAPIKeys")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	keys, err := s.storage.GetAPIKeys(ctx, id)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, keys)
+}
+
+// revokeAPIKey godoc
+// @Summary Revoke API key
+// @Description revoke merchant API key, returns revoked key
+// @Tags Account
+// @Produce json
+// @Param id path string true "account id"
+// @Param key_id path string true "api key id"
+// @Success 200 {object} types.APIKey
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/keys/{key_id} [delete]
+func (s *JSONApiServer) revokeAPIKey(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.revokeAPIKey")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	keyId, err := uuid.Parse(mux.Vars(r)["key_id"])
+	if err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	apiKey, err := s.storage.RevokeAPIKey(ctx, id, keyId)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, apiKey)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CreateAPIKey(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	id := uuid.New()
+	newRequest := func(body string) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/account/"+id.String()+"/keys", strings.NewReader(body))
+		return mux.SetURLVars(request, map[string]string{"id": id.String()})
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		mockStorage.EXPECT().SaveAPIKey(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ any, apiKey *types.APIKey) (*types.APIKey, error) {
+				require.Equal(t, id, apiKey.AccountID)
+				require.Equal(t, "pk_live_", apiKey.Prefix)
+				apiKey.ID = uuid.New()
+				return apiKey, nil
+			})
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.createAPIKey(recorder, newRequest(`{"type":"publishable","mode":"live"}`)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		response := &types.APIKeyResponse{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(response))
+		require.True(t, strings.HasPrefix(response.Key, "pk_live_"))
+		require.Equal(t, response.Key[len(response.Key)-4:], response.Last4)
+		require.Empty(t, response.Hash)
+		require.Empty(t, response.SigningSecret)
+		keyType, mode, err := utils.ParseAPIKey(response.Key)
+		require.NoError(t, err)
+		require.Equal(t, types.APIKeyPublishable, keyType)
+		require.Equal(t, types.APIKeyModeLive, mode)
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.createAPIKey(recorder, newRequest(`{"mode":"sandbox"}`)))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	// every balance is live, test keys would never work
+	t.Run("Test mode", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.createAPIKey(recorder, newRequest(`{"type":"secret","mode":"test"}`)))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), "invalid api key mode")
+	})
+}
+
+func Test_RevokeAPIKey(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	id, keyId := uuid.New(), uuid.New()
+	request := httptest.NewRequest(http.MethodDelete, "/account/"+id.String()+"/keys/"+keyId.String(), nil)
+	request = mux.SetURLVars(request, map[string]string{"id": id.String(), "key_id": keyId.String()})
+
+	mockStorage.EXPECT().RevokeAPIKey(gomock.Any(), id, keyId).Return(nil, sql.ErrNoRows)
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.revokeAPIKey(recorder, request))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+}
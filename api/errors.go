@@ -0,0 +1,103 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/Edbeer/paymentapi/pkg/fx"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Error codes of the api
+const (
+	codeInvalidTransition = "invalid_transition"
+	codeRefundExceeded    = "refund_exceeds_captured"
+	codeAmountExceeded    = "amount_exceeds_authorized"
+	codeExpired           = "authorization_expired"
+	codeValidation        = "validation_failed"
+	codeLocked            = "account_locked"
+	codeIdempotencyKey    = "idempotency_key_reused"
+)
+
+// HTTP status of the error codes
+var errorStatus = map[string]int{
+	types.CodeInvalid:           http.StatusBadRequest,
+	codeValidation:              http.StatusBadRequest,
+	types.CodeUnauthorized:      http.StatusUnauthorized,
+	types.CodeForbidden:         http.StatusForbidden,
+	types.CodeNotFound:          http.StatusNotFound,
+	types.CodeConflict:          http.StatusConflict,
+	codeInvalidTransition:       http.StatusConflict,
+	codeExpired:                 http.StatusConflict,
+	types.CodeInsufficientFunds: http.StatusUnprocessableEntity,
+	types.CodeCardDeclined:      http.StatusUnprocessableEntity,
+	codeRefundExceeded:          http.StatusUnprocessableEntity,
+	codeAmountExceeded:          http.StatusUnprocessableEntity,
+	codeIdempotencyKey:          http.StatusUnprocessableEntity,
+	codeLocked:                  http.StatusLocked,
+	types.CodeInternal:          http.StatusInternalServerError,
+}
+
+// Domain error of err: invalid requests, invalid transitions of the state machine,
+// declines and missing rows get their codes, unknown errors are internal
+func domainError(err error) *types.Error {
+	var (
+		domainErr     *types.Error
+		fieldErrs     utils.ValidationErrors
+		transitionErr *types.TransitionError
+		declineErr    *types.DeclineError
+	)
+	switch {
+	case errors.As(err, &domainErr):
+		return domainErr
+	case errors.As(err, &fieldErrs):
+		return types.NewError(codeValidation, err.Error())
+	case errors.As(err, &transitionErr):
+		return types.NewError(codeInvalidTransition, err.Error())
+	case errors.As(err, &declineErr):
+		return types.NewError(declineErr.Code, err.Error())
+	case errors.Is(err, fx.ErrNoRate):
+		return types.NewError(types.CodeInvalid, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		return types.NewError(types.CodeNotFound, "not found")
+	}
+	return types.WrapError(types.CodeInternal, "internal error", err)
+}
+
+// Writes the error with the status of its code. Internal errors are logged,
+// clients only get the code
+func (s *JSONApiServer) writeError(w http.ResponseWriter, err error) error {
+	domainErr := domainError(err)
+	status, ok := errorStatus[domainErr.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	apiErr := ApiError{Error: domainErr.Message, Code: domainErr.Code}
+	if status >= http.StatusInternalServerError {
+		s.log().WithError(err).Error("internal error")
+		apiErr.Error = "internal error"
+	}
+	var fieldErrs utils.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		apiErr.Details = fieldErrs
+	}
+	var declineErr *types.DeclineError
+	if errors.As(err, &declineErr) {
+		apiErr.Payment = &types.PaymentResponse{
+			ID:     declineErr.Payment.ID,
+			Status: declineErr.Payment.Status,
+			Reason: declineErr.Payment.Reason,
+		}
+	}
+	return WriteJSON(w, status, apiErr)
+}
+
+func (s *JSONApiServer) log() logrus.FieldLogger {
+	if s.logger == nil {
+		return logrus.StandardLogger()
+	}
+	return s.logger
+}
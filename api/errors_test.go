@@ -0,0 +1,112 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WriteError(t *testing.T) {
+	t.Parallel()
+
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		msg    string
+	}{
+		{"Invalid", types.InvalidError(errors.New("unexpected EOF")), http.StatusBadRequest, types.CodeInvalid, "unexpected EOF"},
+		{"Validation", utils.ValidationErrors{{Field: "amount", Code: utils.CodeRequired, Message: "is required"}}, http.StatusBadRequest, codeValidation, "amount: is required"},
+		{"Unauthorized", errAccessToken, http.StatusUnauthorized, types.CodeUnauthorized, errAccessToken.Error()},
+		{"Forbidden", errForbidden, http.StatusForbidden, types.CodeForbidden, errForbidden.Error()},
+		{"Not found", errPayment, http.StatusNotFound, types.CodeNotFound, errPayment.Error()},
+		{"No rows", fmt.Errorf("get payment: %w", sql.ErrNoRows), http.StatusNotFound, types.CodeNotFound, "not found"},
+		{"Conflict", errTOTPEnabled, http.StatusConflict, types.CodeConflict, errTOTPEnabled.Error()},
+		{"Transition", &types.TransitionError{Operation: types.OperationCancel, From: types.StatusCaptured, To: types.StatusVoided}, http.StatusConflict, codeInvalidTransition, ""},
+		{"Insufficient funds", errBlockedMoney, http.StatusUnprocessableEntity, types.CodeInsufficientFunds, errBlockedMoney.Error()},
+		{"Declined", &types.DeclineError{Code: types.CodeInsufficientFunds, Payment: &types.Payment{Reason: types.ReasonInsufficientFunds}}, http.StatusUnprocessableEntity, types.CodeInsufficientFunds, "payment declined: Insufficient funds"},
+		{"Card declined", &types.DeclineError{Code: types.CodeCardDeclined, Payment: &types.Payment{Reason: types.ReasonWrongRequest}}, http.StatusUnprocessableEntity, types.CodeCardDeclined, "payment declined: wrong payment request"},
+		{"Locked", errLocked, http.StatusLocked, codeLocked, errLocked.Error()},
+		{"Internal", errors.New("pq: connection refused"), http.StatusInternalServerError, types.CodeInternal, "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			require.NoError(t, server.writeError(recorder, tt.err))
+			require.Equal(t, tt.status, recorder.Code)
+
+			apiErr := ApiError{}
+			require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+			require.Equal(t, tt.code, apiErr.Code)
+			if tt.msg != "" {
+				require.Equal(t, tt.msg, apiErr.Error)
+			}
+		})
+	}
+
+	t.Run("Internal cause is hidden", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		err := types.WrapError(types.CodeInternal, "internal error", errors.New("pq: password authentication failed"))
+		require.NoError(t, server.writeError(recorder, err))
+		require.Equal(t, http.StatusInternalServerError, recorder.Code)
+		require.NotContains(t, recorder.Body.String(), "pq:")
+	})
+
+	t.Run("Validation details", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		err := utils.ValidationErrors{{Field: "currency", Code: utils.CodeInvalid, Message: "invalid currency"}}
+		require.NoError(t, server.writeError(recorder, err))
+
+		apiErr := ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+		require.Equal(t, []utils.FieldError(err), apiErr.Details)
+	})
+
+	t.Run("Declined payment", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		payment := &types.Payment{ID: uuid.New(), Status: types.StatusFailed, Reason: types.ReasonInsufficientFunds}
+		require.NoError(t, server.writeError(recorder, &types.DeclineError{Code: types.CodeInsufficientFunds, Payment: payment}))
+
+		apiErr := ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+		require.Equal(t, &types.PaymentResponse{ID: payment.ID, Status: payment.Status, Reason: payment.Reason}, apiErr.Payment)
+	})
+}
+
+func Test_HTTPHandler(t *testing.T) {
+	t.Parallel()
+
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	t.Run("Error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		server.HTTPHandler(func(w http.ResponseWriter, r *http.Request) error {
+			return errSession
+		})(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errSession.Error())
+	})
+
+	t.Run("Error after response", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		server.HTTPHandler(func(w http.ResponseWriter, r *http.Request) error {
+			WriteJSON(w, http.StatusOK, "ok")
+			return errors.New("write: broken pipe")
+		})(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "\"ok\"\n", recorder.Body.String())
+	})
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Edbeer/paymentapi/pkg/db/psql"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Release holds of expired authorizations every interval until ctx is done,
+// zero interval disables the worker. Workers of several replicas lock
+// different authorizations
+func (s *JSONApiServer) RunExpiry(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := s.expireAuthorizations(ctx)
+			if err != nil {
+				s.logger.Errorf("expire authorizations: %v", err)
+			}
+			if expired > 0 {
+				s.logger.Infof("expired %d authorizations", expired)
+			}
+		}
+	}
+}
+
+// failed authorizations are skipped for an hour
+const expiryRetryDelay = time.Hour
+
+// Authorization that was locked but failed to expire
+type expiryError struct {
+	paymentID uuid.UUID
+	err       error
+}
+
+func (e *expiryError) Error() string {
+	return fmt.Sprintf("expire authorization %s: %v", e.paymentID, e.err)
+}
+
+func (e *expiryError) Unwrap() error {
+	return e.err
+}
+
+// Expire authorizations one by one, each in its own transaction.
+// An authorization that fails is skipped, otherwise it stays the oldest
+// one and blocks the later ones on every tick
+func (s *JSONApiServer) expireAuthorizations(ctx context.Context) (int, error) {
+	expired := 0
+	for ctx.Err() == nil {
+		now := time.Now()
+		ok, err := s.expireAuthorization(ctx, now)
+		var expiryErr *expiryError
+		if errors.As(err, &expiryErr) && ctx.Err() == nil {
+			attempts, err := s.storage.DeferExpiry(ctx, expiryErr.paymentID, now.Add(expiryRetryDelay))
+			if err != nil {
+				return expired, err
+			}
+			s.log().WithError(expiryErr.err).WithFields(logrus.Fields{
+				"payment_id": expiryErr.paymentID,
+				"attempts":   attempts,
+			}).Error("authorization isn't expired, retry later")
+			continue
+		}
+		if err != nil || !ok {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// Void the rest of the oldest expired authorization, false if there is none
+func (s *JSONApiServer) expireAuthorization(ctx context.Context, now time.Time) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Payment.expireAuthorization")
+	defer span.Finish()
+
+	found := false
+	var paymentID uuid.UUID
+	err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		found, paymentID = false, uuid.Nil
+		authorization, err := s.storage.LockExpiredPayment(ctx, tx, now)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		found, paymentID = true, authorization.ID
+		buyerId := authorization.AccountId
+		if buyerId == uuid.Nil {
+			return errBuyer
+		}
+		merchantId := authorization.BusinessId
+		release, releaseSettlement := authorization.Remaining(), authorization.RemainingSettlement()
+		if err := authorization.Apply(types.OperationExpire, release); err != nil {
+			return err
+		}
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			buyerId:    authorization.Currency,
+			merchantId: authorization.SettlementCurrency,
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
+			}
+			return err
+		}
+		personalBalance, merchantBalance := balances[buyerId], balances[merchantId]
+		if personalBalance.BlockedMoney < release || merchantBalance.BlockedMoney < releaseSettlement {
+			return errBlockedMoney
+		}
+		authorization.ReleasedAmount += release
+		authorization.SettledAmount += releaseSettlement
+		authorization, err = s.storage.UpdatePayment(ctx, tx, authorization)
+		if err != nil {
+			return err
+		}
+		expiredPayment := types.CreateCompletePayment(&types.PaidRequest{
+			OrderId:   authorization.OrderId,
+			PaymentId: authorization.ID,
+			Operation: types.OperationExpire,
+			Amount:    release,
+		}, authorization, types.StatusExpired)
+		expiredPayment.SettlementAmount = releaseSettlement
+		expiredPayment, err = s.storage.SavePayment(ctx, tx, expiredPayment)
+		if err != nil {
+			return err
+		}
+		// return blocked money to the buyer
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance:      int64(release),
+			BlockedMoney: -int64(release),
+		}); err != nil {
+			return err
+		}
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			BlockedMoney: -int64(releaseSettlement),
+		}); err != nil {
+			return err
+		}
+		// ledger: expired hold is released like a cancel
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.CancelEntry(expiredPayment, buyerId, merchantId)); err != nil {
+			return err
+		}
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, expiredPayment.ID); err != nil {
+			return err
+		}
+		_, err = s.storage.UpdateStatement(ctx, tx, merchantId, expiredPayment.ID)
+		return err
+	})
+	if err != nil && found {
+		return found, &expiryError{paymentID: paymentID, err: err}
+	}
+	return found, err
+}
+
+// Authorization lifetime: merchant setting, then config, then default
+func (s *JSONApiServer) authorizationTTL(merchant *types.Account) time.Duration {
+	if merchant.AuthorizationTTL > 0 {
+		return time.Duration(merchant.AuthorizationTTL) * time.Hour
+	}
+	if s.config.Expiry.AuthorizationTTL > 0 {
+		return time.Duration(s.config.Expiry.AuthorizationTTL) * time.Hour
+	}
+	return types.DefaultAuthorizationTTL
+}
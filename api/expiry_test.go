@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExpireAuthorization(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+	now := time.Now()
+
+	t.Run("Expire", func(t *testing.T) {
+		uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+		expiresAt := now.Add(-time.Minute)
+		authorization := &types.Payment{
+			ID:                 pid,
+			BusinessId:         mid,
+			AccountId:          uid,
+			Operation:          types.OperationAuthorization,
+			Amount:             50,
+			Status:             types.StatusPartiallyCaptured,
+			Currency:           "RUB",
+			CardToken:          "tok_4242424242424242",
+			SettlementAmount:   50,
+			SettlementCurrency: "RUB",
+			FxRate:             "1",
+			CapturedAmount:     20,
+			SettledAmount:      20,
+			ExpiresAt:          &expiresAt,
+		}
+		buyerBalance := &types.Balance{AccountID: uid, Currency: "RUB", BlockedMoney: 30}
+		merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", Balance: 20, BlockedMoney: 30}
+
+		mock.ExpectBegin()
+		mockStorage.EXPECT().LockExpiredPayment(gomock.Any(), gomock.Any(), now).Return(authorization, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "RUB",
+			mid: "RUB",
+		}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+		mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), authorization).Return(authorization, nil)
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.OperationExpire, payment.Operation)
+				require.Equal(t, types.StatusExpired, payment.Status)
+				require.Equal(t, uint64(30), payment.Amount)
+				require.Equal(t, pid, payment.ReferenceId)
+				return payment, nil
+			})
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+			Balance:      30,
+			BlockedMoney: -30,
+		}).Return(buyerBalance, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+			BlockedMoney: -30,
+		}).Return(merchantBalance, nil)
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				require.True(t, entry.Balanced())
+				return entry, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), uid, gomock.Any()).Return(&types.Account{}, nil)
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(&types.Account{}, nil)
+		mock.ExpectCommit()
+
+		found, err := server.expireAuthorization(context.Background(), now)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, types.StatusExpired, authorization.Status)
+		require.Equal(t, uint64(30), authorization.ReleasedAmount)
+		require.Equal(t, uint64(0), authorization.Remaining())
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Nothing expired", func(t *testing.T) {
+		mock.ExpectBegin()
+		mockStorage.EXPECT().LockExpiredPayment(gomock.Any(), gomock.Any(), now).Return(nil, sql.ErrNoRows)
+		mock.ExpectCommit()
+
+		found, err := server.expireAuthorization(context.Background(), now)
+		require.NoError(t, err)
+		require.False(t, found)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_ExpireAuthorizations(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid := uuid.New(), uuid.New()
+	expiresAt := time.Now().Add(-time.Hour)
+	authorization := func(buyerId uuid.UUID) *types.Payment {
+		return &types.Payment{
+			ID:                 uuid.New(),
+			BusinessId:         mid,
+			AccountId:          buyerId,
+			Operation:          types.OperationAuthorization,
+			Amount:             50,
+			Status:             types.StatusAuthorized,
+			Currency:           "RUB",
+			CardToken:          "tok_4242424242424242",
+			SettlementAmount:   50,
+			SettlementCurrency: "RUB",
+			FxRate:             "1",
+			ExpiresAt:          &expiresAt,
+		}
+	}
+	// the buyer of the oldest authorization is unknown
+	broken, next := authorization(uuid.Nil), authorization(uid)
+	buyerBalance := &types.Balance{AccountID: uid, Currency: "RUB", BlockedMoney: 50}
+	merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", BlockedMoney: 50}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	gomock.InOrder(
+		mockStorage.EXPECT().LockExpiredPayment(gomock.Any(), gomock.Any(), gomock.Any()).Return(broken, nil),
+		mockStorage.EXPECT().DeferExpiry(gomock.Any(), broken.ID, gomock.Any()).DoAndReturn(
+			func(_ any, _ uuid.UUID, retryAt time.Time) (int, error) {
+				require.WithinDuration(t, time.Now().Add(expiryRetryDelay), retryAt, time.Minute)
+				return 1, nil
+			}),
+		mockStorage.EXPECT().LockExpiredPayment(gomock.Any(), gomock.Any(), gomock.Any()).Return(next, nil),
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil),
+		mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), next).Return(next, nil),
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				return payment, nil
+			}),
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, gomock.Any()).Return(buyerBalance, nil),
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, gomock.Any()).Return(merchantBalance, nil),
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				return entry, nil
+			}),
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), uid, gomock.Any()).Return(&types.Account{}, nil),
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(&types.Account{}, nil),
+		mockStorage.EXPECT().LockExpiredPayment(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, sql.ErrNoRows),
+	)
+
+	expired, err := server.expireAuthorizations(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, expired)
+	require.Equal(t, types.StatusAuthorized, broken.Status)
+	require.Equal(t, types.StatusExpired, next.Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_AuthorizationTTL(t *testing.T) {
+	t.Parallel()
+
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	require.Equal(t, types.DefaultAuthorizationTTL, server.authorizationTTL(&types.Account{}))
+
+	server.config.Expiry.AuthorizationTTL = 48
+	require.Equal(t, 48*time.Hour, server.authorizationTTL(&types.Account{}))
+	require.Equal(t, 24*time.Hour, server.authorizationTTL(&types.Account{AuthorizationTTL: 24}))
+}
@@ -1,37 +1,314 @@
 package api
+
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Edbeer/paymentapi/client"
 	"github.com/Edbeer/paymentapi/pkg/utils"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 // auth middleware
-func AuthJWT(next http.HandlerFunc) http.HandlerFunc {
+func (s *JSONApiServer) AuthJWT(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("x-jwt-token")
-		token, err := utils.ValidateJWT(tokenString)
+		claims, err := s.tokens.ValidateJWT(tokenString)
+		if err != nil {
+			s.writeError(w, errAccessToken)
+			return
+		}
+
+		subject, err := uuid.Parse(claims.Subject)
 		if err != nil {
-			WriteJSON(w, http.StatusBadRequest, "permission denied")
+			s.writeError(w, errAccessToken)
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
+		next(w, r.WithContext(context.WithValue(r.Context(), accountContextKey, subject)))
+	}
+}
+
+var errForbidden = types.NewError(types.CodeForbidden, "permission denied")
+
+// role middleware after AuthJWT: the role of the token account must have
+// the permission on the account of the route, the token account by default
+func (s *JSONApiServer) Authorize(next http.HandlerFunc, permission types.Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := getAccountID(r)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		user, err := s.storage.GetAccountByID(ctx, id)
+		if err != nil {
+			s.writeError(w, errAccessToken)
+			return
+		}
+
+		own := true
+		if _, ok := mux.Vars(r)["id"]; ok {
+			uid, err := GetUUID(r)
+			if err != nil {
+				s.writeError(w, err)
+				return
+			}
+			own = uid == id
+		}
+		if !types.Can(user.Role, permission, own) {
+			s.writeError(w, errForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(ctx, userContextKey, user)))
+	}
+}
+
+// Account of the access token loaded by Authorize
+func getUser(r *http.Request) (*types.Account, error) {
+	user, ok := r.Context().Value(userContextKey).(*types.Account)
+	if !ok {
+		return nil, errAccessToken
+	}
+	return user, nil
+}
+
+type contextKey int
+
+const (
+	// merchant account authenticated by the API key
+	merchantContextKey contextKey = iota
+	// the API key itself
+	apiKeyContextKey
+	// account id of the access token
+	accountContextKey
+	// account of the access token with its role
+	userContextKey
+)
+
+// API key middleware: the bearer key of one of the types is resolved
+// to the merchant account, which is put into the request context
+func (s *JSONApiServer) AuthAPIKey(next http.HandlerFunc, keyTypes ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
 		if !ok {
-			WriteJSON(w, http.StatusBadRequest, "permission denied")
+			s.writeError(w, errAPIKey)
+			return
+		}
+		keyType, _, err := utils.ParseAPIKey(key)
+		if err != nil {
+			s.writeError(w, errAPIKey)
+			return
+		}
+		allowed := false
+		for _, t := range keyTypes {
+			allowed = allowed || t == keyType
+		}
+		if !allowed {
+			s.writeError(w, errForbidden)
 			return
 		}
-		uid, err := GetUUID(r)
+		ctx := r.Context()
+		apiKey, err := s.storage.GetAPIKeyByHash(ctx, utils.HashAPIKey(key))
 		if err != nil {
-			WriteJSON(w, http.StatusBadRequest, "permission denied")
+			s.writeError(w, errAPIKey)
 			return
 		}
+		merchant, err := s.storage.GetAccountByID(ctx, apiKey.AccountID)
+		if err != nil {
+			s.writeError(w, errAPIKey)
+			return
+		}
+		if !types.Can(merchant.Role, types.PermissionAcceptPayments, true) {
+			s.writeError(w, errForbidden)
+			return
+		}
+		ctx = context.WithValue(ctx, merchantContextKey, merchant)
+		next(w, r.WithContext(context.WithValue(ctx, apiKeyContextKey, apiKey)))
+	}
+}
 
-		if claims["id"] != uid.String() {
-			WriteJSON(w, http.StatusBadRequest, "permission denied")
+var (
+	errSignature        = types.NewError(types.CodeUnauthorized, "invalid request signature")
+	errSigningSecret    = types.NewError(types.CodeUnauthorized, "api key has no signing secret, create a new key")
+	errSignatureExpired = types.NewError(types.CodeUnauthorized, "request signature is expired")
+	errReplay           = types.NewError(types.CodeUnauthorized, "request nonce is already used")
+)
+
+// accepted clock skew of signed requests in seconds if the config doesn't set it
+const defaultSignatureWindow = 300
+
+// signature middleware: requests of secret keys must be signed with the key
+// signing secret within the window around the server time, every nonce is
+// accepted once. Publishable keys are used by browsers and can't sign
+func (s *JSONApiServer) VerifySignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey, ok := r.Context().Value(apiKeyContextKey).(*types.APIKey)
+		if !ok {
+			s.writeError(w, errAPIKey)
 			return
 		}
+		if apiKey.Type != types.APIKeySecret {
+			next(w, r)
+			return
+		}
+		if apiKey.SigningSecret == "" {
+			s.writeError(w, errSigningSecret)
+			return
+		}
+		timestamp, err := strconv.ParseInt(r.Header.Get(client.HeaderTimestamp), 10, 64)
+		nonce := r.Header.Get(client.HeaderNonce)
+		if err != nil || nonce == "" {
+			s.writeError(w, errSignature)
+			return
+		}
+		window := s.signatureWindow()
+		if skew := time.Now().Unix() - timestamp; skew > window || skew < -window {
+			s.writeError(w, errSignatureExpired)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
 
+		signature := client.Signature(apiKey.SigningSecret, r.Method, r.URL.Path, timestamp, nonce, body)
+		if !hmac.Equal([]byte(signature), []byte(r.Header.Get(client.HeaderSignature))) {
+			s.writeError(w, errSignature)
+			return
+		}
+		// the nonce outlives the window, older requests are rejected by the timestamp
+		ok, err = s.redisStorage.SaveNonce(r.Context(), "nonce:"+apiKey.ID.String()+":"+nonce, int(2*window))
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if !ok {
+			s.writeError(w, errReplay)
+			return
+		}
 		next(w, r)
 	}
-}
\ No newline at end of file
+}
+
+func (s *JSONApiServer) signatureWindow() int64 {
+	if s.config.Signature.Window > 0 {
+		return int64(s.config.Signature.Window)
+	}
+	return defaultSignatureWindow
+}
+
+// idempotency key lifetime in seconds
+const idempotencyKeyExpire = 86400
+
+var (
+	errIdempotencyKey        = types.NewError(codeIdempotencyKey, "idempotency key is already used with another request")
+	errIdempotencyInProgress = types.NewError(types.CodeConflict, "request with this idempotency key is in progress")
+)
+
+// idempotency middleware: the first response for an Idempotency-Key
+// is stored and replayed for retries with the same request body
+func (s *JSONApiServer) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			next(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		key := "idempotency:"
+		if merchant, err := getMerchant(r); err == nil {
+			key += merchant.ID.String()
+		} else if id, err := getAccountID(r); err == nil {
+			key += id.String()
+		}
+		key += ":" + idempotencyKey
+		fingerprint := requestFingerprint(r, body)
+		record, err := s.redisStorage.StartIdempotentRequest(ctx, key, fingerprint, idempotencyKeyExpire)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				s.writeError(w, errIdempotencyKey)
+			case record.InProgress():
+				s.writeError(w, errIdempotencyInProgress)
+			default:
+				w.Header().Add("Content-Type", "application/json")
+				w.Header().Add("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			// server errors and panics are not stored, the request can be retried
+			if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+				s.redisStorage.DeleteIdempotentRequest(ctx, key)
+				return
+			}
+			s.redisStorage.FinishIdempotentRequest(ctx, key, &types.IdempotentRecord{
+				Fingerprint: fingerprint,
+				Status:      recorder.status,
+				Body:        recorder.body.Bytes(),
+			}, idempotencyKeyExpire)
+		}()
+		next(recorder, r)
+	}
+}
+
+// hash of the request method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Response writer that keeps a copy of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
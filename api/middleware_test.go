@@ -0,0 +1,462 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/client"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Idempotent(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	config := &config.Config{}
+	server := NewJSONApiServer(config, nil, nil, nil, mockRedis, nil, nil, nil, nil, nil)
+
+	body := []byte(`{"order_id":"1","amount":50}`)
+	calls := 0
+	handler := server.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSON(w, http.StatusOK, types.PaymentResponse{Status: "Approved"})
+	})
+	newRequest := func(body []byte) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/payment/auth", bytes.NewReader(body))
+		request.Header.Set("Idempotency-Key", "key")
+		return request
+	}
+	key := "idempotency::key"
+	fingerprint := requestFingerprint(newRequest(body), body)
+
+	t.Run("First request", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, fingerprint, idempotencyKeyExpire).Return(nil, nil)
+		mockRedis.EXPECT().FinishIdempotentRequest(gomock.Any(), key, gomock.Any(), idempotencyKeyExpire).DoAndReturn(
+			func(_ any, _ string, record *types.IdempotentRecord, _ int) error {
+				require.Equal(t, http.StatusOK, record.Status)
+				require.Equal(t, recorder.Body.Bytes(), record.Body)
+				return nil
+			})
+
+		handler(recorder, newRequest(body))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, fingerprint, idempotencyKeyExpire).Return(&types.IdempotentRecord{
+			Fingerprint: fingerprint,
+			Status:      http.StatusOK,
+			Body:        []byte(`{"status":"Approved"}`),
+		}, nil)
+
+		handler(recorder, newRequest(body))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+		require.Equal(t, `{"status":"Approved"}`, recorder.Body.String())
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("In progress", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, fingerprint, idempotencyKeyExpire).Return(&types.IdempotentRecord{
+			Fingerprint: fingerprint,
+		}, nil)
+
+		handler(recorder, newRequest(body))
+		require.Equal(t, http.StatusConflict, recorder.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Another body", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		otherBody := []byte(`{"order_id":"1","amount":60}`)
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, requestFingerprint(newRequest(otherBody), otherBody), idempotencyKeyExpire).Return(&types.IdempotentRecord{
+			Fingerprint: fingerprint,
+			Status:      http.StatusOK,
+		}, nil)
+
+		handler(recorder, newRequest(otherBody))
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		require.Equal(t, 1, calls)
+	})
+}
+
+// request authenticated by the merchant API key
+func withMerchant(r *http.Request, merchant *types.Account) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), merchantContextKey, merchant))
+}
+
+// access tokens signed with the HS256 secret
+func testTokens(t *testing.T) *utils.JWTManager {
+	tokens, err := utils.NewJWTManager(config.Server{JwtSecretKey: "secret"})
+	require.NoError(t, err)
+	return tokens
+}
+
+func Test_AuthJWT(t *testing.T) {
+	t.Parallel()
+
+	tokens := testTokens(t)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, nil, nil, nil, nil, tokens, nil)
+	account := &types.Account{ID: uuid.New()}
+
+	handler := server.AuthJWT(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(id uuid.UUID, token string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/account/"+id.String(), nil)
+		request.Header.Set("x-jwt-token", token)
+		request = mux.SetURLVars(request, map[string]string{"id": id.String()})
+		handler(recorder, request)
+		return recorder
+	}
+	// token signed with the test secret
+	sign := func(claims jwt.Claims, method jwt.SigningMethod) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "secret"
+		tokenString, err := token.SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return tokenString
+	}
+	now := time.Now()
+	claims := func() jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   account.ID.String(),
+			Issuer:    "paymentapi",
+			Audience:  jwt.ClaimStrings{"paymentapi"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		token, err := tokens.CreateJWT(account)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, serve(account.ID, token).Code)
+
+		parsed, err := tokens.ValidateJWT(token)
+		require.NoError(t, err)
+		require.Equal(t, account.ID.String(), parsed.Subject)
+		require.NotEmpty(t, parsed.ID)
+		require.NotNil(t, parsed.NotBefore)
+		require.WithinDuration(t, now.Add(900*time.Second), parsed.ExpiresAt.Time, 5*time.Second)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := claims()
+		expired.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		require.Equal(t, http.StatusUnauthorized, serve(account.ID, sign(expired, jwt.SigningMethodHS256)).Code)
+	})
+
+	t.Run("No expiry", func(t *testing.T) {
+		noExpiry := claims()
+		noExpiry.ExpiresAt = nil
+		require.Equal(t, http.StatusUnauthorized, serve(account.ID, sign(noExpiry, jwt.SigningMethodHS256)).Code)
+	})
+
+	t.Run("Wrong audience", func(t *testing.T) {
+		wrong := claims()
+		wrong.Audience = jwt.ClaimStrings{"gateway"}
+		require.Equal(t, http.StatusUnauthorized, serve(account.ID, sign(wrong, jwt.SigningMethodHS256)).Code)
+	})
+
+	t.Run("Wrong method", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve(account.ID, sign(claims(), jwt.SigningMethodHS512)).Code)
+	})
+
+	t.Run("Legacy token", func(t *testing.T) {
+		legacy := sign(jwt.MapClaims{"id": account.ID.String(), "expire_at": 15000}, jwt.SigningMethodHS256)
+		require.Equal(t, http.StatusUnauthorized, serve(account.ID, legacy).Code)
+	})
+}
+
+func Test_AuthAPIKey(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	merchant := &types.Account{ID: uuid.New(), Role: types.RoleMerchant}
+	key, apiKey, err := utils.CreateAPIKey(merchant.ID, types.APIKeySecret, types.APIKeyModeLive)
+	require.NoError(t, err)
+	require.Regexp(t, "^sk_live_[0-9a-f]{48}$", key)
+	publishableKey, _, err := utils.CreateAPIKey(merchant.ID, types.APIKeyPublishable, types.APIKeyModeLive)
+	require.NoError(t, err)
+
+	var authenticated *types.Account
+	handler := server.AuthAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		authenticated, err = getMerchant(r)
+		require.NoError(t, err)
+		WriteJSON(w, http.StatusOK, nil)
+	}, types.APIKeySecret)
+	newRequest := func(key string) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/payment/capture", nil)
+		if key != "" {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+		return request
+	}
+
+	t.Run("Secret key", func(t *testing.T) {
+		mockStorage.EXPECT().GetAPIKeyByHash(gomock.Any(), utils.HashAPIKey(key)).Return(apiKey, nil)
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), merchant.ID).Return(merchant, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(key))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, merchant, authenticated)
+	})
+
+	t.Run("No key", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(""))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("Publishable key", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(publishableKey))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+
+	t.Run("Revoked key", func(t *testing.T) {
+		mockStorage.EXPECT().GetAPIKeyByHash(gomock.Any(), utils.HashAPIKey(key)).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(key))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("Not a merchant", func(t *testing.T) {
+		customer := &types.Account{ID: merchant.ID, Role: types.RoleCustomer}
+		mockStorage.EXPECT().GetAPIKeyByHash(gomock.Any(), utils.HashAPIKey(key)).Return(apiKey, nil)
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), merchant.ID).Return(customer, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(key))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+}
+
+func Test_AuthAPIKeyTestMode(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// keys created as test keys before aren't looked up,
+	// balances, payments and the ledger aren't expected to be touched
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	_, _, err := utils.CreateAPIKey(uuid.New(), types.APIKeySecret, "test")
+	require.Error(t, err)
+	key := "sk_test_" + strings.Repeat("0", 48)
+
+	buffer, err := utils.AnyToBytesBuffer(&types.PaymentRequest{
+		AccountId:        uuid.New(),
+		CardNumber:       "4242424242424242",
+		CardExpiryMonth:  "12",
+		CardExpiryYear:   "30",
+		CardSecurityCode: "924",
+		Currency:         "RUB",
+		Amount:           100,
+	})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/payment/auth", buffer)
+	request.Header.Set("Authorization", "Bearer "+key)
+
+	recorder := httptest.NewRecorder()
+	server.AuthAPIKey(server.HTTPHandler(server.createPayment), types.APIKeySecret)(recorder, request)
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	require.Contains(t, recorder.Body.String(), errAPIKey.Error())
+}
+
+func Test_Authorize(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	var user *types.Account
+	newHandler := func(permission types.Permission) http.HandlerFunc {
+		return server.Authorize(func(w http.ResponseWriter, r *http.Request) {
+			user, _ = getUser(r)
+			w.WriteHeader(http.StatusOK)
+		}, permission)
+	}
+	// request of the token account to the route of the account
+	serve := func(handler http.HandlerFunc, account *types.Account, id uuid.UUID) int {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), account.ID).Return(account, nil)
+		request := httptest.NewRequest(http.MethodGet, "/account/"+id.String(), nil)
+		request = request.WithContext(context.WithValue(request.Context(), accountContextKey, account.ID))
+		request = mux.SetURLVars(request, map[string]string{"id": id.String()})
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder.Code
+	}
+	customer := &types.Account{ID: uuid.New(), Role: types.RoleCustomer}
+	merchant := &types.Account{ID: uuid.New(), Role: types.RoleMerchant}
+	support := &types.Account{ID: uuid.New(), Role: types.RoleSupport}
+	admin := &types.Account{ID: uuid.New(), Role: types.RoleAdmin}
+
+	t.Run("Own account", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionReadAccount), customer, customer.ID))
+		require.Equal(t, customer, user)
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionMoveMoney), customer, customer.ID))
+	})
+
+	t.Run("Other account", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionReadAccount), customer, merchant.ID))
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionReadAccount), merchant, customer.ID))
+	})
+
+	t.Run("Support", func(t *testing.T) {
+		// views any account, but doesn't change it or move money
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionReadAccount), support, customer.ID))
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionWriteAccount), support, customer.ID))
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionMoveMoney), support, support.ID))
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionListAccounts), support, support.ID))
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionListAccounts), admin, admin.ID))
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionManageRoles), admin, customer.ID))
+		// credentials belong to the account itself
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionCredentials), admin, customer.ID))
+	})
+
+	t.Run("API keys", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(newHandler(types.PermissionManageAPIKeys), merchant, merchant.ID))
+		require.Equal(t, http.StatusForbidden, serve(newHandler(types.PermissionManageAPIKeys), customer, customer.ID))
+	})
+
+	t.Run("Deleted account", func(t *testing.T) {
+		id := uuid.New()
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+		request := httptest.NewRequest(http.MethodGet, "/account", nil)
+		request = request.WithContext(context.WithValue(request.Context(), accountContextKey, id))
+		recorder := httptest.NewRecorder()
+		newHandler(types.PermissionReadAccount)(recorder, request)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
+
+func Test_VerifySignature(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, mockRedis, nil, nil, nil, nil, nil)
+
+	key, apiKey, err := utils.CreateAPIKey(uuid.New(), types.APIKeySecret, types.APIKeyModeLive)
+	require.NoError(t, err)
+	apiKey.ID = uuid.New()
+	signer := client.NewSigner(key, apiKey.SigningSecret)
+
+	body := `{"order_id":"1","amount":50}`
+	calls := 0
+	handler := server.VerifySignature(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		read, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(read))
+		WriteJSON(w, http.StatusOK, nil)
+	})
+	newRequest := func(apiKey *types.APIKey) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/payment/capture/1", strings.NewReader(body))
+		require.NoError(t, signer.Sign(request))
+		return request.WithContext(context.WithValue(request.Context(), apiKeyContextKey, apiKey))
+	}
+	nonceKey := func(request *http.Request) string {
+		return "nonce:" + apiKey.ID.String() + ":" + request.Header.Get(client.HeaderNonce)
+	}
+
+	t.Run("Signed", func(t *testing.T) {
+		request := newRequest(apiKey)
+		mockRedis.EXPECT().SaveNonce(gomock.Any(), nonceKey(request), 2*defaultSignatureWindow).Return(true, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		request := newRequest(apiKey)
+		mockRedis.EXPECT().SaveNonce(gomock.Any(), nonceKey(request), 2*defaultSignatureWindow).Return(false, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errReplay.Error())
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Tampered body", func(t *testing.T) {
+		request := newRequest(apiKey)
+		request.Body = io.NopCloser(strings.NewReader(`{"order_id":"1","amount":5000}`))
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errSignature.Error())
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		request := newRequest(apiKey)
+		timestamp := time.Now().Add(-time.Hour).Unix()
+		request.Header.Set(client.HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		request.Header.Set(client.HeaderSignature, client.Signature(
+			apiKey.SigningSecret, request.Method, request.URL.Path, timestamp, request.Header.Get(client.HeaderNonce), []byte(body),
+		))
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errSignatureExpired.Error())
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Publishable key", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/payment/auth", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), apiKeyContextKey, &types.APIKey{Type: types.APIKeyPublishable}))
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, 2, calls)
+	})
+}
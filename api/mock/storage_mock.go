@@ -8,6 +8,7 @@ import (
 	context "context"
 	sql "database/sql"
 	reflect "reflect"
+	time "time"
 
 	types "github.com/Edbeer/paymentapi/types"
 	gomock "github.com/golang/mock/gomock"
@@ -38,18 +39,33 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 }
 
 // CreateAccount mocks base method.
-func (m *MockStorage) CreateAccount(ctx context.Context, reqAcc *types.RequestCreate) (*types.Account, error) {
+func (m *MockStorage) CreateAccount(ctx context.Context, reqAcc *types.RequestCreate, card *types.Card) (*types.Account, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateAccount", ctx, reqAcc)
+	ret := m.ctrl.Call(m, "CreateAccount", ctx, reqAcc, card)
 	ret0, _ := ret[0].(*types.Account)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateAccount indicates an expected call of CreateAccount.
-func (mr *MockStorageMockRecorder) CreateAccount(ctx, reqAcc interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) CreateAccount(ctx, reqAcc, card interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAccount", reflect.TypeOf((*MockStorage)(nil).CreateAccount), ctx, reqAcc)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAccount", reflect.TypeOf((*MockStorage)(nil).CreateAccount), ctx, reqAcc, card)
+}
+
+// DeferExpiry mocks base method.
+func (m *MockStorage) DeferExpiry(ctx context.Context, id uuid.UUID, retryAt time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeferExpiry", ctx, id, retryAt)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeferExpiry indicates an expected call of DeferExpiry.
+func (mr *MockStorageMockRecorder) DeferExpiry(ctx, id, retryAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeferExpiry", reflect.TypeOf((*MockStorage)(nil).DeferExpiry), ctx, id, retryAt)
 }
 
 // DeleteAccount mocks base method.
@@ -67,48 +83,63 @@ func (mr *MockStorageMockRecorder) DeleteAccount(ctx, id interface{}) *gomock.Ca
 }
 
 // DepositAccount mocks base method.
-func (m *MockStorage) DepositAccount(ctx context.Context, reqDep *types.RequestDeposit) (*types.Account, error) {
+func (m *MockStorage) DepositAccount(ctx context.Context, tx *sql.Tx, reqDep *types.RequestDeposit, id uuid.UUID) (*types.Balance, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DepositAccount", ctx, reqDep)
-	ret0, _ := ret[0].(*types.Account)
+	ret := m.ctrl.Call(m, "DepositAccount", ctx, tx, reqDep, id)
+	ret0, _ := ret[0].(*types.Balance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // DepositAccount indicates an expected call of DepositAccount.
-func (mr *MockStorageMockRecorder) DepositAccount(ctx, reqDep interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) DepositAccount(ctx, tx, reqDep, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepositAccount", reflect.TypeOf((*MockStorage)(nil).DepositAccount), ctx, reqDep)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepositAccount", reflect.TypeOf((*MockStorage)(nil).DepositAccount), ctx, tx, reqDep, id)
 }
 
-// GetAccount mocks base method.
-func (m *MockStorage) GetAccount(ctx context.Context) ([]*types.Account, error) {
+// GetAPIKeyByHash mocks base method.
+func (m *MockStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAccount", ctx)
-	ret0, _ := ret[0].([]*types.Account)
+	ret := m.ctrl.Call(m, "GetAPIKeyByHash", ctx, hash)
+	ret0, _ := ret[0].(*types.APIKey)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetAccount indicates an expected call of GetAccount.
-func (mr *MockStorageMockRecorder) GetAccount(ctx interface{}) *gomock.Call {
+// GetAPIKeyByHash indicates an expected call of GetAPIKeyByHash.
+func (mr *MockStorageMockRecorder) GetAPIKeyByHash(ctx, hash interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccount", reflect.TypeOf((*MockStorage)(nil).GetAccount), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeyByHash", reflect.TypeOf((*MockStorage)(nil).GetAPIKeyByHash), ctx, hash)
 }
 
-// GetAccountByCard mocks base method.
-func (m *MockStorage) GetAccountByCard(ctx context.Context, card string) (*types.Account, error) {
+// GetAPIKeys mocks base method.
+func (m *MockStorage) GetAPIKeys(ctx context.Context, id uuid.UUID) ([]*types.APIKey, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAccountByCard", ctx, card)
-	ret0, _ := ret[0].(*types.Account)
+	ret := m.ctrl.Call(m, "GetAPIKeys", ctx, id)
+	ret0, _ := ret[0].([]*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeys indicates an expected call of GetAPIKeys.
+func (mr *MockStorageMockRecorder) GetAPIKeys(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeys", reflect.TypeOf((*MockStorage)(nil).GetAPIKeys), ctx, id)
+}
+
+// GetAccount mocks base method.
+func (m *MockStorage) GetAccount(ctx context.Context) ([]*types.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccount", ctx)
+	ret0, _ := ret[0].([]*types.Account)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetAccountByCard indicates an expected call of GetAccountByCard.
-func (mr *MockStorageMockRecorder) GetAccountByCard(ctx, card interface{}) *gomock.Call {
+// GetAccount indicates an expected call of GetAccount.
+func (mr *MockStorageMockRecorder) GetAccount(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccountByCard", reflect.TypeOf((*MockStorage)(nil).GetAccountByCard), ctx, card)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccount", reflect.TypeOf((*MockStorage)(nil).GetAccount), ctx)
 }
 
 // GetAccountByID mocks base method.
@@ -141,6 +172,51 @@ func (mr *MockStorageMockRecorder) GetAccountStatement(ctx, id interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccountStatement", reflect.TypeOf((*MockStorage)(nil).GetAccountStatement), ctx, id)
 }
 
+// GetBalances mocks base method.
+func (m *MockStorage) GetBalances(ctx context.Context, id uuid.UUID) ([]*types.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalances", ctx, id)
+	ret0, _ := ret[0].([]*types.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalances indicates an expected call of GetBalances.
+func (mr *MockStorageMockRecorder) GetBalances(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalances", reflect.TypeOf((*MockStorage)(nil).GetBalances), ctx, id)
+}
+
+// GetCredential mocks base method.
+func (m *MockStorage) GetCredential(ctx context.Context, id uuid.UUID) (*types.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCredential", ctx, id)
+	ret0, _ := ret[0].(*types.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCredential indicates an expected call of GetCredential.
+func (mr *MockStorageMockRecorder) GetCredential(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredential", reflect.TypeOf((*MockStorage)(nil).GetCredential), ctx, id)
+}
+
+// GetLedgerBalance mocks base method.
+func (m *MockStorage) GetLedgerBalance(ctx context.Context, id uuid.UUID, currency string) (*types.LedgerBalance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLedgerBalance", ctx, id, currency)
+	ret0, _ := ret[0].(*types.LedgerBalance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLedgerBalance indicates an expected call of GetLedgerBalance.
+func (mr *MockStorageMockRecorder) GetLedgerBalance(ctx, id, currency interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLedgerBalance", reflect.TypeOf((*MockStorage)(nil).GetLedgerBalance), ctx, id, currency)
+}
+
 // GetPaymentByID mocks base method.
 func (m *MockStorage) GetPaymentByID(ctx context.Context, id uuid.UUID) (*types.Payment, error) {
 	m.ctrl.T.Helper()
@@ -156,19 +232,198 @@ func (mr *MockStorageMockRecorder) GetPaymentByID(ctx, id interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPaymentByID", reflect.TypeOf((*MockStorage)(nil).GetPaymentByID), ctx, id)
 }
 
+// GetRefunds mocks base method.
+func (m *MockStorage) GetRefunds(ctx context.Context, id uuid.UUID) ([]*types.Payment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRefunds", ctx, id)
+	ret0, _ := ret[0].([]*types.Payment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRefunds indicates an expected call of GetRefunds.
+func (mr *MockStorageMockRecorder) GetRefunds(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRefunds", reflect.TypeOf((*MockStorage)(nil).GetRefunds), ctx, id)
+}
+
+// GetTOTP mocks base method.
+func (m *MockStorage) GetTOTP(ctx context.Context, id uuid.UUID) (*types.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTP", ctx, id)
+	ret0, _ := ret[0].(*types.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTP indicates an expected call of GetTOTP.
+func (mr *MockStorageMockRecorder) GetTOTP(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTP", reflect.TypeOf((*MockStorage)(nil).GetTOTP), ctx, id)
+}
+
+// LockBalances mocks base method.
+func (m *MockStorage) LockBalances(ctx context.Context, tx *sql.Tx, wallets map[uuid.UUID]string) (map[uuid.UUID]*types.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockBalances", ctx, tx, wallets)
+	ret0, _ := ret[0].(map[uuid.UUID]*types.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockBalances indicates an expected call of LockBalances.
+func (mr *MockStorageMockRecorder) LockBalances(ctx, tx, wallets interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockBalances", reflect.TypeOf((*MockStorage)(nil).LockBalances), ctx, tx, wallets)
+}
+
+// LockExpiredPayment mocks base method.
+func (m *MockStorage) LockExpiredPayment(ctx context.Context, tx *sql.Tx, now time.Time) (*types.Payment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockExpiredPayment", ctx, tx, now)
+	ret0, _ := ret[0].(*types.Payment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockExpiredPayment indicates an expected call of LockExpiredPayment.
+func (mr *MockStorageMockRecorder) LockExpiredPayment(ctx, tx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockExpiredPayment", reflect.TypeOf((*MockStorage)(nil).LockExpiredPayment), ctx, tx, now)
+}
+
+// LockPayment mocks base method.
+func (m *MockStorage) LockPayment(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*types.Payment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockPayment", ctx, tx, id)
+	ret0, _ := ret[0].(*types.Payment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockPayment indicates an expected call of LockPayment.
+func (mr *MockStorageMockRecorder) LockPayment(ctx, tx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockPayment", reflect.TypeOf((*MockStorage)(nil).LockPayment), ctx, tx, id)
+}
+
+// OpenBalance mocks base method.
+func (m *MockStorage) OpenBalance(ctx context.Context, id uuid.UUID, currency string) (*types.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OpenBalance", ctx, id, currency)
+	ret0, _ := ret[0].(*types.Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OpenBalance indicates an expected call of OpenBalance.
+func (mr *MockStorageMockRecorder) OpenBalance(ctx, id, currency interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenBalance", reflect.TypeOf((*MockStorage)(nil).OpenBalance), ctx, id, currency)
+}
+
+// ResetFailedLogins mocks base method.
+func (m *MockStorage) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetFailedLogins", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetFailedLogins indicates an expected call of ResetFailedLogins.
+func (mr *MockStorageMockRecorder) ResetFailedLogins(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetFailedLogins", reflect.TypeOf((*MockStorage)(nil).ResetFailedLogins), ctx, id)
+}
+
+// RevokeAPIKey mocks base method.
+func (m *MockStorage) RevokeAPIKey(ctx context.Context, accountId, id uuid.UUID) (*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAPIKey", ctx, accountId, id)
+	ret0, _ := ret[0].(*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeAPIKey indicates an expected call of RevokeAPIKey.
+func (mr *MockStorageMockRecorder) RevokeAPIKey(ctx, accountId, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAPIKey", reflect.TypeOf((*MockStorage)(nil).RevokeAPIKey), ctx, accountId, id)
+}
+
+// SaveAPIKey mocks base method.
+func (m *MockStorage) SaveAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAPIKey", ctx, key)
+	ret0, _ := ret[0].(*types.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveAPIKey indicates an expected call of SaveAPIKey.
+func (mr *MockStorageMockRecorder) SaveAPIKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAPIKey", reflect.TypeOf((*MockStorage)(nil).SaveAPIKey), ctx, key)
+}
+
 // SaveBalance mocks base method.
-func (m *MockStorage) SaveBalance(ctx context.Context, tx *sql.Tx, account *types.Account, balance, bmoney uint64) (*types.Account, error) {
+func (m *MockStorage) SaveBalance(ctx context.Context, tx *sql.Tx, balance *types.Balance, delta types.BalanceDelta) (*types.Balance, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveBalance", ctx, tx, account, balance, bmoney)
-	ret0, _ := ret[0].(*types.Account)
+	ret := m.ctrl.Call(m, "SaveBalance", ctx, tx, balance, delta)
+	ret0, _ := ret[0].(*types.Balance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // SaveBalance indicates an expected call of SaveBalance.
-func (mr *MockStorageMockRecorder) SaveBalance(ctx, tx, account, balance, bmoney interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) SaveBalance(ctx, tx, balance, delta interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBalance", reflect.TypeOf((*MockStorage)(nil).SaveBalance), ctx, tx, account, balance, bmoney)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveBalance", reflect.TypeOf((*MockStorage)(nil).SaveBalance), ctx, tx, balance, delta)
+}
+
+// SaveCredential mocks base method.
+func (m *MockStorage) SaveCredential(ctx context.Context, credential *types.Credential) (*types.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveCredential", ctx, credential)
+	ret0, _ := ret[0].(*types.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveCredential indicates an expected call of SaveCredential.
+func (mr *MockStorageMockRecorder) SaveCredential(ctx, credential interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCredential", reflect.TypeOf((*MockStorage)(nil).SaveCredential), ctx, credential)
+}
+
+// SaveFailedLogin mocks base method.
+func (m *MockStorage) SaveFailedLogin(ctx context.Context, id uuid.UUID, maxFailures int, lockedUntil time.Time) (*types.Credential, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveFailedLogin", ctx, id, maxFailures, lockedUntil)
+	ret0, _ := ret[0].(*types.Credential)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveFailedLogin indicates an expected call of SaveFailedLogin.
+func (mr *MockStorageMockRecorder) SaveFailedLogin(ctx, id, maxFailures, lockedUntil interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveFailedLogin", reflect.TypeOf((*MockStorage)(nil).SaveFailedLogin), ctx, id, maxFailures, lockedUntil)
+}
+
+// SaveJournalEntry mocks base method.
+func (m *MockStorage) SaveJournalEntry(ctx context.Context, tx *sql.Tx, entry *types.JournalEntry) (*types.JournalEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveJournalEntry", ctx, tx, entry)
+	ret0, _ := ret[0].(*types.JournalEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveJournalEntry indicates an expected call of SaveJournalEntry.
+func (mr *MockStorageMockRecorder) SaveJournalEntry(ctx, tx, entry interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveJournalEntry", reflect.TypeOf((*MockStorage)(nil).SaveJournalEntry), ctx, tx, entry)
 }
 
 // SavePayment mocks base method.
@@ -186,19 +441,64 @@ func (mr *MockStorageMockRecorder) SavePayment(ctx, tx, payment interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePayment", reflect.TypeOf((*MockStorage)(nil).SavePayment), ctx, tx, payment)
 }
 
+// SaveTOTP mocks base method.
+func (m *MockStorage) SaveTOTP(ctx context.Context, totp *types.TOTP) (*types.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTOTP", ctx, totp)
+	ret0, _ := ret[0].(*types.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveTOTP indicates an expected call of SaveTOTP.
+func (mr *MockStorageMockRecorder) SaveTOTP(ctx, totp interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTOTP", reflect.TypeOf((*MockStorage)(nil).SaveTOTP), ctx, totp)
+}
+
 // UpdateAccount mocks base method.
-func (m *MockStorage) UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, id uuid.UUID) (*types.Account, error) {
+func (m *MockStorage) UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, card *types.Card, id uuid.UUID) (*types.Account, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateAccount", ctx, reqUp, id)
+	ret := m.ctrl.Call(m, "UpdateAccount", ctx, reqUp, card, id)
 	ret0, _ := ret[0].(*types.Account)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateAccount indicates an expected call of UpdateAccount.
-func (mr *MockStorageMockRecorder) UpdateAccount(ctx, reqUp, id interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateAccount(ctx, reqUp, card, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAccount", reflect.TypeOf((*MockStorage)(nil).UpdateAccount), ctx, reqUp, id)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAccount", reflect.TypeOf((*MockStorage)(nil).UpdateAccount), ctx, reqUp, card, id)
+}
+
+// UpdatePayment mocks base method.
+func (m *MockStorage) UpdatePayment(ctx context.Context, tx *sql.Tx, payment *types.Payment) (*types.Payment, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePayment", ctx, tx, payment)
+	ret0, _ := ret[0].(*types.Payment)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdatePayment indicates an expected call of UpdatePayment.
+func (mr *MockStorageMockRecorder) UpdatePayment(ctx, tx, payment interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePayment", reflect.TypeOf((*MockStorage)(nil).UpdatePayment), ctx, tx, payment)
+}
+
+// UpdateRole mocks base method.
+func (m *MockStorage) UpdateRole(ctx context.Context, id uuid.UUID, role string) (*types.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRole", ctx, id, role)
+	ret0, _ := ret[0].(*types.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateRole indicates an expected call of UpdateRole.
+func (mr *MockStorageMockRecorder) UpdateRole(ctx, id, role interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRole", reflect.TypeOf((*MockStorage)(nil).UpdateRole), ctx, id, role)
 }
 
 // UpdateStatement mocks base method.
@@ -216,6 +516,36 @@ func (mr *MockStorageMockRecorder) UpdateStatement(ctx, tx, id, paymentId interf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateStatement", reflect.TypeOf((*MockStorage)(nil).UpdateStatement), ctx, tx, id, paymentId)
 }
 
+// UseRecoveryCode mocks base method.
+func (m *MockStorage) UseRecoveryCode(ctx context.Context, id uuid.UUID, hash string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", ctx, id, hash)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockStorageMockRecorder) UseRecoveryCode(ctx, id, hash interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockStorage)(nil).UseRecoveryCode), ctx, id, hash)
+}
+
+// UseTOTPCode mocks base method.
+func (m *MockStorage) UseTOTPCode(ctx context.Context, id uuid.UUID, counter int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPCode", ctx, id, counter)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPCode indicates an expected call of UseTOTPCode.
+func (mr *MockStorageMockRecorder) UseTOTPCode(ctx, id, counter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPCode", reflect.TypeOf((*MockStorage)(nil).UseTOTPCode), ctx, id, counter)
+}
+
 // MockRedisStorage is a mock of RedisStorage interface.
 type MockRedisStorage struct {
 	ctrl     *gomock.Controller
@@ -239,6 +569,51 @@ func (m *MockRedisStorage) EXPECT() *MockRedisStorageMockRecorder {
 	return m.recorder
 }
 
+// ConsumeResetToken mocks base method.
+func (m *MockRedisStorage) ConsumeResetToken(ctx context.Context, token string) (uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeResetToken", ctx, token)
+	ret0, _ := ret[0].(uuid.UUID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeResetToken indicates an expected call of ConsumeResetToken.
+func (mr *MockRedisStorageMockRecorder) ConsumeResetToken(ctx, token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeResetToken", reflect.TypeOf((*MockRedisStorage)(nil).ConsumeResetToken), ctx, token)
+}
+
+// ConsumeSignInChallenge mocks base method.
+func (m *MockRedisStorage) ConsumeSignInChallenge(ctx context.Context, challenge string) (uuid.UUID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConsumeSignInChallenge", ctx, challenge)
+	ret0, _ := ret[0].(uuid.UUID)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConsumeSignInChallenge indicates an expected call of ConsumeSignInChallenge.
+func (mr *MockRedisStorageMockRecorder) ConsumeSignInChallenge(ctx, challenge interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConsumeSignInChallenge", reflect.TypeOf((*MockRedisStorage)(nil).ConsumeSignInChallenge), ctx, challenge)
+}
+
+// CreateResetToken mocks base method.
+func (m *MockRedisStorage) CreateResetToken(ctx context.Context, id uuid.UUID, expire int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateResetToken", ctx, id, expire)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateResetToken indicates an expected call of CreateResetToken.
+func (mr *MockRedisStorageMockRecorder) CreateResetToken(ctx, id, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateResetToken", reflect.TypeOf((*MockRedisStorage)(nil).CreateResetToken), ctx, id, expire)
+}
+
 // CreateSession mocks base method.
 func (m *MockRedisStorage) CreateSession(ctx context.Context, session *types.Session, expire int) (string, error) {
 	m.ctrl.T.Helper()
@@ -254,6 +629,35 @@ func (mr *MockRedisStorageMockRecorder) CreateSession(ctx, session, expire inter
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockRedisStorage)(nil).CreateSession), ctx, session, expire)
 }
 
+// CreateSignInChallenge mocks base method.
+func (m *MockRedisStorage) CreateSignInChallenge(ctx context.Context, id uuid.UUID, expire int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSignInChallenge", ctx, id, expire)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSignInChallenge indicates an expected call of CreateSignInChallenge.
+func (mr *MockRedisStorageMockRecorder) CreateSignInChallenge(ctx, id, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSignInChallenge", reflect.TypeOf((*MockRedisStorage)(nil).CreateSignInChallenge), ctx, id, expire)
+}
+
+// DeleteIdempotentRequest mocks base method.
+func (m *MockRedisStorage) DeleteIdempotentRequest(ctx context.Context, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotentRequest", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotentRequest indicates an expected call of DeleteIdempotentRequest.
+func (mr *MockRedisStorageMockRecorder) DeleteIdempotentRequest(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotentRequest", reflect.TypeOf((*MockRedisStorage)(nil).DeleteIdempotentRequest), ctx, key)
+}
+
 // DeleteSession mocks base method.
 func (m *MockRedisStorage) DeleteSession(ctx context.Context, refreshToken string) error {
 	m.ctrl.T.Helper()
@@ -268,17 +672,208 @@ func (mr *MockRedisStorageMockRecorder) DeleteSession(ctx, refreshToken interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSession", reflect.TypeOf((*MockRedisStorage)(nil).DeleteSession), ctx, refreshToken)
 }
 
-// GetUserID mocks base method.
-func (m *MockRedisStorage) GetUserID(ctx context.Context, refreshToken string) (uuid.UUID, error) {
+// FinishIdempotentRequest mocks base method.
+func (m *MockRedisStorage) FinishIdempotentRequest(ctx context.Context, key string, record *types.IdempotentRecord, expire int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserID", ctx, refreshToken)
-	ret0, _ := ret[0].(uuid.UUID)
+	ret := m.ctrl.Call(m, "FinishIdempotentRequest", ctx, key, record, expire)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishIdempotentRequest indicates an expected call of FinishIdempotentRequest.
+func (mr *MockRedisStorageMockRecorder) FinishIdempotentRequest(ctx, key, record, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishIdempotentRequest", reflect.TypeOf((*MockRedisStorage)(nil).FinishIdempotentRequest), ctx, key, record, expire)
+}
+
+// GetSessions mocks base method.
+func (m *MockRedisStorage) GetSessions(ctx context.Context, userID uuid.UUID) ([]*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSessions", ctx, userID)
+	ret0, _ := ret[0].([]*types.Session)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUserID indicates an expected call of GetUserID.
-func (mr *MockRedisStorageMockRecorder) GetUserID(ctx, refreshToken interface{}) *gomock.Call {
+// GetSessions indicates an expected call of GetSessions.
+func (mr *MockRedisStorageMockRecorder) GetSessions(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSessions", reflect.TypeOf((*MockRedisStorage)(nil).GetSessions), ctx, userID)
+}
+
+// RevokeOtherSessions mocks base method.
+func (m *MockRedisStorage) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, refreshToken string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeOtherSessions", ctx, userID, refreshToken)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeOtherSessions indicates an expected call of RevokeOtherSessions.
+func (mr *MockRedisStorageMockRecorder) RevokeOtherSessions(ctx, userID, refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeOtherSessions", reflect.TypeOf((*MockRedisStorage)(nil).RevokeOtherSessions), ctx, userID, refreshToken)
+}
+
+// RevokeSession mocks base method.
+func (m *MockRedisStorage) RevokeSession(ctx context.Context, userID, id uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", ctx, userID, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockRedisStorageMockRecorder) RevokeSession(ctx, userID, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockRedisStorage)(nil).RevokeSession), ctx, userID, id)
+}
+
+// RevokeSessions mocks base method.
+func (m *MockRedisStorage) RevokeSessions(ctx context.Context, userID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSessions", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSessions indicates an expected call of RevokeSessions.
+func (mr *MockRedisStorageMockRecorder) RevokeSessions(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSessions", reflect.TypeOf((*MockRedisStorage)(nil).RevokeSessions), ctx, userID)
+}
+
+// RotateSession mocks base method.
+func (m *MockRedisStorage) RotateSession(ctx context.Context, refreshToken string, expire int) (*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateSession", ctx, refreshToken, expire)
+	ret0, _ := ret[0].(*types.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateSession indicates an expected call of RotateSession.
+func (mr *MockRedisStorageMockRecorder) RotateSession(ctx, refreshToken, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateSession", reflect.TypeOf((*MockRedisStorage)(nil).RotateSession), ctx, refreshToken, expire)
+}
+
+// SaveNonce mocks base method.
+func (m *MockRedisStorage) SaveNonce(ctx context.Context, key string, expire int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveNonce", ctx, key, expire)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveNonce indicates an expected call of SaveNonce.
+func (mr *MockRedisStorageMockRecorder) SaveNonce(ctx, key, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveNonce", reflect.TypeOf((*MockRedisStorage)(nil).SaveNonce), ctx, key, expire)
+}
+
+// StartIdempotentRequest mocks base method.
+func (m *MockRedisStorage) StartIdempotentRequest(ctx context.Context, key, fingerprint string, expire int) (*types.IdempotentRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartIdempotentRequest", ctx, key, fingerprint, expire)
+	ret0, _ := ret[0].(*types.IdempotentRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StartIdempotentRequest indicates an expected call of StartIdempotentRequest.
+func (mr *MockRedisStorageMockRecorder) StartIdempotentRequest(ctx, key, fingerprint, expire interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartIdempotentRequest", reflect.TypeOf((*MockRedisStorage)(nil).StartIdempotentRequest), ctx, key, fingerprint, expire)
+}
+
+// MockCardVault is a mock of CardVault interface.
+type MockCardVault struct {
+	ctrl     *gomock.Controller
+	recorder *MockCardVaultMockRecorder
+}
+
+// MockCardVaultMockRecorder is the mock recorder for MockCardVault.
+type MockCardVaultMockRecorder struct {
+	mock *MockCardVault
+}
+
+// NewMockCardVault creates a new mock instance.
+func NewMockCardVault(ctrl *gomock.Controller) *MockCardVault {
+	mock := &MockCardVault{ctrl: ctrl}
+	mock.recorder = &MockCardVaultMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCardVault) EXPECT() *MockCardVaultMockRecorder {
+	return m.recorder
+}
+
+// Lookup mocks base method.
+func (m *MockCardVault) Lookup(ctx context.Context, pan string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lookup", ctx, pan)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lookup indicates an expected call of Lookup.
+func (mr *MockCardVaultMockRecorder) Lookup(ctx, pan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockCardVault)(nil).Lookup), ctx, pan)
+}
+
+// Tokenize mocks base method.
+func (m *MockCardVault) Tokenize(ctx context.Context, pan string) (*types.Card, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tokenize", ctx, pan)
+	ret0, _ := ret[0].(*types.Card)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Tokenize indicates an expected call of Tokenize.
+func (mr *MockCardVaultMockRecorder) Tokenize(ctx, pan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tokenize", reflect.TypeOf((*MockCardVault)(nil).Tokenize), ctx, pan)
+}
+
+// MockNotifier is a mock of Notifier interface.
+type MockNotifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotifierMockRecorder
+}
+
+// MockNotifierMockRecorder is the mock recorder for MockNotifier.
+type MockNotifierMockRecorder struct {
+	mock *MockNotifier
+}
+
+// NewMockNotifier creates a new mock instance.
+func NewMockNotifier(ctrl *gomock.Controller) *MockNotifier {
+	mock := &MockNotifier{ctrl: ctrl}
+	mock.recorder = &MockNotifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNotifier) EXPECT() *MockNotifierMockRecorder {
+	return m.recorder
+}
+
+// PasswordReset mocks base method.
+func (m *MockNotifier) PasswordReset(ctx context.Context, account *types.Account, token string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PasswordReset", ctx, account, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PasswordReset indicates an expected call of PasswordReset.
+func (mr *MockNotifierMockRecorder) PasswordReset(ctx, account, token interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserID", reflect.TypeOf((*MockRedisStorage)(nil).GetUserID), ctx, refreshToken)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PasswordReset", reflect.TypeOf((*MockNotifier)(nil).PasswordReset), ctx, account, token)
 }
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+)
+
+var (
+	errCredentials     = types.NewError(types.CodeUnauthorized, "invalid account id or password")
+	errLocked          = types.NewError(codeLocked, "sign-in is locked after too many failed attempts, try again later")
+	errCurrentPassword = types.NewError(types.CodeForbidden, "current password is wrong")
+	errResetToken      = types.NewError(types.CodeInvalid, "invalid or expired reset token")
+	errResetDisabled   = types.NewError(types.CodeForbidden, "password reset is disabled, no notifier is configured")
+)
+
+// Defaults if the config doesn't set them
+const (
+	defaultMaxFailedLogins = 5
+	defaultLockout         = 15 * time.Minute
+	defaultResetTokenTTL   = 3600
+)
+
+// setPassword godoc
+// @Summary Set password
+// @Description set account password, the current password is required to change it, other sessions are signed out, returns status
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "account id"
+// @Param input body types.RequestPassword true "set password info"
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/password [put]
+func (s *JSONApiServer) setPassword(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.setPassword")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestPassword{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	credential, err := s.storage.GetCredential(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// first password
+	case err != nil:
+		return s.writeError(w, err)
+	case !utils.CheckPassword(credential.PasswordHash, req.CurrentPassword):
+		return s.writeError(w, errCurrentPassword)
+	}
+	if err := s.savePassword(ctx, id, req.Password); err != nil {
+		return s.writeError(w, err)
+	}
+	// sessions of whoever knew the old password are signed out,
+	// the session of the request is kept
+	refreshToken := ""
+	if cookie, err := r.Cookie("refresh-token"); err == nil {
+		refreshToken = cookie.Value
+	}
+	if err := s.redisStorage.RevokeOtherSessions(ctx, id, refreshToken); err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, "password was set")
+}
+
+// resetPassword godoc
+// @Summary Reset password
+// @Description send password reset token to the account owner, returns status whether the account exists or not
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param input body types.RequestPasswordReset true "reset password info"
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/password/reset [post]
+func (s *JSONApiServer) resetPassword(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.resetPassword")
+	defer span.Finish()
+	// the token can't be delivered
+	if s.notifier == nil {
+		return s.writeError(w, errResetDisabled)
+	}
+
+	req := &types.RequestPasswordReset{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	const sent = "reset token was sent to the account owner"
+	account, err := s.storage.GetAccountByID(ctx, req.ID)
+	if err != nil {
+		return WriteJSON(w, http.StatusOK, sent)
+	}
+	token, err := s.redisStorage.CreateResetToken(ctx, account.ID, s.resetTokenTTL())
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if err := s.notifier.PasswordReset(ctx, account, token); err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, sent)
+}
+
+// confirmPasswordReset godoc
+// @Summary Confirm password reset
+// @Description set account password with the reset token, unlocks sign-in and signs out all sessions, returns status
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param input body types.RequestPasswordResetConfirm true "confirm password reset info"
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/password/reset/confirm [post]
+func (s *JSONApiServer) confirmPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.confirmPasswordReset")
+	defer span.Finish()
+
+	req := &types.RequestPasswordResetConfirm{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	if err := utils.ValidatePassword(req.Password); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	id, err := s.redisStorage.ConsumeResetToken(ctx, req.Token)
+	if err != nil {
+		return s.writeError(w, errResetToken)
+	}
+	if err := s.savePassword(ctx, id, req.Password); err != nil {
+		return s.writeError(w, err)
+	}
+	// every session may be of whoever knew the old password
+	if err := s.redisStorage.RevokeSessions(ctx, id); err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, "password was set")
+}
+
+// Hash and save the password, failed sign-ins are cleared
+func (s *JSONApiServer) savePassword(ctx context.Context, id uuid.UUID, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.storage.SaveCredential(ctx, &types.Credential{
+		AccountID:    id,
+		PasswordHash: hash,
+	})
+	return err
+}
+
+func (s *JSONApiServer) maxFailedLogins() int {
+	if s.config.Auth.MaxFailedLogins > 0 {
+		return s.config.Auth.MaxFailedLogins
+	}
+	return defaultMaxFailedLogins
+}
+
+func (s *JSONApiServer) lockout() time.Duration {
+	if s.config.Auth.Lockout > 0 {
+		return time.Duration(s.config.Auth.Lockout) * time.Second
+	}
+	return defaultLockout
+}
+
+func (s *JSONApiServer) resetTokenTTL() int {
+	if s.config.Auth.ResetTokenTTL > 0 {
+		return s.config.Auth.ResetTokenTTL
+	}
+	return defaultResetTokenTTL
+}
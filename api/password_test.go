@@ -0,0 +1,155 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SetPassword(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, mockRedis, nil, nil, nil, nil, nil)
+
+	id := uuid.New()
+	newRequest := func(body string) *http.Request {
+		request := httptest.NewRequest(http.MethodPut, "/account/"+id.String()+"/password", strings.NewReader(body))
+		return mux.SetURLVars(request, map[string]string{"id": id.String()})
+	}
+	savePassword := func(password string) {
+		mockStorage.EXPECT().SaveCredential(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ any, credential *types.Credential) (*types.Credential, error) {
+				require.Equal(t, id, credential.AccountID)
+				require.True(t, utils.CheckPassword(credential.PasswordHash, password))
+				return credential, nil
+			})
+	}
+
+	t.Run("First password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+		savePassword("correct horse")
+		mockRedis.EXPECT().RevokeOtherSessions(gomock.Any(), id, "").Return(nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.setPassword(recorder, newRequest(`{"password":"correct horse"}`)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	passwordHash, err := utils.HashPassword("correct horse")
+	require.NoError(t, err)
+
+	t.Run("Change password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{PasswordHash: passwordHash}, nil)
+		savePassword("battery staple")
+		// the session of the request is kept
+		mockRedis.EXPECT().RevokeOtherSessions(gomock.Any(), id, "refresh").Return(nil)
+
+		request := newRequest(`{"current_password":"correct horse","password":"battery staple"}`)
+		request.AddCookie(&http.Cookie{Name: "refresh-token", Value: "refresh"})
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.setPassword(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Wrong current password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{PasswordHash: passwordHash}, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.setPassword(recorder, newRequest(`{"current_password":"wrong horse","password":"battery staple"}`)))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+	})
+
+	t.Run("Short password", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.setPassword(recorder, newRequest(`{"password":"horse"}`)))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
+
+func Test_ResetPassword(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	mockNotifier := mockstore.NewMockNotifier(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, mockRedis, nil, nil, mockNotifier, nil, nil)
+
+	account := &types.Account{ID: uuid.New()}
+
+	t.Run("Reset", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), account.ID).Return(account, nil)
+		mockRedis.EXPECT().CreateResetToken(gomock.Any(), account.ID, defaultResetTokenTTL).Return("token", nil)
+		mockNotifier.EXPECT().PasswordReset(gomock.Any(), account, "token").Return(nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/password/reset", strings.NewReader(`{"id":"`+account.ID.String()+`"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.resetPassword(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Unknown account", func(t *testing.T) {
+		id := uuid.New()
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/password/reset", strings.NewReader(`{"id":"`+id.String()+`"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.resetPassword(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		mockRedis.EXPECT().ConsumeResetToken(gomock.Any(), "token").Return(account.ID, nil)
+		mockStorage.EXPECT().SaveCredential(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ any, credential *types.Credential) (*types.Credential, error) {
+				require.Equal(t, account.ID, credential.AccountID)
+				require.True(t, utils.CheckPassword(credential.PasswordHash, "battery staple"))
+				return credential, nil
+			})
+		mockRedis.EXPECT().RevokeSessions(gomock.Any(), account.ID).Return(nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/password/reset/confirm", strings.NewReader(`{"token":"token","password":"battery staple"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.confirmPasswordReset(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Used token", func(t *testing.T) {
+		mockRedis.EXPECT().ConsumeResetToken(gomock.Any(), "token").Return(uuid.Nil, redis.Nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/password/reset/confirm", strings.NewReader(`{"token":"token","password":"battery staple"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.confirmPasswordReset(recorder, request))
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errResetToken.Error())
+	})
+
+	t.Run("No notifier", func(t *testing.T) {
+		server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, mockRedis, nil, nil, nil, nil, nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/password/reset", strings.NewReader(`{"id":"`+account.ID.String()+`"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.resetPassword(recorder, request))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errResetDisabled.Error())
+	})
+}
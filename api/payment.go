@@ -1,9 +1,14 @@
 package api
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/Edbeer/paymentapi/pkg/db/psql"
+	"github.com/Edbeer/paymentapi/pkg/fx"
 	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/google/uuid"
@@ -18,10 +23,14 @@ import (
 // @Produce json
 // @Param id path string true "create payment info"
 // @Param input body types.PaymentRequest true "create payment info"
+// @Param Idempotency-Key header string false "idempotency key"
 // @Success 200 {object} types.PaymentResponse
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
+// @Failure 422  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
 // @Router /payment/auth [post]
 func (s *JSONApiServer) createPayment(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.createPayment")
@@ -29,131 +38,131 @@ func (s *JSONApiServer) createPayment(w http.ResponseWriter, r *http.Request) er
 	// read body request
 	reqPay := &types.PaymentRequest{}
 	if err := json.NewDecoder(r.Body).Decode(reqPay); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
 	// validate request
 	if err := utils.ValidatePaymentRequest(reqPay); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// merchant account
-	id, err := getMerchantID(r)
+	merchantAccount, err := getMerchant(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	merchantAccount, err := s.storage.GetAccountByID(ctx, id)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// personal account
 	personalAccountId := reqPay.AccountId
+	if personalAccountId == merchantAccount.ID {
+		return s.writeError(w, errSelfPayment)
+	}
 	personalAccount, err := s.storage.GetAccountByID(ctx, personalAccountId)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	// check payment request
-	if reqPay.CardNumber != personalAccount.CardNumber || 
-	reqPay.CardExpiryMonth != personalAccount.CardExpiryMonth ||
-	reqPay.CardExpiryYear != personalAccount.CardExpiryYear ||
-	reqPay.CardSecurityCode	!= personalAccount.CardSecurityCode {
-		// Begin Transaction
-		tx, err := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		}
-		payment := types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, "wrong payment request")
-		savedPayment, err := s.storage.SavePayment(ctx, tx, payment)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	// check payment request, the card is compared by its vault token,
+	// the security code isn't stored, it's only required to be present
+	cardToken, err := s.vault.Lookup(ctx, reqPay.CardNumber)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return s.writeError(w, err)
+	}
+	if cardToken != personalAccount.CardToken ||
+		reqPay.CardExpiryMonth != personalAccount.CardExpiryMonth ||
+		reqPay.CardExpiryYear != personalAccount.CardExpiryYear {
+		payment := types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, types.StatusFailed)
+		payment.Reason = types.ReasonWrongRequest
+		if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+			savedPayment, err := s.storage.SavePayment(ctx, tx, payment)
+			if err != nil {
+				return err
+			}
+			_, err = s.storage.UpdateStatement(ctx, tx, merchantAccount.ID, savedPayment.ID)
+			return err
+		}); err != nil {
+			return s.writeError(w, err)
 		}
-		merchantAccount.Statement = append(merchantAccount.Statement, savedPayment.ID.String())
-		merchantAccount, err = s.storage.UpdateStatement(ctx, tx, id, savedPayment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		} 
-		return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-			ID:     payment.ID,
-			Status: payment.Status,
-		})
+		return s.writeError(w, &types.DeclineError{Code: types.CodeCardDeclined, Payment: payment})
 	}
-	// consume user balance
-	// balance < req amount
-	if personalAccount.Balance < reqPay.Amount {
-		// Begin Transaction
-		tx, err := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
+	// lock exchange rate to the merchant currency
+	rate, err := fx.Quote(ctx, s.rates, reqPay.Currency, merchantAccount.Currency, s.config.FX.MarkupBps)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	var payment *types.Payment
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// lock personal balance in the payment currency
+		// and merchant balance in the settlement currency
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			personalAccountId:  reqPay.Currency,
+			merchantAccount.ID: rate.To,
+		})
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
+			}
+			return err
+		}
+		personalBalance, merchantBalance := balances[personalAccountId], balances[merchantAccount.ID]
+		// consume user balance
+		// balance < req amount
+		if personalBalance.Balance < reqPay.Amount {
+			payment = types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, types.StatusFailed)
+			payment.Reason = types.ReasonInsufficientFunds
+			if err := payment.LockRate(rate); err != nil {
+				return err
+			}
+			savedPayment, err := s.storage.SavePayment(ctx, tx, payment)
+			if err != nil {
+				return err
+			}
+			_, err = s.storage.UpdateStatement(ctx, tx, merchantAccount.ID, savedPayment.ID)
+			return err
+		}
+		// balance > req amount
+		// create new payment
+		payment = types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, types.StatusAuthorized)
+		payment.SetExpiry(s.authorizationTTL(merchantAccount))
+		if err := payment.LockRate(rate); err != nil {
+			return err
+		}
+		// personal acc new balance
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance:      -int64(payment.Amount),
+			BlockedMoney: int64(payment.Amount),
+		}); err != nil {
+			return err
+		}
+		// merchant account new balance
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			BlockedMoney: int64(payment.SettlementAmount),
+		}); err != nil {
+			return err
 		}
-		payment := types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, "Insufficient funds")
 		savedPayment, err := s.storage.SavePayment(ctx, tx, payment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		merchantAccount.Statement = append(merchantAccount.Statement, savedPayment.ID.String())
-		merchantAccount, err = s.storage.UpdateStatement(ctx, tx, id, payment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		} 
-		return WriteJSON(w, http.StatusBadGateway, types.PaymentResponse{
-			ID:     payment.ID,
-			Status: payment.Status,
-		})
-	}
-	// balance > req amount
-	// Begin transaction
-	tx, err := s.db.BeginTx(ctx, nil)
-	defer tx.Rollback()
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-	}
-	// personal acc new balance
-	personalAccount.Balance = personalAccount.Balance - reqPay.Amount
-	personalAccount.BlockedMoney = personalAccount.BlockedMoney + reqPay.Amount
-	personalAccount, err = s.storage.SaveBalance(ctx, tx, personalAccount, personalAccount.Balance, personalAccount.BlockedMoney)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	// merchant account new balance
-	merchantAccount.BlockedMoney = merchantAccount.BlockedMoney + reqPay.Amount
-	merchantAccount, err = s.storage.SaveBalance(ctx, tx, merchantAccount, merchantAccount.Balance, merchantAccount.BlockedMoney)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	// create new payment
-	payment := types.CreateAuthPayment(reqPay, personalAccount, merchantAccount, "Approved")
-	savedPayment, err := s.storage.SavePayment(ctx, tx, payment)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	// merchant account append statement
-	merchantAccount.Statement = append(merchantAccount.Statement, savedPayment.ID.String())
-	merchantAccount, err = s.storage.UpdateStatement(ctx, tx, id, savedPayment.ID)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		// ledger
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.AuthorizationEntry(savedPayment, personalAccountId, merchantAccount.ID)); err != nil {
+			return err
+		}
+		// merchant account append statement
+		if _, err := s.storage.UpdateStatement(ctx, tx, merchantAccount.ID, savedPayment.ID); err != nil {
+			return err
+		}
+		// personal account append statement
+		_, err = s.storage.UpdateStatement(ctx, tx, personalAccountId, savedPayment.ID)
+		return err
+	}); err != nil {
+		return s.writeError(w, err)
 	}
-	// personal account append statement
-	personalAccount.Statement = append(personalAccount.Statement, savedPayment.ID.String())
-	personalAccount, err = s.storage.UpdateStatement(ctx, tx, personalAccountId, savedPayment.ID)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+	// the failed payment is committed, the decline isn't retryable
+	if payment.Status == types.StatusFailed {
+		return s.writeError(w, &types.DeclineError{Code: types.CodeInsufficientFunds, Payment: payment})
 	}
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-	} 
 	return WriteJSON(w, http.StatusOK, types.PaymentResponse{
 		ID:     payment.ID,
 		Status: payment.Status,
+		Reason: payment.Reason,
 	})
 }
 
@@ -165,10 +174,15 @@ func (s *JSONApiServer) createPayment(w http.ResponseWriter, r *http.Request) er
 // @Produce json
 // @Param id path string true "capture payment info"
 // @Param input body types.PaidRequest true "capture payment info"
+// @Param Idempotency-Key header string false "idempotency key"
 // @Success 200 {object} types.PaymentResponse
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
+// @Failure 409  {object}  api.ApiError
+// @Failure 422  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
 // @Router /payment/capture/{id} [post]
 func (s *JSONApiServer) capturePayment(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.capturePayment")
@@ -176,113 +190,148 @@ func (s *JSONApiServer) capturePayment(w http.ResponseWriter, r *http.Request) e
 
 	reqPaid := &types.PaidRequest{}
 	if err := json.NewDecoder(r.Body).Decode(reqPaid); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
+	if err := utils.ValidatePaidRequest(reqPaid); err != nil {
+		return s.writeError(w, err)
+	}
 	paymentId, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// get merchant
-	merchantId, err := getMerchantID(r)
+	merchant, err := getMerchant(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	merchant, err := s.storage.GetAccountByID(ctx, merchantId)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	// check previous payment
-	reqPaid.Operation = "Capture"
+	merchantId := merchant.ID
+	reqPaid.Operation = types.OperationCapture
 	reqPaid.PaymentId = paymentId
 	referncedPayment, err := s.storage.GetPaymentByID(ctx, paymentId)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
+	}
+	if referncedPayment.BusinessId != merchantId {
+		return s.writeError(w, errPayment)
 	}
-	if referncedPayment.Operation == "Authorization" && referncedPayment.Status  == "Approved" {
+	buyerId := referncedPayment.AccountId
+	if buyerId == uuid.Nil {
+		return s.writeError(w, errBuyer)
+	}
+	var completedPayment *types.Payment
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// check previous payment
+		referncedPayment, err := s.storage.LockPayment(ctx, tx, paymentId)
+		if err != nil {
+			return err
+		}
+		remaining := referncedPayment.Remaining()
+		status := referncedPayment.NextStatus(types.OperationCapture, reqPaid.Amount)
+		if reqPaid.FinalCapture {
+			status = types.StatusCaptured
+		}
+		if err := referncedPayment.Transition(types.OperationCapture, status); err != nil {
+			return err
+		}
 		// Invalid amount
-		if referncedPayment.Amount < reqPaid.Amount {
-			// Begin transaction
-			tx, err := s.db.BeginTx(ctx, nil)
-			defer tx.Rollback()
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			}
-			completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Invalid amount")
-			invalidPayment, err := s.storage.SavePayment(ctx, tx, completedPayment)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-			}
-			merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
-			merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, invalidPayment.ID)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-			}
-			// Commit transaction
-			if err := tx.Commit(); err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			} 
-			return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-				ID:     invalidPayment.ID,
-				Status: invalidPayment.Status,
-			})
+		if remaining < reqPaid.Amount {
+			return errAmount
 		}
 		// Successful payment
-		// Begin Transaction
-		tx, err := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			buyerId:    referncedPayment.Currency,
+			merchantId: referncedPayment.SettlementCurrency,
+		})
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
+			}
+			return err
 		}
-		referncedPayment.Amount = referncedPayment.Amount - reqPaid.Amount
-		referncedPayment, err = s.storage.SavePayment(ctx, tx, referncedPayment)
+		personalBalance, merchantBalance := balances[buyerId], balances[merchantId]
+		// merchant part at the locked rate
+		settlement, err := referncedPayment.Settle(reqPaid.Amount)
+		if err != nil {
+			return err
+		}
+		// the final capture releases the rest of the hold
+		var release, releaseSettlement uint64
+		if reqPaid.FinalCapture {
+			release = remaining - reqPaid.Amount
+			releaseSettlement = referncedPayment.RemainingSettlement() - settlement
+		}
+		if personalBalance.BlockedMoney < reqPaid.Amount+release ||
+			merchantBalance.BlockedMoney < settlement+releaseSettlement {
+			return errBlockedMoney
+		}
+		referncedPayment.CapturedAmount += reqPaid.Amount
+		referncedPayment.ReleasedAmount += release
+		referncedPayment.SettledAmount += settlement + releaseSettlement
+		referncedPayment, err = s.storage.UpdatePayment(ctx, tx, referncedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Successful payment")
+		completedPayment = types.CreateCompletePayment(reqPaid, referncedPayment, types.StatusCaptured)
+		completedPayment.SettlementAmount = settlement
 		completedPayment, err = s.storage.SavePayment(ctx, tx, completedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
 		// update new personal account balance and append new statement
-		personalAccount, err := s.storage.GetAccountByCard(ctx, referncedPayment.CardNumber)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance:      int64(release),
+			BlockedMoney: -int64(reqPaid.Amount + release),
+		}); err != nil {
+			return err
 		}
-		personalAccount.BlockedMoney = personalAccount.BlockedMoney - reqPaid.Amount
-		personalAccount, err = s.storage.SaveBalance(ctx, tx, personalAccount, 0, personalAccount.BlockedMoney)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		// ledger
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.CaptureEntry(completedPayment, buyerId, merchantId)); err != nil {
+			return err
 		}
-		personalAccount.Statement = append(personalAccount.Statement, completedPayment.ID.String())
-		personalAccount, err = s.storage.UpdateStatement(ctx, tx, personalAccount.ID, completedPayment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, completedPayment.ID); err != nil {
+			return err
 		}
 		// update new merchant balance and append new statement
-		merchant.Balance = merchant.Balance + reqPaid.Amount
-		merchant.BlockedMoney = merchant.BlockedMoney - reqPaid.Amount
-		merchant, err = s.storage.SaveBalance(ctx, tx, merchant, merchant.Balance, merchant.BlockedMoney)
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			Balance:      int64(settlement),
+			BlockedMoney: -int64(settlement + releaseSettlement),
+		}); err != nil {
+			return err
+		}
+		if _, err := s.storage.UpdateStatement(ctx, tx, merchantId, completedPayment.ID); err != nil {
+			return err
+		}
+		if release == 0 {
+			return nil
+		}
+		// released rest of the hold
+		releasedPayment := types.CreateCompletePayment(&types.PaidRequest{
+			OrderId:   reqPaid.OrderId,
+			PaymentId: paymentId,
+			Operation: types.OperationCancel,
+			Amount:    release,
+		}, referncedPayment, types.StatusVoided)
+		releasedPayment.SettlementAmount = releaseSettlement
+		releasedPayment, err = s.storage.SavePayment(ctx, tx, releasedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
-		merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, completedPayment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		} 
-		return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-			ID:     completedPayment.ID,
-			Status: completedPayment.Status,
-		})
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.CancelEntry(releasedPayment, buyerId, merchantId)); err != nil {
+			return err
+		}
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, releasedPayment.ID); err != nil {
+			return err
+		}
+		_, err = s.storage.UpdateStatement(ctx, tx, merchantId, releasedPayment.ID)
+		return err
+	}); err != nil {
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-		ID:     reqPaid.PaymentId,
-		Status: "Invalid transaction",
+		ID:     completedPayment.ID,
+		Status: completedPayment.Status,
+		Reason: completedPayment.Reason,
 	})
 }
 
@@ -294,124 +343,172 @@ func (s *JSONApiServer) capturePayment(w http.ResponseWriter, r *http.Request) e
 // @Produce json
 // @Param id path string true "refund payment info"
 // @Param input body types.PaidRequest true "refund payment info"
+// @Param Idempotency-Key header string false "idempotency key"
 // @Success 200 {object} types.PaymentResponse
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
+// @Failure 409  {object}  api.ApiError
+// @Failure 422  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
 // @Router /payment/refund/{id} [post]
 func (s *JSONApiServer) refundPayment(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.refundPayment")
 	defer span.Finish()
-	
+
 	reqPaid := &types.PaidRequest{}
 	if err := json.NewDecoder(r.Body).Decode(reqPaid); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
+	if err := utils.ValidatePaidRequest(reqPaid); err != nil {
+		return s.writeError(w, err)
+	}
 	// payment id
 	paymentId, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// get merchant
-	merchantId, err := getMerchantID(r)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-	}
-	merchant, err := s.storage.GetAccountByID(ctx, merchantId)
+	merchant, err := getMerchant(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	// check referenced payment
-	reqPaid.Operation = "Refund"
+	merchantId := merchant.ID
+	reqPaid.Operation = types.OperationRefund
 	reqPaid.PaymentId = paymentId
 	referncedPayment, err := s.storage.GetPaymentByID(ctx, paymentId)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	if referncedPayment.Operation == "Capture" && referncedPayment.Status == "Successful payment" {
-		// Invalid amount
-		if referncedPayment.Amount < reqPaid.Amount {
-			// Begin Transaction
-			tx, err := s.db.BeginTx(ctx, nil)
-			defer tx.Rollback()
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			}
-			completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Invalid amount")
-			invalidPayment, err := s.storage.SavePayment(ctx, tx, completedPayment)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-			}
-			merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
-			merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, invalidPayment.ID)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-			}
-			// Commit transaction
-			if err := tx.Commit(); err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			} 
-			return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-				ID:     invalidPayment.ID,
-				Status: invalidPayment.Status,
-			})
+	if referncedPayment.BusinessId != merchantId {
+		return s.writeError(w, errPayment)
+	}
+	buyerId := referncedPayment.AccountId
+	if buyerId == uuid.Nil {
+		return s.writeError(w, errBuyer)
+	}
+	var completedPayment *types.Payment
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// check referenced payment
+		referncedPayment, err := s.storage.LockPayment(ctx, tx, paymentId)
+		if err != nil {
+			return err
+		}
+		if err := referncedPayment.Apply(types.OperationRefund, reqPaid.Amount); err != nil {
+			return err
+		}
+		// refunds can't exceed the captured amount
+		if referncedPayment.Remaining() < reqPaid.Amount {
+			return errRefundAmount
 		}
 		// Successful refund
-		// Begin transaction
-		tx, err := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			buyerId:    referncedPayment.Currency,
+			merchantId: referncedPayment.SettlementCurrency,
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
+			}
+			return err
+		}
+		personalBalance, merchantBalance := balances[buyerId], balances[merchantId]
+		// merchant part at the original rate
+		settlement, err := referncedPayment.Settle(reqPaid.Amount)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
+			return err
 		}
-		referncedPayment.Amount = referncedPayment.Amount - reqPaid.Amount
-		referncedPayment, err = s.storage.SavePayment(ctx, tx, referncedPayment)
+		if merchantBalance.Balance < settlement {
+			return errMerchantBalance
+		}
+		referncedPayment.RefundedAmount += reqPaid.Amount
+		referncedPayment.SettledAmount += settlement
+		referncedPayment, err = s.storage.UpdatePayment(ctx, tx, referncedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Successful refund")
+		completedPayment = types.CreateCompletePayment(reqPaid, referncedPayment, types.StatusRefunded)
+		completedPayment.SettlementAmount = settlement
 		completedPayment, err = s.storage.SavePayment(ctx, tx, completedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
 		// update new personal account balance and append new statement
-		personalAccount, err := s.storage.GetAccountByCard(ctx, referncedPayment.CardNumber)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance: int64(reqPaid.Amount),
+		}); err != nil {
+			return err
 		}
-		personalAccount.Balance = personalAccount.Balance + reqPaid.Amount
-		personalAccount, err = s.storage.SaveBalance(ctx, tx, personalAccount, personalAccount.Balance, 0)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		// ledger
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.RefundEntry(completedPayment, buyerId, merchantId)); err != nil {
+			return err
 		}
-		personalAccount.Statement = append(personalAccount.Statement, completedPayment.ID.String())
-		personalAccount, err = s.storage.UpdateStatement(ctx, tx, personalAccount.ID, completedPayment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, completedPayment.ID); err != nil {
+			return err
 		}
 		// update new merchant balance and append new statement
-		merchant.Balance = merchant.Balance - reqPaid.Amount
-		merchant, err = s.storage.SaveBalance(ctx, tx, merchant, merchant.Balance, 0)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			Balance: -int64(settlement),
+		}); err != nil {
+			return err
 		}
-		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
-		merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, completedPayment.ID)
-		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		} 
-		return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-			ID:     completedPayment.ID,
-			Status: completedPayment.Status,
-		})
+		_, err = s.storage.UpdateStatement(ctx, tx, merchantId, completedPayment.ID)
+		return err
+	}); err != nil {
+		return s.writeError(w, err)
 	}
 	return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-		ID:     reqPaid.PaymentId,
-		Status: "Invalid transaction",
+		ID:     completedPayment.ID,
+		Status: completedPayment.Status,
+		Reason: completedPayment.Reason,
+	})
+}
+
+// getRefunds godoc
+// @Summary Refund history
+// @Description Refunds of the capture
+// @Tags Payment
+// @Produce json
+// @Param id path string true "capture payment id"
+// @Success 200 {object} types.RefundHistory
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
+// @Router /payment/{id}/refunds [get]
+func (s *JSONApiServer) getRefunds(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.getRefunds")
+	defer span.Finish()
+	// capture id
+	paymentId, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	merchant, err := getMerchant(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	merchantId := merchant.ID
+	capture, err := s.storage.GetPaymentByID(ctx, paymentId)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if capture.Operation != types.OperationCapture || capture.BusinessId != merchantId {
+		return s.writeError(w, errCapture)
+	}
+	refunds, err := s.storage.GetRefunds(ctx, paymentId)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, types.RefundHistory{
+		CaptureID:      capture.ID,
+		Currency:       capture.Currency,
+		CapturedAmount: capture.Amount,
+		RefundedAmount: capture.RefundedAmount,
+		Refunds:        refunds,
 	})
 }
 
@@ -423,10 +520,15 @@ func (s *JSONApiServer) refundPayment(w http.ResponseWriter, r *http.Request) er
 // @Produce json
 // @Param id path string true "cancel payment info"
 // @Param input body types.PaidRequest true "cancel payment info"
+// @Param Idempotency-Key header string false "idempotency key"
 // @Success 200 {object} types.PaymentResponse
 // @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
 // @Failure 404  {object}  api.ApiError
+// @Failure 409  {object}  api.ApiError
+// @Failure 422  {object}  api.ApiError
 // @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
 // @Router /payment/cancel/{id} [post]
 func (s *JSONApiServer) cancelPayment(w http.ResponseWriter, r *http.Request) error {
 	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.cancelPayment")
@@ -434,123 +536,276 @@ func (s *JSONApiServer) cancelPayment(w http.ResponseWriter, r *http.Request) er
 	// payment id
 	paymentId, err := GetUUID(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
 	// paid request
 	reqPaid := &types.PaidRequest{}
 	if err := json.NewDecoder(r.Body).Decode(reqPaid); err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, types.InvalidError(err))
 	}
 	defer r.Body.Close()
-	// get merchant
-	merchantId, err := getMerchantID(r)
-	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+	if err := utils.ValidatePaidRequest(reqPaid); err != nil {
+		return s.writeError(w, err)
 	}
-	merchant, err := s.storage.GetAccountByID(ctx, merchantId)
+	// get merchant
+	merchant, err := getMerchant(r)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	// check referenced payment
-	reqPaid.Operation = "Cancel"
+	merchantId := merchant.ID
+	reqPaid.Operation = types.OperationCancel
 	reqPaid.PaymentId = paymentId
 	referncedPayment, err := s.storage.GetPaymentByID(ctx, paymentId)
 	if err != nil {
-		return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		return s.writeError(w, err)
 	}
-	if referncedPayment.Operation == "Authorization" && referncedPayment.Status == "Approved" {
+	if referncedPayment.BusinessId != merchantId {
+		return s.writeError(w, errPayment)
+	}
+	buyerId := referncedPayment.AccountId
+	if buyerId == uuid.Nil {
+		return s.writeError(w, errBuyer)
+	}
+	var completedPayment *types.Payment
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// check referenced payment
+		referncedPayment, err := s.storage.LockPayment(ctx, tx, paymentId)
+		if err != nil {
+			return err
+		}
+		if err := referncedPayment.Apply(types.OperationCancel, reqPaid.Amount); err != nil {
+			return err
+		}
 		// Invalid amount
-		if referncedPayment.Amount < reqPaid.Amount {
-			// Begin transaction
-			tx, err := s.db.BeginTx(ctx, nil)
-			defer tx.Rollback()
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			}
-			completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Invalid amount")
-			invalidPayment, err := s.storage.SavePayment(ctx, tx, completedPayment)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-			}
-			merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
-			merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, invalidPayment.ID)
-			if err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		if referncedPayment.Remaining() < reqPaid.Amount {
+			return errAmount
+		}
+		// Successful cancel
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			buyerId:    referncedPayment.Currency,
+			merchantId: referncedPayment.SettlementCurrency,
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
 			}
-			// Commit transaction
-			if err := tx.Commit(); err != nil {
-				return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-			} 
-			return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-				ID:     invalidPayment.ID,
-				Status: invalidPayment.Status,
-			})
+			return err
 		}
-		// Successful refund
-		// Begin transaction
-		tx, err := s.db.BeginTx(ctx, nil)
-		defer tx.Rollback()
+		personalBalance, merchantBalance := balances[buyerId], balances[merchantId]
+		// merchant part at the locked rate
+		settlement, err := referncedPayment.Settle(reqPaid.Amount)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
+			return err
 		}
-		referncedPayment.Amount = referncedPayment.Amount - reqPaid.Amount
-		referncedPayment, err = s.storage.SavePayment(ctx, tx, referncedPayment)
+		if personalBalance.BlockedMoney < reqPaid.Amount || merchantBalance.BlockedMoney < settlement {
+			return errBlockedMoney
+		}
+		referncedPayment.ReleasedAmount += reqPaid.Amount
+		referncedPayment.SettledAmount += settlement
+		referncedPayment, err = s.storage.UpdatePayment(ctx, tx, referncedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		completedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, "Successful cancel")
+		completedPayment = types.CreateCompletePayment(reqPaid, referncedPayment, types.StatusVoided)
+		completedPayment.SettlementAmount = settlement
 		completedPayment, err = s.storage.SavePayment(ctx, tx, completedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
 		// update new personal account balance and append new statement
-		personalAccount, err := s.storage.GetAccountByCard(ctx, referncedPayment.CardNumber)
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance:      int64(reqPaid.Amount),
+			BlockedMoney: -int64(reqPaid.Amount),
+		}); err != nil {
+			return err
+		}
+		// ledger
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.CancelEntry(completedPayment, buyerId, merchantId)); err != nil {
+			return err
+		}
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, completedPayment.ID); err != nil {
+			return err
+		}
+		// update new merchant balance and append new statement
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			BlockedMoney: -int64(settlement),
+		}); err != nil {
+			return err
+		}
+		_, err = s.storage.UpdateStatement(ctx, tx, merchantId, completedPayment.ID)
+		return err
+	}); err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, types.PaymentResponse{
+		ID:     completedPayment.ID,
+		Status: completedPayment.Status,
+		Reason: completedPayment.Reason,
+	})
+}
+
+// incrementPayment godoc
+// @Summary Increment authorization
+// @Description Increment authorization: raise the hold of the authorization
+// @Tags Payment
+// @Accept json
+// @Produce json
+// @Param id path string true "authorization payment id"
+// @Param input body types.PaidRequest true "increment payment info"
+// @Param Idempotency-Key header string false "idempotency key"
+// @Success 200 {object} types.PaymentResponse
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 409  {object}  api.ApiError
+// @Failure 422  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Security MerchantKey
+// @Router /payment/{id}/increment [post]
+func (s *JSONApiServer) incrementPayment(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Payment.incrementPayment")
+	defer span.Finish()
+	// payment id
+	paymentId, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	// paid request
+	reqPaid := &types.PaidRequest{}
+	if err := json.NewDecoder(r.Body).Decode(reqPaid); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	if err := utils.ValidatePaidRequest(reqPaid); err != nil {
+		return s.writeError(w, err)
+	}
+	// get merchant
+	merchant, err := getMerchant(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	merchantId := merchant.ID
+	reqPaid.Operation = types.OperationIncrement
+	reqPaid.PaymentId = paymentId
+	referncedPayment, err := s.storage.GetPaymentByID(ctx, paymentId)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if referncedPayment.BusinessId != merchantId {
+		return s.writeError(w, errPayment)
+	}
+	buyerId := referncedPayment.AccountId
+	if buyerId == uuid.Nil {
+		return s.writeError(w, errBuyer)
+	}
+	var completedPayment *types.Payment
+	if err := psql.WithTx(ctx, s.db, func(tx *sql.Tx) error {
+		// check referenced payment
+		referncedPayment, err := s.storage.LockPayment(ctx, tx, paymentId)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		personalAccount.Balance = personalAccount.Balance + reqPaid.Amount
-		personalAccount.BlockedMoney = personalAccount.BlockedMoney - reqPaid.Amount
-		personalAccount, err = s.storage.SaveBalance(ctx, tx, personalAccount, personalAccount.Balance, personalAccount.BlockedMoney)
+		if err := referncedPayment.Apply(types.OperationIncrement, reqPaid.Amount); err != nil {
+			return err
+		}
+		if referncedPayment.ExpiresAt != nil && referncedPayment.ExpiresAt.Before(time.Now()) {
+			return errExpired
+		}
+		balances, err := s.storage.LockBalances(ctx, tx, map[uuid.UUID]string{
+			buyerId:    referncedPayment.Currency,
+			merchantId: referncedPayment.SettlementCurrency,
+		})
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			if errors.Is(err, sql.ErrNoRows) {
+				return errCurrency
+			}
+			return err
+		}
+		personalBalance, merchantBalance := balances[buyerId], balances[merchantId]
+		// balance < increment amount
+		if personalBalance.Balance < reqPaid.Amount {
+			failedPayment := types.CreateCompletePayment(reqPaid, referncedPayment, types.StatusFailed)
+			failedPayment.Reason = types.ReasonInsufficientFunds
+			completedPayment, err = s.storage.SavePayment(ctx, tx, failedPayment)
+			if err != nil {
+				return err
+			}
+			_, err = s.storage.UpdateStatement(ctx, tx, merchantId, completedPayment.ID)
+			return err
 		}
-		personalAccount.Statement = append(personalAccount.Statement, completedPayment.ID.String())
-		personalAccount, err = s.storage.UpdateStatement(ctx, tx, personalAccount.ID, completedPayment.ID)
+		// merchant part at the locked rate
+		settlement, err := referncedPayment.ExchangeRate().Convert(reqPaid.Amount)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		// update new merchant balance and append new statement
-		merchant.BlockedMoney = merchant.BlockedMoney - reqPaid.Amount
-		merchant, err = s.storage.SaveBalance(ctx, tx, merchant, 0, merchant.BlockedMoney)
+		referncedPayment.Amount += reqPaid.Amount
+		referncedPayment.SettlementAmount += settlement
+		referncedPayment, err = s.storage.UpdatePayment(ctx, tx, referncedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			return err
 		}
-		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
-		merchant, err = s.storage.UpdateStatement(ctx, tx, merchant.ID, completedPayment.ID)
+		completedPayment = types.CreateCompletePayment(reqPaid, referncedPayment, types.StatusAuthorized)
+		completedPayment.SettlementAmount = settlement
+		completedPayment, err = s.storage.SavePayment(ctx, tx, completedPayment)
 		if err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
-		}
-		// Commit transaction
-		if err := tx.Commit(); err != nil {
-			return WriteJSON(w, http.StatusBadRequest, ApiError{Error: "wrong transaction"})
-		} 
-		return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-			ID:     completedPayment.ID,
-			Status: completedPayment.Status,
-		})
+			return err
+		}
+		// block the increment on the personal balance and append new statement
+		if _, err := s.storage.SaveBalance(ctx, tx, personalBalance, types.BalanceDelta{
+			Balance:      -int64(reqPaid.Amount),
+			BlockedMoney: int64(reqPaid.Amount),
+		}); err != nil {
+			return err
+		}
+		// ledger
+		if _, err := s.storage.SaveJournalEntry(ctx, tx, types.AuthorizationEntry(completedPayment, buyerId, merchantId)); err != nil {
+			return err
+		}
+		if _, err := s.storage.UpdateStatement(ctx, tx, buyerId, completedPayment.ID); err != nil {
+			return err
+		}
+		// merchant pending money and append new statement
+		if _, err := s.storage.SaveBalance(ctx, tx, merchantBalance, types.BalanceDelta{
+			BlockedMoney: int64(settlement),
+		}); err != nil {
+			return err
+		}
+		_, err = s.storage.UpdateStatement(ctx, tx, merchantId, completedPayment.ID)
+		return err
+	}); err != nil {
+		return s.writeError(w, err)
+	}
+	// the failed payment is committed, the decline isn't retryable
+	if completedPayment.Status == types.StatusFailed {
+		return s.writeError(w, &types.DeclineError{Code: types.CodeInsufficientFunds, Payment: completedPayment})
 	}
 	return WriteJSON(w, http.StatusOK, types.PaymentResponse{
-		ID:     reqPaid.PaymentId,
-		Status: "Invalid transaction",
+		ID:     completedPayment.ID,
+		Status: completedPayment.Status,
 	})
 }
 
-// get merchant id
-func getMerchantID(r *http.Request) (uuid.UUID, error) {
-	id := r.Header.Get("From")
-	merchantID, err := uuid.Parse(id)
-	if err != nil {
-		return uuid.Nil, err
+var (
+	errBlockedMoney    = types.NewError(types.CodeInsufficientFunds, "not enough blocked money")
+	errMerchantBalance = types.NewError(types.CodeInsufficientFunds, "not enough merchant balance")
+	errCurrency        = types.NewError(types.CodeInvalid, "account doesn't hold the payment currency")
+	errRefundAmount    = types.NewError(codeRefundExceeded, "refund amount exceeds the captured amount not refunded yet")
+	errAmount          = types.NewError(codeAmountExceeded, "amount exceeds the authorized amount not captured or released yet")
+	errCapture         = types.NewError(types.CodeNotFound, "merchant has no capture with this id")
+	errPayment         = types.NewError(types.CodeNotFound, "merchant has no payment with this id")
+	errAPIKey          = types.NewError(types.CodeUnauthorized, "invalid api key")
+	errExpired         = types.NewError(codeExpired, "authorization is expired")
+	// both wallets of a payment are locked and changed separately
+	errSelfPayment = types.NewError(types.CodeInvalid, "merchant account can't pay itself")
+	// older payments whose buyer migration 000022_payment_account didn't find
+	errBuyer = types.NewError(types.CodeConflict, "payment has no buyer account")
+)
+
+// get merchant authenticated by the API key
+func getMerchant(r *http.Request) (*types.Account, error) {
+	merchant, ok := r.Context().Value(merchantContextKey).(*types.Account)
+	if !ok {
+		return nil, errAPIKey
 	}
-	return merchantID, nil
-}
\ No newline at end of file
+	return merchant, nil
+}
@@ -1,18 +1,23 @@
 package api
 
 import (
+	"database/sql"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	mockstore "github.com/Edbeer/paymentapi/api/mock"
 	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/fx"
 	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/require"
 )
@@ -30,7 +35,7 @@ func Test_CreatePayment(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 
 	uid := uuid.New()
 	reqPay := &types.PaymentRequest{
@@ -38,9 +43,9 @@ func Test_CreatePayment(t *testing.T) {
 		OrderId:          "1",
 		Amount:           50,
 		Currency:         "RUB",
-		CardNumber:       "4444444444444444",
+		CardNumber:       "4242424242424242",
 		CardExpiryMonth:  "12",
-		CardExpiryYear:   "24",
+		CardExpiryYear:   "30",
 		CardSecurityCode: "924",
 	}
 	err = utils.ValidatePaymentRequest(reqPay)
@@ -61,15 +66,19 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          50,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		accountBalance := &types.Balance{
+			AccountID:    uid,
+			Currency:     "RUB",
+			Balance:      50,
+			BlockedMoney: 0,
+		}
 
 		// merchant
 		mid := uuid.New()
@@ -77,27 +86,31 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 0,
+		}
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, uid).Return(account, nil).AnyTimes()
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		account.Balance = account.Balance - reqPay.Amount
-		account.BlockedMoney = account.BlockedMoney + reqPay.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, account, account.Balance, account.BlockedMoney).Return(account, nil).AnyTimes()
-		merchant.BlockedMoney = merchant.BlockedMoney + reqPay.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchant, merchant.Balance, merchant.BlockedMoney).Return(merchant, nil).AnyTimes()
+		accountBalance.Balance = accountBalance.Balance - reqPay.Amount
+		accountBalance.BlockedMoney = accountBalance.BlockedMoney + reqPay.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, accountBalance, types.BalanceDelta{Balance: -int64(reqPay.Amount), BlockedMoney: int64(reqPay.Amount)}).Return(accountBalance, nil).AnyTimes()
+		merchantBalance.BlockedMoney = merchantBalance.BlockedMoney + reqPay.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchantBalance, types.BalanceDelta{BlockedMoney: int64(reqPay.Amount)}).Return(merchantBalance, nil).AnyTimes()
 
 		
-		payment := types.CreateAuthPayment(reqPay, account, merchant, "Approved")
+		payment := types.CreateAuthPayment(reqPay, account, merchant, types.StatusAuthorized)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, payment).Return(payment, nil).AnyTimes()
 
 		merchant.Statement = append(merchant.Statement, payment.ID.String())
@@ -110,10 +123,10 @@ func Test_CreatePayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, payment.ID.String())
-		require.Equal(t, merchant.Balance, uint64(0))
-		require.Equal(t, merchant.BlockedMoney, uint64(50))
-		require.Equal(t, account.Balance, uint64(0))
-		require.Equal(t, account.BlockedMoney, uint64(50))
+		require.Equal(t, merchantBalance.Balance, uint64(0))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(50))
+		require.Equal(t, accountBalance.Balance, uint64(0))
+		require.Equal(t, accountBalance.BlockedMoney, uint64(50))
 	})
 
 	t.Run("Wrong payment request", func(t *testing.T) {
@@ -122,12 +135,10 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          50,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		}
@@ -138,12 +149,10 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444234",
+			CardToken:        "tok_444444444444234",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		}
@@ -151,7 +160,7 @@ func Test_CreatePayment(t *testing.T) {
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		payment := types.CreateAuthPayment(reqPay, account, merchant, "wrong payment request")
+		payment := types.CreateAuthPayment(reqPay, account, merchant, types.StatusFailed)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, payment).Return(payment, nil).AnyTimes()
 		
 		merchant.Statement = append(merchant.Statement, payment.ID.String())
@@ -160,7 +169,7 @@ func Test_CreatePayment(t *testing.T) {
 		err = server.createPayment(recorder, request)
 		require.NoError(t, err)
 		require.Nil(t, err)
-		require.NotEqual(t, account.CardNumber, reqPay.CardNumber)
+		require.NotEqual(t, "tok_"+reqPay.CardNumber, account.CardToken)
 	})
 
 	t.Run("Insufficient funds", func(t *testing.T) {
@@ -169,15 +178,19 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          30,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		}
+		accountBalance := &types.Balance{
+			AccountID:    uid,
+			Currency:     "RUB",
+			Balance:      30,
+			BlockedMoney: 0,
+		}
 
 		// merchant
 		mid := uuid.New()
@@ -185,22 +198,26 @@ func Test_CreatePayment(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444234",
+			CardToken:        "tok_444444444444234",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        make([]string, 1),
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 0,
+		}
 
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, uid).Return(account, nil).AnyTimes()
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
-		require.Less(t, account.Balance, reqPay.Amount)
+		require.Less(t, accountBalance.Balance, reqPay.Amount)
 
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		payment := types.CreateAuthPayment(reqPay, account, merchant, "wrong payment request")
+		payment := types.CreateAuthPayment(reqPay, account, merchant, types.StatusFailed)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, payment).Return(payment, nil).AnyTimes()
 
 		merchant.Statement = append(merchant.Statement, payment.ID.String())
@@ -210,8 +227,38 @@ func Test_CreatePayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, payment.ID.String())
-		require.Equal(t, merchant.BlockedMoney, uint64(0))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(0))
+	})
+}
+
+func Test_CreatePaymentSelf(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// no storage calls, the wallets would collapse into one
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	mid := uuid.New()
+	buffer, err := utils.AnyToBytesBuffer(&types.PaymentRequest{
+		AccountId:        mid,
+		OrderId:          "1",
+		Amount:           50,
+		Currency:         "RUB",
+		CardNumber:       "4242424242424242",
+		CardExpiryMonth:  "12",
+		CardExpiryYear:   "30",
+		CardSecurityCode: "924",
 	})
+	require.NoError(t, err)
+	request := withMerchant(httptest.NewRequest(http.MethodPost, "/payment/auth", buffer), &types.Account{ID: mid})
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.createPayment(recorder, request))
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	require.Contains(t, recorder.Body.String(), errSelfPayment.Error())
 }
 
 func Test_CapturePayment(t *testing.T) {
@@ -226,7 +273,7 @@ func Test_CapturePayment(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	pid := uuid.New()
 	reqPaid := &types.PaidRequest{
 		OrderId:   "1",
@@ -254,60 +301,68 @@ func Test_CapturePayment(t *testing.T) {
 			OrderId:         "1",
 			Operation:       "Authorization",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusAuthorized,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		merchant := &types.Account{
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{pid.String()},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 		uid := uuid.New()
 		account := &types.Account{
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{pid.String()},
 			CreatedAt:        time.Now(),
 		}
+		accountBalance := &types.Balance{
+			AccountID:    uid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
+		refPayment.AccountId = account.ID
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		refPayment.Amount = refPayment.Amount - reqPaid.Amount
-		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
+		refPayment.CapturedAmount = refPayment.CapturedAmount + reqPaid.Amount
+		mockStorage.EXPECT().UpdatePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
 
-		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, "Successful payment")
+		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusCaptured)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, completedPayment).Return(completedPayment, nil).AnyTimes()
 
-		mockStorage.EXPECT().GetAccountByCard(ctxWithTrace, refPayment.CardNumber).Return(account, nil).AnyTimes()
-		account.BlockedMoney = account.BlockedMoney - reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, account, account.Balance, account.BlockedMoney).Return(account, nil).AnyTimes()
+		accountBalance.BlockedMoney = accountBalance.BlockedMoney - reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, accountBalance, types.BalanceDelta{BlockedMoney: -int64(reqPaid.Amount)}).Return(accountBalance, nil).AnyTimes()
 		account.Statement = append(account.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, uid, completedPayment.ID).Return(account, nil).AnyTimes()
 
-		merchant.Balance = merchant.Balance + reqPaid.Amount
-		merchant.BlockedMoney = merchant.BlockedMoney - reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchant, merchant.Balance, merchant.BlockedMoney).Return(merchant, nil).AnyTimes()
+		merchantBalance.Balance = merchantBalance.Balance + reqPaid.Amount
+		merchantBalance.BlockedMoney = merchantBalance.BlockedMoney - reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchantBalance, types.BalanceDelta{Balance: int64(reqPaid.Amount), BlockedMoney: -int64(reqPaid.Amount)}).Return(merchantBalance, nil).AnyTimes()
 		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, mid, completedPayment.ID).Return(merchant, nil).AnyTimes()
 
@@ -315,9 +370,9 @@ func Test_CapturePayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, completedPayment.ID.String())
-		require.Equal(t, merchant.BlockedMoney, uint64(0))
-		require.Equal(t, merchant.Balance, uint64(50))
-		require.Equal(t, account.Balance, uint64(0))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(0))
+		require.Equal(t, merchantBalance.Balance, uint64(50))
+		require.Equal(t, accountBalance.Balance, uint64(0))
 	})
 
 	t.Run("Invalid amount", func(t *testing.T) {
@@ -328,32 +383,36 @@ func Test_CapturePayment(t *testing.T) {
 			OrderId:         "1",
 			Operation:       "Authorization",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusAuthorized,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		merchant := &types.Account{
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{pid.String()},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, "Invalid amount")
+		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusFailed)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, invalidPayment).Return(invalidPayment, nil).AnyTimes()
 
 		merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
@@ -363,7 +422,7 @@ func Test_CapturePayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, invalidPayment.ID.String())
-		require.Equal(t, merchant.BlockedMoney, uint64(50))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(50))
 	})
 }
 
@@ -379,7 +438,7 @@ func Test_RefundPayment(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	pid := uuid.New()
 	reqPaid := &types.PaidRequest{
 		OrderId:   "1",
@@ -404,30 +463,38 @@ func Test_RefundPayment(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          50,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      50,
+			BlockedMoney: 0,
+		}
 
 		uid := uuid.New()
 		account := &types.Account{
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		accountBalance := &types.Balance{
+			AccountID:    uid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 0,
+		}
 
 		refPayment := &types.Payment{
 			ID:              uuid.New(),
@@ -435,31 +502,31 @@ func Test_RefundPayment(t *testing.T) {
 			OrderId:         "1",
 			Operation:       "Capture",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusCaptured,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
+		refPayment.AccountId = account.ID
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		refPayment.Amount = refPayment.Amount - reqPaid.Amount
-		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
+		refPayment.RefundedAmount = refPayment.RefundedAmount + reqPaid.Amount
+		mockStorage.EXPECT().UpdatePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
 
-		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, "Successful refund")
+		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusRefunded)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, completedPayment).Return(completedPayment, nil).AnyTimes()
 
-		mockStorage.EXPECT().GetAccountByCard(ctxWithTrace, refPayment.CardNumber).Return(account, nil).AnyTimes()
-		account.Balance = account.Balance + reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, account, account.Balance, account.BlockedMoney).Return(account, nil).AnyTimes()
+		accountBalance.Balance = accountBalance.Balance + reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, accountBalance, types.BalanceDelta{Balance: int64(reqPaid.Amount)}).Return(accountBalance, nil).AnyTimes()
 		account.Statement = append(account.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, uid, completedPayment.ID).Return(account, nil).AnyTimes()
 
-		merchant.Balance = merchant.Balance - reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchant, merchant.Balance, merchant.BlockedMoney).Return(merchant, nil).AnyTimes()
+		merchantBalance.Balance = merchantBalance.Balance - reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchantBalance, types.BalanceDelta{Balance: -int64(reqPaid.Amount)}).Return(merchantBalance, nil).AnyTimes()
 		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, mid, completedPayment.ID).Return(merchant, nil).AnyTimes()
 
@@ -467,8 +534,8 @@ func Test_RefundPayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, completedPayment.ID.String())
-		require.Equal(t, merchant.Balance, uint64(0))
-		require.Equal(t, account.Balance, uint64(50))
+		require.Equal(t, merchantBalance.Balance, uint64(0))
+		require.Equal(t, accountBalance.Balance, uint64(50))
 	})
 
 	t.Run("Invalid amount", func(t *testing.T) {
@@ -477,34 +544,38 @@ func Test_RefundPayment(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          50,
-			BlockedMoney:     0,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      50,
+			BlockedMoney: 0,
+		}
 
 		refPayment := &types.Payment{
 			ID:              uuid.New(),
 			BusinessId:      mid,
 			OrderId:         "1",
-			Operation:       "Authorization",
+			Operation:       "Capture",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusCaptured,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, "Invalid amount")
+		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusFailed)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, invalidPayment).Return(invalidPayment, nil).AnyTimes()
 
 		merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
@@ -514,7 +585,7 @@ func Test_RefundPayment(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, invalidPayment.ID.String())
-		require.Equal(t, merchant.Balance, uint64(50))
+		require.Equal(t, merchantBalance.Balance, uint64(50))
 	})
 }
 
@@ -530,7 +601,7 @@ func Test_CancelPaymen(t *testing.T) {
 
 	mockStorage := mockstore.NewMockStorage(ctrl)
 	config := &config.Config{}
-	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
 	pid := uuid.New()
 	reqPaid := &types.PaidRequest{
 		OrderId:   "1",
@@ -557,60 +628,68 @@ func Test_CancelPaymen(t *testing.T) {
 			OrderId:         "1",
 			Operation:       "Authorization",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusAuthorized,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		merchant := &types.Account{
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{pid.String()},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 		uid := uuid.New()
 		account := &types.Account{
 			ID:               uid,
 			FirstName:        "Pavel",
 			LastName:         "Voklov",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		accountBalance := &types.Balance{
+			AccountID:    uid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 
+		refPayment.AccountId = account.ID
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
-		refPayment.Amount = refPayment.Amount - reqPaid.Amount
-		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
+		refPayment.ReleasedAmount = refPayment.ReleasedAmount + reqPaid.Amount
+		mockStorage.EXPECT().UpdatePayment(ctxWithTrace, tx, refPayment).Return(refPayment, nil).AnyTimes()
 
-		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, "Successful cancel")
+		completedPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusVoided)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, completedPayment).Return(completedPayment, nil).AnyTimes()
 		
-		mockStorage.EXPECT().GetAccountByCard(ctxWithTrace, refPayment.CardNumber).Return(account, nil).AnyTimes()
-		account.Balance = account.Balance + reqPaid.Amount
-		account.BlockedMoney = account.BlockedMoney - reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, account, account.Balance, account.BlockedMoney).Return(account, nil).AnyTimes()
+		accountBalance.Balance = accountBalance.Balance + reqPaid.Amount
+		accountBalance.BlockedMoney = accountBalance.BlockedMoney - reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, accountBalance, types.BalanceDelta{Balance: int64(reqPaid.Amount), BlockedMoney: -int64(reqPaid.Amount)}).Return(accountBalance, nil).AnyTimes()
 		account.Statement = append(account.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, uid, completedPayment.ID).Return(account, nil).AnyTimes()
 
-		merchant.BlockedMoney = merchant.BlockedMoney - reqPaid.Amount
-		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchant, merchant.Balance, merchant.BlockedMoney).Return(account, nil).AnyTimes()
+		merchantBalance.BlockedMoney = merchantBalance.BlockedMoney - reqPaid.Amount
+		mockStorage.EXPECT().SaveBalance(ctxWithTrace, tx, merchantBalance, types.BalanceDelta{BlockedMoney: -int64(reqPaid.Amount)}).Return(merchantBalance, nil).AnyTimes()
 		merchant.Statement = append(merchant.Statement, completedPayment.ID.String())
 		mockStorage.EXPECT().UpdateStatement(ctxWithTrace, tx, mid, completedPayment.ID).Return(merchant, nil).AnyTimes()
 
@@ -618,8 +697,8 @@ func Test_CancelPaymen(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, completedPayment.ID.String())
-		require.Equal(t, merchant.BlockedMoney, uint64(0))
-		require.Equal(t, account.Balance, uint64(50))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(0))
+		require.Equal(t, accountBalance.Balance, uint64(50))
 	})
 
 	t.Run("Invalid amount", func(t *testing.T) {
@@ -628,15 +707,19 @@ func Test_CancelPaymen(t *testing.T) {
 			ID:               mid,
 			FirstName:        "Pasha",
 			LastName:         "Volkov",
-			CardNumber:       "444444444444434",
+			CardToken:        "tok_444444444444434",
 			CardExpiryMonth:  "10",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "934",
-			Balance:          0,
-			BlockedMoney:     50,
+			CardExpiryYear:   "30",
+			Currency:         "RUB",
 			Statement:        []string{},
 			CreatedAt:        time.Now(),
 		}
+		merchantBalance := &types.Balance{
+			AccountID:    mid,
+			Currency:     "RUB",
+			Balance:      0,
+			BlockedMoney: 50,
+		}
 
 		refPayment := &types.Payment{
 			ID:              uuid.New(),
@@ -644,18 +727,18 @@ func Test_CancelPaymen(t *testing.T) {
 			OrderId:         "1",
 			Operation:       "Authorization",
 			Amount:          50,
-			Status:          "Approved",
+			Status:          types.StatusAuthorized,
 			Currency:        "RUB",
-			CardNumber:       "444444444444444",
+			CardToken:        "tok_444444444444444",
 			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
+			CardExpiryYear:   "30",
 			CreatedAt:       time.Time{},
 		}
 		mockStorage.EXPECT().GetAccountByID(ctxWithTrace, mid).Return(merchant, nil).AnyTimes()
 		mockStorage.EXPECT().GetPaymentByID(ctxWithTrace, pid).Return(refPayment, nil).AnyTimes()
 
 		tx, _ := db.BeginTx(ctxWithTrace, nil)
-		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, "Invalid amount")
+		invalidPayment := types.CreateCompletePayment(reqPaid, refPayment, types.StatusFailed)
 		mockStorage.EXPECT().SavePayment(ctxWithTrace, tx, invalidPayment).Return(invalidPayment, nil).AnyTimes()
 
 		merchant.Statement = append(merchant.Statement, invalidPayment.ID.String())
@@ -665,6 +748,963 @@ func Test_CancelPaymen(t *testing.T) {
 		require.NoError(t, err)
 		require.Nil(t, err)
 		require.Contains(t, merchant.Statement, invalidPayment.ID.String())
-		require.Equal(t, merchant.BlockedMoney, uint64(50))
+		require.Equal(t, merchantBalance.BlockedMoney, uint64(50))
+	})
+}
+
+func Test_CreatePaymentCurrency(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	config := &config.Config{FX: config.FX{MarkupBps: 100}}
+	rates, err := fx.NewStaticProvider(map[string]string{"EUR/RUB": "80"}, time.Now())
+	require.NoError(t, err)
+	mockVault := mockstore.NewMockCardVault(ctrl)
+	server := NewJSONApiServer(config, db, nil, mockStorage, nil, mockVault, rates, nil, nil, nil)
+
+	uid, mid := uuid.New(), uuid.New()
+	account := &types.Account{
+		ID:               uid,
+		CardToken:        "tok_4242424242424242",
+		CardExpiryMonth:  "12",
+		CardExpiryYear:   "30",
+		Currency:         "RUB",
+	}
+	merchant := &types.Account{
+		ID:       mid,
+		Currency: "RUB",
+	}
+	mockVault.EXPECT().Lookup(gomock.Any(), "4242424242424242").Return(account.CardToken, nil).AnyTimes()
+	newCardRequest := func(currency, card string) *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaymentRequest{
+			AccountId:        uid,
+			OrderId:          "1",
+			Amount:           1000,
+			Currency:         currency,
+			CardNumber:       card,
+			CardExpiryMonth:  account.CardExpiryMonth,
+			CardExpiryYear:   account.CardExpiryYear,
+			CardSecurityCode: "924",
+		})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/payment/auth", buffer)
+		return withMerchant(request, merchant)
+	}
+	newRequest := func(currency string) *http.Request {
+		return newCardRequest(currency, "4242424242424242")
+	}
+
+	t.Run("Unknown currency", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newRequest("XXX"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), "invalid currency")
+	})
+
+	t.Run("Invalid card", func(t *testing.T) {
+		for card, message := range map[string]string{
+			"4242424242424241": "invalid card number",
+			"6011111111111117": "unsupported card brand",
+			// amex takes 4 digit codes
+			"378282246310005": "invalid card security code",
+		} {
+			recorder := httptest.NewRecorder()
+			err := server.createPayment(recorder, newCardRequest("RUB", card))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadRequest, recorder.Code)
+			require.Contains(t, recorder.Body.String(), message)
+		}
+	})
+
+	t.Run("Invalid fields", func(t *testing.T) {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaymentRequest{
+			AccountId:       uid,
+			Currency:        "RUB",
+			CardNumber:      "4242424242424241",
+			CardExpiryMonth: "13",
+			CardExpiryYear:  "30",
+		})
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		err = server.createPayment(recorder, withMerchant(httptest.NewRequest(http.MethodPost, "/payment/auth", buffer), merchant))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+
+		apiErr := &ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(apiErr))
+		require.Equal(t, codeValidation, apiErr.Code)
+		require.Equal(t, []utils.FieldError{
+			{Field: "amount", Code: utils.CodeRequired, Message: "must be positive"},
+			{Field: "card_number", Code: utils.CodeInvalid, Message: "invalid card number"},
+			{Field: "card_expiry_month", Code: utils.CodeInvalid, Message: "invalid card expiry month"},
+			{Field: "card_security_code", Code: utils.CodeRequired, Message: "is required"},
+		}, apiErr.Details)
+	})
+
+	t.Run("Card expired", func(t *testing.T) {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaymentRequest{
+			AccountId:        uid,
+			OrderId:          "1",
+			Amount:           1000,
+			Currency:         "RUB",
+			CardNumber:       "4242424242424242",
+			CardExpiryMonth:  "12",
+			CardExpiryYear:   "24",
+			CardSecurityCode: "924",
+		})
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		err = server.createPayment(recorder, withMerchant(httptest.NewRequest(http.MethodPost, "/payment/auth", buffer), merchant))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), "card expired")
+	})
+
+	t.Run("No exchange rate", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newRequest("USD"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), fx.ErrNoRate.Error())
+	})
+
+	t.Run("Card isn't in the vault", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockVault.EXPECT().Lookup(gomock.Any(), "5555555555554444").Return("", sql.ErrNoRows)
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.StatusFailed, payment.Status)
+				require.Equal(t, "mastercard", payment.CardBrand)
+				return payment, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(merchant, nil)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newCardRequest("RUB", "5555555555554444"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		// the failed payment is in the error
+		apiErr := &ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(apiErr))
+		require.Equal(t, types.CodeCardDeclined, apiErr.Code)
+		require.NotNil(t, apiErr.Payment)
+		require.Equal(t, types.StatusFailed, apiErr.Payment.Status)
+		require.Equal(t, types.ReasonWrongRequest, apiErr.Payment.Reason)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Currency isn't held", func(t *testing.T) {
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "EUR",
+			mid: "RUB",
+		}).Return(nil, sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newRequest("EUR"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errCurrency.Error())
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Declined", func(t *testing.T) {
+		buyerBalance := &types.Balance{AccountID: uid, Currency: "EUR", Balance: 999}
+		merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB"}
+
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+		// the failed payment is saved, balances aren't touched
+		var failed *types.Payment
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.StatusFailed, payment.Status)
+				require.Equal(t, types.ReasonInsufficientFunds, payment.Reason)
+				failed = payment
+				return payment, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(merchant, nil)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newRequest("EUR"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+		apiErr := &ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(apiErr))
+		require.Equal(t, types.CodeInsufficientFunds, apiErr.Code)
+		require.Equal(t, &types.PaymentResponse{
+			ID:     failed.ID,
+			Status: types.StatusFailed,
+			Reason: types.ReasonInsufficientFunds,
+		}, apiErr.Payment)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Exchange", func(t *testing.T) {
+		buyerBalance := &types.Balance{AccountID: uid, Currency: "EUR", Balance: 1000}
+		merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB"}
+		// 10.00 EUR at 80 with 1% markup
+		settlement := uint64(79200)
+
+		mockStorage.EXPECT().GetAccountByID(gomock.Any(), uid).Return(account, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "EUR",
+			mid: "RUB",
+		}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+			Balance:      -1000,
+			BlockedMoney: 1000,
+		}).Return(buyerBalance, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+			BlockedMoney: int64(settlement),
+		}).Return(merchantBalance, nil)
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, uint64(1000), payment.Amount)
+				require.Equal(t, "EUR", payment.Currency)
+				require.Equal(t, settlement, payment.SettlementAmount)
+				require.Equal(t, "RUB", payment.SettlementCurrency)
+				require.Equal(t, "79.2000000000", payment.FxRate)
+				require.Equal(t, "visa", payment.CardBrand)
+				require.Equal(t, uid, payment.AccountId)
+				return payment, nil
+			})
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				require.True(t, entry.Balanced())
+				return entry, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(merchant, nil)
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), uid, gomock.Any()).Return(account, nil)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		err := server.createPayment(recorder, newRequest("EUR"))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_CapturePaymentExchange(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	authorization := &types.Payment{
+		ID:                 pid,
+		BusinessId:         mid,
+		AccountId:          uid,
+		Operation:          "Authorization",
+		Amount:             1000,
+		Status:             types.StatusAuthorized,
+		Currency:           "EUR",
+		CardToken:          "tok_4242424242424242",
+		SettlementAmount:   79200,
+		SettlementCurrency: "RUB",
+		FxRate:             "79.2",
+	}
+	buyerBalance := &types.Balance{AccountID: uid, Currency: "EUR", BlockedMoney: 1000}
+	merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", BlockedMoney: 79200}
+
+	capture := func(amount, settlement uint64) {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: amount})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/payment/capture/"+pid.String(), buffer)
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		request = withMerchant(request, &types.Account{ID: mid})
+
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "EUR",
+			mid: "RUB",
+		}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+		mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), authorization).Return(authorization, nil)
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, amount, payment.Amount)
+				require.Equal(t, settlement, payment.SettlementAmount)
+				require.Equal(t, "79.2", payment.FxRate)
+				return payment, nil
+			})
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+			BlockedMoney: -int64(amount),
+		}).Return(buyerBalance, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+			Balance:      int64(settlement),
+			BlockedMoney: -int64(settlement),
+		}).Return(merchantBalance, nil)
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				require.True(t, entry.Balanced())
+				return entry, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&types.Account{}, nil).Times(2)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.capturePayment(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	t.Run("Partial capture", func(t *testing.T) {
+		// 3.33 EUR at 79.2 = 263.736 RUB, rounded down
+		capture(333, 26373)
+		require.Equal(t, types.StatusPartiallyCaptured, authorization.Status)
+		require.Equal(t, uint64(1000), authorization.Amount)
+		require.Equal(t, uint64(667), authorization.Remaining())
+		require.Equal(t, uint64(52827), authorization.RemainingSettlement())
+	})
+
+	overAmount := func(path string, handler ApiFunc) {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: 668})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, path, buffer)
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		request = withMerchant(request, &types.Account{ID: mid})
+
+		// rejected before any write
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).DoAndReturn(
+			func(_, _, _ any) (*types.Payment, error) {
+				locked := *authorization
+				return &locked, nil
+			})
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, handler(recorder, request))
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		apiErr := ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+		require.Equal(t, codeAmountExceeded, apiErr.Code)
+		require.Equal(t, uint64(667), authorization.Remaining())
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	t.Run("Over-capture", func(t *testing.T) {
+		overAmount("/payment/capture/"+pid.String(), server.capturePayment)
+	})
+
+	t.Run("Over-cancel", func(t *testing.T) {
+		overAmount("/payment/cancel/"+pid.String(), server.cancelPayment)
+	})
+
+	t.Run("Remaining capture", func(t *testing.T) {
+		// the rest settles the rest, nothing is left blocked
+		capture(667, 52827)
+		require.Equal(t, types.StatusCaptured, authorization.Status)
+		require.Equal(t, uint64(1000), authorization.CapturedAmount)
+		require.Equal(t, uint64(79200), authorization.SettledAmount)
+		require.Equal(t, uint64(0), authorization.Remaining())
+	})
+}
+
+func Test_CapturePaymentConflict(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	authorization := &types.Payment{
+		ID:         pid,
+		BusinessId: mid,
+		AccountId:  uid,
+		Operation:  types.OperationAuthorization,
+		Amount:     50,
+		Status:     types.StatusVoided,
+		Currency:   "RUB",
+		CardToken:  "tok_4242424242424242",
+	}
+	buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: 50})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/payment/capture/"+pid.String(), buffer)
+	request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+	request = withMerchant(request, &types.Account{ID: mid})
+
+	mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+	mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).Return(authorization, nil)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.capturePayment(recorder, request))
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "Capture isn't allowed")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_CapturePaymentNoBuyer(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	// created before buyers were recorded, the buyer isn't guessed by the card
+	mid, pid := uuid.New(), uuid.New()
+	authorization := &types.Payment{
+		ID:         pid,
+		BusinessId: mid,
+		Operation:  types.OperationAuthorization,
+		Amount:     50,
+		Status:     types.StatusAuthorized,
+		Currency:   "RUB",
+		CardToken:  "tok_4242424242424242",
+	}
+	buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: 50})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/payment/capture/"+pid.String(), buffer)
+	request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+	request = withMerchant(request, &types.Account{ID: mid})
+
+	mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.capturePayment(recorder, request))
+	require.Equal(t, http.StatusConflict, recorder.Code)
+	require.Contains(t, recorder.Body.String(), errBuyer.Error())
+}
+
+func Test_CapturePaymentFinal(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	authorization := &types.Payment{
+		ID:                 pid,
+		BusinessId:         mid,
+		AccountId:          uid,
+		Operation:          types.OperationAuthorization,
+		Amount:             100,
+		Status:             types.StatusPartiallyCaptured,
+		Currency:           "RUB",
+		CardToken:          "tok_4242424242424242",
+		SettlementAmount:   100,
+		SettlementCurrency: "RUB",
+		FxRate:             "1",
+		CapturedAmount:     30,
+		SettledAmount:      30,
+	}
+	buyerBalance := &types.Balance{AccountID: uid, Currency: "RUB", BlockedMoney: 70}
+	merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", Balance: 30, BlockedMoney: 70}
+
+	buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: 20, FinalCapture: true})
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/payment/capture/"+pid.String(), buffer)
+	request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+	request = withMerchant(request, &types.Account{ID: mid})
+
+	mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+	mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).Return(authorization, nil)
+	mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+		uid: "RUB",
+		mid: "RUB",
+	}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+	mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), authorization).Return(authorization, nil)
+	var operations []string
+	mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+			require.Equal(t, pid, payment.ReferenceId)
+			operations = append(operations, payment.Operation)
+			return payment, nil
+		}).Times(2)
+	// captured 20, released 50 back to the buyer
+	mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+		Balance:      50,
+		BlockedMoney: -70,
+	}).Return(buyerBalance, nil)
+	mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+		Balance:      20,
+		BlockedMoney: -70,
+	}).Return(merchantBalance, nil)
+	mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+			require.True(t, entry.Balanced())
+			return entry, nil
+		}).Times(2)
+	mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&types.Account{}, nil).Times(4)
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.capturePayment(recorder, request))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Equal(t, []string{types.OperationCapture, types.OperationCancel}, operations)
+	require.Equal(t, types.StatusCaptured, authorization.Status)
+	require.Equal(t, uint64(50), authorization.CapturedAmount)
+	require.Equal(t, uint64(50), authorization.ReleasedAmount)
+	require.Equal(t, uint64(100), authorization.SettledAmount)
+	require.Equal(t, uint64(0), authorization.Remaining())
+}
+
+func Test_RefundPaymentCumulative(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	capture := &types.Payment{
+		ID:                 pid,
+		BusinessId:         mid,
+		AccountId:          uid,
+		Operation:          types.OperationCapture,
+		Amount:             50,
+		Status:             types.StatusCaptured,
+		Currency:           "RUB",
+		CardToken:          "tok_4242424242424242",
+		SettlementAmount:   50,
+		SettlementCurrency: "RUB",
+		FxRate:             "1",
+	}
+	buyerBalance := &types.Balance{AccountID: uid, Currency: "RUB"}
+	merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", Balance: 50}
+
+	newRequest := func(amount uint64) *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: amount})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/payment/refund/"+pid.String(), buffer)
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		request = withMerchant(request, &types.Account{ID: mid})
+
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(capture, nil)
+		// locked row is a copy, changes are stored by UpdatePayment
+		mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).DoAndReturn(
+			func(_, _, _ any) (*types.Payment, error) {
+				locked := *capture
+				return &locked, nil
+			})
+		return request
+	}
+	refund := func(amount uint64) {
+		request := newRequest(amount)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "RUB",
+			mid: "RUB",
+		}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+		mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				*capture = *payment
+				return capture, nil
+			})
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, amount, payment.Amount)
+				require.Equal(t, pid, payment.ReferenceId)
+				return payment, nil
+			})
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+			Balance: int64(amount),
+		}).Return(buyerBalance, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+			Balance: -int64(amount),
+		}).DoAndReturn(func(_, _ any, balance *types.Balance, delta types.BalanceDelta) (*types.Balance, error) {
+			balance.Balance -= amount
+			return balance, nil
+		})
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).Return(&types.JournalEntry{}, nil)
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&types.Account{}, nil).Times(2)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.refundPayment(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	t.Run("Partial refund", func(t *testing.T) {
+		refund(20)
+		require.Equal(t, types.StatusPartiallyRefunded, capture.Status)
+		require.Equal(t, uint64(20), capture.RefundedAmount)
+	})
+
+	t.Run("Second partial refund", func(t *testing.T) {
+		refund(10)
+		require.Equal(t, types.StatusPartiallyRefunded, capture.Status)
+		require.Equal(t, uint64(30), capture.RefundedAmount)
+		require.Equal(t, uint64(50), capture.Amount)
+	})
+
+	t.Run("Over-refund", func(t *testing.T) {
+		request := newRequest(30)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.refundPayment(recorder, request))
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		apiErr := ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+		require.Equal(t, codeRefundExceeded, apiErr.Code)
+		require.Equal(t, uint64(30), capture.RefundedAmount)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Remaining refund", func(t *testing.T) {
+		refund(20)
+		require.Equal(t, types.StatusRefunded, capture.Status)
+		require.Equal(t, uint64(50), capture.RefundedAmount)
+		require.Equal(t, uint64(0), merchantBalance.Balance)
+	})
+}
+
+func Test_GetRefunds(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	mid, pid := uuid.New(), uuid.New()
+	capture := &types.Payment{
+		ID:             pid,
+		BusinessId:     mid,
+		Operation:      types.OperationCapture,
+		Amount:         50,
+		Status:         types.StatusPartiallyRefunded,
+		Currency:       "RUB",
+		RefundedAmount: 30,
+	}
+	refunds := []*types.Payment{
+		{ID: uuid.New(), Operation: types.OperationRefund, Amount: 20, ReferenceId: pid},
+		{ID: uuid.New(), Operation: types.OperationRefund, Amount: 10, ReferenceId: pid},
+	}
+	newRequest := func(merchantID uuid.UUID) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/payment/"+pid.String()+"/refunds", nil)
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		return withMerchant(request, &types.Account{ID: merchantID})
+	}
+
+	t.Run("GetRefunds", func(t *testing.T) {
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(capture, nil)
+		mockStorage.EXPECT().GetRefunds(gomock.Any(), pid).Return(refunds, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.getRefunds(recorder, newRequest(mid)))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		history := types.RefundHistory{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&history))
+		require.Equal(t, uint64(50), history.CapturedAmount)
+		require.Equal(t, uint64(30), history.RefundedAmount)
+		require.Len(t, history.Refunds, 2)
+	})
+
+	t.Run("Another merchant", func(t *testing.T) {
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(capture, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.getRefunds(recorder, newRequest(uuid.New())))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
+func Test_IncrementPayment(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	expiresAt := time.Now().Add(time.Hour)
+	authorization := &types.Payment{
+		ID:                 pid,
+		BusinessId:         mid,
+		AccountId:          uid,
+		Operation:          types.OperationAuthorization,
+		Amount:             1000,
+		Status:             types.StatusAuthorized,
+		Currency:           "EUR",
+		CardToken:          "tok_4242424242424242",
+		SettlementAmount:   79200,
+		SettlementCurrency: "RUB",
+		FxRate:             "79.2",
+		ExpiresAt:          &expiresAt,
+	}
+	buyerBalance := &types.Balance{AccountID: uid, Currency: "EUR", Balance: 600, BlockedMoney: 1000}
+	merchantBalance := &types.Balance{AccountID: mid, Currency: "RUB", BlockedMoney: 79200}
+
+	newRequest := func(amount uint64) *http.Request {
+		buffer, err := utils.AnyToBytesBuffer(&types.PaidRequest{OrderId: "1", Amount: amount})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/payment/"+pid.String()+"/increment", buffer)
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		request = withMerchant(request, &types.Account{ID: mid})
+
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).DoAndReturn(
+			func(_, _, _ any) (*types.Payment, error) {
+				locked := *authorization
+				return &locked, nil
+			})
+		return request
+	}
+	lockBalances := func() {
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), map[uuid.UUID]string{
+			uid: "EUR",
+			mid: "RUB",
+		}).Return(map[uuid.UUID]*types.Balance{uid: buyerBalance, mid: merchantBalance}, nil)
+	}
+
+	t.Run("Increment", func(t *testing.T) {
+		request := newRequest(500)
+		lockBalances()
+		mockStorage.EXPECT().UpdatePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				*authorization = *payment
+				return authorization, nil
+			})
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.OperationIncrement, payment.Operation)
+				require.Equal(t, pid, payment.ReferenceId)
+				require.Equal(t, uint64(500), payment.Amount)
+				require.Equal(t, uint64(39600), payment.SettlementAmount)
+				return payment, nil
+			})
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), buyerBalance, types.BalanceDelta{
+			Balance:      -500,
+			BlockedMoney: 500,
+		}).Return(buyerBalance, nil)
+		mockStorage.EXPECT().SaveBalance(gomock.Any(), gomock.Any(), merchantBalance, types.BalanceDelta{
+			BlockedMoney: 39600,
+		}).Return(merchantBalance, nil)
+		mockStorage.EXPECT().SaveJournalEntry(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, entry *types.JournalEntry) (*types.JournalEntry, error) {
+				require.True(t, entry.Balanced())
+				return entry, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&types.Account{}, nil).Times(2)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.incrementPayment(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, uint64(1500), authorization.Amount)
+		require.Equal(t, uint64(118800), authorization.SettlementAmount)
+		require.Equal(t, types.StatusAuthorized, authorization.Status)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Insufficient funds", func(t *testing.T) {
+		request := newRequest(700)
+		lockBalances()
+		var failed *types.Payment
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.StatusFailed, payment.Status)
+				require.Equal(t, types.ReasonInsufficientFunds, payment.Reason)
+				failed = payment
+				return payment, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(&types.Account{}, nil)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.incrementPayment(recorder, request))
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		apiErr := ApiError{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&apiErr))
+		require.Equal(t, types.CodeInsufficientFunds, apiErr.Code)
+		require.Equal(t, &types.PaymentResponse{
+			ID:     failed.ID,
+			Status: types.StatusFailed,
+			Reason: types.ReasonInsufficientFunds,
+		}, apiErr.Payment)
+		require.Equal(t, uint64(1500), authorization.Amount)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		authorization.ExpiresAt = &expired
+		request := newRequest(100)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.incrementPayment(recorder, request))
+		require.Equal(t, http.StatusConflict, recorder.Code)
+		require.Contains(t, recorder.Body.String(), codeExpired)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Other merchant", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/payment/"+pid.String()+"/increment", strings.NewReader(`{"amount":100}`))
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		request = withMerchant(request, &types.Account{ID: uuid.New()})
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.incrementPayment(recorder, request))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errPayment.Error())
+	})
+
+	t.Run("Voided", func(t *testing.T) {
+		authorization.ExpiresAt = &expiresAt
+		authorization.Status = types.StatusVoided
+		request := newRequest(100)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.incrementPayment(recorder, request))
+		require.Equal(t, http.StatusConflict, recorder.Code)
+		require.Contains(t, recorder.Body.String(), codeInvalidTransition)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_IncrementPaymentIdempotent(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, db, nil, mockStorage, mockRedis, nil, nil, nil, nil, nil)
+
+	uid, mid, pid := uuid.New(), uuid.New(), uuid.New()
+	expiresAt := time.Now().Add(time.Hour)
+	authorization := &types.Payment{
+		ID:                 pid,
+		BusinessId:         mid,
+		AccountId:          uid,
+		Operation:          types.OperationAuthorization,
+		Amount:             1000,
+		Status:             types.StatusAuthorized,
+		Currency:           "RUB",
+		CardToken:          "tok_4242424242424242",
+		SettlementAmount:   1000,
+		SettlementCurrency: "RUB",
+		ExpiresAt:          &expiresAt,
+	}
+	handler := server.Idempotent(server.HTTPHandler(server.incrementPayment))
+	newRequest := func() *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/payment/"+pid.String()+"/increment", strings.NewReader(`{"order_id":"1","amount":700}`))
+		request.Header.Set("Idempotency-Key", "key")
+		request = mux.SetURLVars(request, map[string]string{"id": pid.String()})
+		return withMerchant(request, &types.Account{ID: mid})
+	}
+	key := "idempotency:" + mid.String() + ":key"
+
+	t.Run("Declined", func(t *testing.T) {
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, gomock.Any(), idempotencyKeyExpire).Return(nil, nil)
+		mockStorage.EXPECT().GetPaymentByID(gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockPayment(gomock.Any(), gomock.Any(), pid).Return(authorization, nil)
+		mockStorage.EXPECT().LockBalances(gomock.Any(), gomock.Any(), gomock.Any()).Return(map[uuid.UUID]*types.Balance{
+			uid: {AccountID: uid, Currency: "RUB", Balance: 600},
+			mid: {AccountID: mid, Currency: "RUB"},
+		}, nil)
+		mockStorage.EXPECT().SavePayment(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_, _ any, payment *types.Payment) (*types.Payment, error) {
+				require.Equal(t, types.StatusFailed, payment.Status)
+				return payment, nil
+			})
+		mockStorage.EXPECT().UpdateStatement(gomock.Any(), gomock.Any(), mid, gomock.Any()).Return(&types.Account{}, nil)
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+		// the decline is stored, not deleted
+		mockRedis.EXPECT().FinishIdempotentRequest(gomock.Any(), key, gomock.Any(), idempotencyKeyExpire).DoAndReturn(
+			func(_ any, _ string, record *types.IdempotentRecord, _ int) error {
+				require.Equal(t, http.StatusUnprocessableEntity, record.Status)
+				return nil
+			})
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest())
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		// no second failed payment is written
+		mockRedis.EXPECT().StartIdempotentRequest(gomock.Any(), key, gomock.Any(), idempotencyKeyExpire).Return(&types.IdempotentRecord{
+			Fingerprint: requestFingerprint(newRequest(), []byte(`{"order_id":"1","amount":700}`)),
+			Status:      http.StatusUnprocessableEntity,
+			Body:        []byte(`{"error":"payment declined: Insufficient funds","code":"insufficient_funds"}`),
+		}, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest())
+		require.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		require.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
 	})
 }
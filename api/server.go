@@ -10,6 +10,8 @@ import (
 
 	"github.com/Edbeer/paymentapi/config"
 	_ "github.com/Edbeer/paymentapi/docs"
+	"github.com/Edbeer/paymentapi/pkg/fx"
+	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -21,25 +23,71 @@ import (
 
 // Postgres storage interface
 type Storage interface {
-	CreateAccount(ctx context.Context, reqAcc *types.RequestCreate) (*types.Account, error)
+	CreateAccount(ctx context.Context, reqAcc *types.RequestCreate, card *types.Card) (*types.Account, error)
 	GetAccount(ctx context.Context) ([]*types.Account, error)
 	GetAccountByID(ctx context.Context, id uuid.UUID) (*types.Account, error)
-	GetAccountByCard(ctx context.Context, card string) (*types.Account, error)
-	UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, id uuid.UUID) (*types.Account, error)
+	UpdateRole(ctx context.Context, id uuid.UUID, role string) (*types.Account, error)
+	UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, card *types.Card, id uuid.UUID) (*types.Account, error)
 	DeleteAccount(ctx context.Context, id uuid.UUID) error
-	DepositAccount(ctx context.Context, reqDep *types.RequestDeposit) (*types.Account, error)
+	DepositAccount(ctx context.Context, tx *sql.Tx, reqDep *types.RequestDeposit, id uuid.UUID) (*types.Balance, error)
+	GetBalances(ctx context.Context, id uuid.UUID) ([]*types.Balance, error)
+	OpenBalance(ctx context.Context, id uuid.UUID, currency string) (*types.Balance, error)
 	GetAccountStatement(ctx context.Context, id uuid.UUID) ([]string, error)
 	SavePayment(ctx context.Context, tx *sql.Tx, payment *types.Payment) (*types.Payment, error)
+	UpdatePayment(ctx context.Context, tx *sql.Tx, payment *types.Payment) (*types.Payment, error)
 	GetPaymentByID(ctx context.Context, id uuid.UUID) (*types.Payment, error)
-	SaveBalance(ctx context.Context, tx *sql.Tx, account *types.Account, balance, bmoney uint64) (*types.Account, error)
+	SaveBalance(ctx context.Context, tx *sql.Tx, balance *types.Balance, delta types.BalanceDelta) (*types.Balance, error)
 	UpdateStatement(ctx context.Context, tx *sql.Tx, id, paymentId uuid.UUID) (*types.Account, error)
+	SaveJournalEntry(ctx context.Context, tx *sql.Tx, entry *types.JournalEntry) (*types.JournalEntry, error)
+	GetLedgerBalance(ctx context.Context, id uuid.UUID, currency string) (*types.LedgerBalance, error)
+	LockBalances(ctx context.Context, tx *sql.Tx, wallets map[uuid.UUID]string) (map[uuid.UUID]*types.Balance, error)
+	LockPayment(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*types.Payment, error)
+	GetRefunds(ctx context.Context, id uuid.UUID) ([]*types.Payment, error)
+	LockExpiredPayment(ctx context.Context, tx *sql.Tx, now time.Time) (*types.Payment, error)
+	DeferExpiry(ctx context.Context, id uuid.UUID, retryAt time.Time) (int, error)
+	SaveAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error)
+	GetAPIKeys(ctx context.Context, id uuid.UUID) ([]*types.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error)
+	RevokeAPIKey(ctx context.Context, accountId, id uuid.UUID) (*types.APIKey, error)
+	SaveCredential(ctx context.Context, credential *types.Credential) (*types.Credential, error)
+	GetCredential(ctx context.Context, id uuid.UUID) (*types.Credential, error)
+	SaveFailedLogin(ctx context.Context, id uuid.UUID, maxFailures int, lockedUntil time.Time) (*types.Credential, error)
+	ResetFailedLogins(ctx context.Context, id uuid.UUID) error
+	SaveTOTP(ctx context.Context, totp *types.TOTP) (*types.TOTP, error)
+	GetTOTP(ctx context.Context, id uuid.UUID) (*types.TOTP, error)
+	UseTOTPCode(ctx context.Context, id uuid.UUID, counter int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, id uuid.UUID, hash string) (bool, error)
 }
 
 // Redis storage interface
 type RedisStorage interface {
 	CreateSession(ctx context.Context, session *types.Session, expire int) (string, error)
-	GetUserID(ctx context.Context, refreshToken string) (uuid.UUID, error)
+	RotateSession(ctx context.Context, refreshToken string, expire int) (*types.Session, error)
+	GetSessions(ctx context.Context, userID uuid.UUID) ([]*types.Session, error)
+	RevokeSession(ctx context.Context, userID, id uuid.UUID) error
+	RevokeSessions(ctx context.Context, userID uuid.UUID) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, refreshToken string) error
 	DeleteSession(ctx context.Context, refreshToken string) error
+	StartIdempotentRequest(ctx context.Context, key, fingerprint string, expire int) (*types.IdempotentRecord, error)
+	FinishIdempotentRequest(ctx context.Context, key string, record *types.IdempotentRecord, expire int) error
+	DeleteIdempotentRequest(ctx context.Context, key string) error
+	SaveNonce(ctx context.Context, key string, expire int) (bool, error)
+	CreateResetToken(ctx context.Context, id uuid.UUID, expire int) (string, error)
+	ConsumeResetToken(ctx context.Context, token string) (uuid.UUID, error)
+	CreateSignInChallenge(ctx context.Context, id uuid.UUID, expire int) (string, error)
+	ConsumeSignInChallenge(ctx context.Context, challenge string) (uuid.UUID, error)
+}
+
+// Card vault interface, card numbers are exchanged for tokens,
+// the server never detokenizes them
+type CardVault interface {
+	Tokenize(ctx context.Context, pan string) (*types.Card, error)
+	Lookup(ctx context.Context, pan string) (string, error)
+}
+
+// Account owner notifications
+type Notifier interface {
+	PasswordReset(ctx context.Context, account *types.Account, token string) error
 }
 
 // Server
@@ -47,6 +95,10 @@ type JSONApiServer struct {
 	config       *config.Config
 	storage      Storage
 	redisStorage RedisStorage
+	vault        CardVault
+	rates        fx.RateProvider
+	notifier     Notifier
+	tokens       *utils.JWTManager
 	Server       *http.Server
 	db           *sql.DB
 	redis        *redis.Client
@@ -54,12 +106,17 @@ type JSONApiServer struct {
 }
 
 // Constructor
-func NewJSONApiServer(config *config.Config, db *sql.DB, redis *redis.Client, storage Storage, redisStorage RedisStorage, logger *logrus.Logger) *JSONApiServer {
+func NewJSONApiServer(config *config.Config, db *sql.DB, redis *redis.Client, storage Storage, redisStorage RedisStorage, vault CardVault, rates fx.RateProvider, notifier Notifier, tokens *utils.JWTManager, logger *logrus.Logger) *JSONApiServer {
 	return &JSONApiServer{
+		config:       config,
 		db:           db,
 		redis:        redis,
 		storage:      storage,
 		redisStorage: redisStorage,
+		vault:        vault,
+		rates:        rates,
+		notifier:     notifier,
+		tokens:       tokens,
 		logger: logger,
 		Server: &http.Server{
 			Addr:         config.Server.Port,
@@ -74,27 +131,46 @@ func (s *JSONApiServer) Run() {
 	router := mux.NewRouter()
 	// POST
 	postRouter := router.Methods(http.MethodPost).Subrouter()
-	postRouter.HandleFunc("/account", HTTPHandler(s.createAccount))
-	postRouter.HandleFunc("/account/sign-in", HTTPHandler(s.signIn))
-	postRouter.HandleFunc("/account/sign-out", HTTPHandler(s.signOut))
-	postRouter.HandleFunc("/account/deposit", HTTPHandler(s.depositAccount))
-	postRouter.HandleFunc("/account/refresh", HTTPHandler(s.refreshTokens))
+	postRouter.HandleFunc("/account", s.HTTPHandler(s.createAccount))
+	postRouter.HandleFunc("/account/sign-in", s.HTTPHandler(s.signIn))
+	postRouter.HandleFunc("/account/sign-in/2fa", s.HTTPHandler(s.signInTOTP))
+	postRouter.HandleFunc("/account/sign-out", s.HTTPHandler(s.signOut))
+	postRouter.HandleFunc("/account/password/reset", s.HTTPHandler(s.resetPassword))
+	postRouter.HandleFunc("/account/password/reset/confirm", s.HTTPHandler(s.confirmPasswordReset))
+	postRouter.HandleFunc("/account/deposit/{id}", s.AuthJWT(s.Authorize(s.Idempotent(s.HTTPHandler(s.depositAccount)), types.PermissionMoveMoney)))
+	postRouter.HandleFunc("/account/refresh", s.HTTPHandler(s.refreshTokens))
+	postRouter.HandleFunc("/account/balance/{id}", s.AuthJWT(s.Authorize(s.HTTPHandler(s.openBalance), types.PermissionWriteAccount)))
+	postRouter.HandleFunc("/account/{id}/keys", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.createAPIKey)), types.PermissionManageAPIKeys)))
+	postRouter.HandleFunc("/account/{id}/2fa", s.AuthJWT(s.Authorize(s.HTTPHandler(s.enrollTOTP), types.PermissionCredentials)))
+	postRouter.HandleFunc("/account/{id}/2fa/confirm", s.AuthJWT(s.Authorize(s.HTTPHandler(s.confirmTOTP), types.PermissionCredentials)))
 	// payment
-	postRouter.HandleFunc("/payment/auth", HTTPHandler(s.createPayment))
-	postRouter.HandleFunc("/payment/capture/{id}", HTTPHandler(s.capturePayment))
-	postRouter.HandleFunc("/payment/refund/{id}", HTTPHandler(s.refundPayment))
-	postRouter.HandleFunc("/payment/cancel/{id}", HTTPHandler(s.cancelPayment))
+	postRouter.HandleFunc("/payment/auth", s.AuthAPIKey(s.VerifySignature(s.Idempotent(s.HTTPHandler(s.createPayment))), types.APIKeySecret, types.APIKeyPublishable))
+	postRouter.HandleFunc("/payment/capture/{id}", s.AuthAPIKey(s.VerifySignature(s.Idempotent(s.HTTPHandler(s.capturePayment))), types.APIKeySecret))
+	postRouter.HandleFunc("/payment/refund/{id}", s.AuthAPIKey(s.VerifySignature(s.Idempotent(s.HTTPHandler(s.refundPayment))), types.APIKeySecret))
+	postRouter.HandleFunc("/payment/cancel/{id}", s.AuthAPIKey(s.VerifySignature(s.Idempotent(s.HTTPHandler(s.cancelPayment))), types.APIKeySecret))
+	postRouter.HandleFunc("/payment/{id}/increment", s.AuthAPIKey(s.VerifySignature(s.Idempotent(s.HTTPHandler(s.incrementPayment))), types.APIKeySecret))
 	// GET
 	getRouter := router.Methods(http.MethodGet).Subrouter()
-	getRouter.HandleFunc("/account", HTTPHandler(s.getAccount))
-	getRouter.HandleFunc("/account/{id}", AuthJWT(HTTPHandler(s.getAccountByID)))
-	getRouter.HandleFunc("/account/statement/{id}", AuthJWT(HTTPHandler(s.getStatement)))
+	getRouter.HandleFunc("/.well-known/jwks.json", s.HTTPHandler(s.getJWKS))
+	getRouter.HandleFunc("/account", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getAccount), types.PermissionListAccounts)))
+	getRouter.HandleFunc("/account/sessions", s.AuthJWT(s.HTTPHandler(s.getSessions)))
+	getRouter.HandleFunc("/account/{id}", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getAccountByID), types.PermissionReadAccount)))
+	getRouter.HandleFunc("/account/statement/{id}", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getStatement), types.PermissionReadAccount)))
+	getRouter.HandleFunc("/account/ledger/{id}", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getLedgerBalance), types.PermissionReadAccount)))
+	getRouter.HandleFunc("/account/balance/{id}", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getBalances), types.PermissionReadAccount)))
+	getRouter.HandleFunc("/account/{id}/keys", s.AuthJWT(s.Authorize(s.HTTPHandler(s.getAPIKeys), types.PermissionManageAPIKeys)))
+	getRouter.HandleFunc("/payment/{id}/refunds", s.AuthAPIKey(s.VerifySignature(s.HTTPHandler(s.getRefunds)), types.APIKeySecret))
 	// UPDATE
 	putRouter := router.Methods(http.MethodPut).Subrouter()
-	putRouter.HandleFunc("/account/{id}", AuthJWT(HTTPHandler(s.updateAccount)))
+	putRouter.HandleFunc("/account/{id}", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.updateAccount)), types.PermissionWriteAccount)))
+	putRouter.HandleFunc("/account/{id}/password", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.setPassword)), types.PermissionCredentials)))
+	putRouter.HandleFunc("/account/{id}/role", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.updateRole)), types.PermissionManageRoles)))
 	// DELETE
 	deleteRouter := router.Methods(http.MethodDelete).Subrouter()
-	deleteRouter.HandleFunc("/account/{id}", AuthJWT(HTTPHandler(s.deleteAccount)))
+	deleteRouter.HandleFunc("/account/sessions", s.AuthJWT(s.HTTPHandler(s.revokeSessions)))
+	deleteRouter.HandleFunc("/account/sessions/{session_id}", s.AuthJWT(s.HTTPHandler(s.revokeSession)))
+	deleteRouter.HandleFunc("/account/{id}", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.deleteAccount)), types.PermissionWriteAccount)))
+	deleteRouter.HandleFunc("/account/{id}/keys/{key_id}", s.AuthJWT(s.Authorize(s.StepUp(s.HTTPHandler(s.revokeAPIKey)), types.PermissionManageAPIKeys)))
 	// SWAGGER
 	router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 	// METRICS
@@ -106,17 +182,46 @@ func (s *JSONApiServer) Run() {
 
 type ApiFunc func(w http.ResponseWriter, r *http.Request) error
 
-// Wrapper for handler func
-func HTTPHandler(f ApiFunc) http.HandlerFunc {
+// Wrapper for handler func. Errors of handlers that have already
+// written the response are only logged
+func (s *JSONApiServer) HTTPHandler(f ApiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+		sw := &statusWriter{ResponseWriter: w}
+		err := f(sw, r)
+		if err == nil {
+			return
+		}
+		if sw.written {
+			s.log().WithError(err).WithField("path", r.URL.Path).Error("write response")
+			return
 		}
+		s.writeError(w, err)
 	}
 }
 
+// tracks whether the response was started
+type statusWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
 type ApiError struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+	// invalid fields of the request
+	Details []utils.FieldError `json:"details,omitempty"`
+	// failed payment of a decline
+	Payment *types.PaymentResponse `json:"payment,omitempty"`
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) error {
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errRefreshToken = types.NewError(types.CodeUnauthorized, "invalid or expired refresh token")
+	errSession      = types.NewError(types.CodeNotFound, "account has no session with this id")
+	errAccessToken  = types.NewError(types.CodeUnauthorized, "invalid access token")
+)
+
+// getSessions godoc
+// @Summary Get sessions
+// @Description get active sessions of the account signed in with the access token, returns sessions
+// @Tags Account
+// @Produce json
+// @Success 200 {array} types.Session
+// @Failure 400  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/sessions [get]
+func (s *JSONApiServer) getSessions(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.getSessions")
+	defer span.Finish()
+
+	id, err := getAccountID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	sessions, err := s.redisStorage.GetSessions(ctx, id)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, sessions)
+}
+
+// revokeSessions godoc
+// @Summary Revoke all sessions
+// @Description sign out of all sessions of the account, returns status
+// @Tags Account
+// @Produce json
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/sessions [delete]
+func (s *JSONApiServer) revokeSessions(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.revokeSessions")
+	defer span.Finish()
+
+	id, err := getAccountID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if err := s.redisStorage.RevokeSessions(ctx, id); err != nil {
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, "sessions were revoked")
+}
+
+// revokeSession godoc
+// @Summary Revoke session
+// @Description sign out of the session, its refresh token can't be used anymore, returns status
+// @Tags Account
+// @Produce json
+// @Param session_id path string true "session id"
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 404  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/sessions/{session_id} [delete]
+func (s *JSONApiServer) revokeSession(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.revokeSession")
+	defer span.Finish()
+
+	id, err := getAccountID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	sessionId, err := uuid.Parse(mux.Vars(r)["session_id"])
+	if err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	if err := s.redisStorage.RevokeSession(ctx, id, sessionId); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return s.writeError(w, errSession)
+		}
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, "session was revoked")
+}
+
+// New session of the account signing in with the request
+func newSession(r *http.Request, id uuid.UUID) *types.Session {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &types.Session{
+		UserID:    id,
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
+
+// Account id of the access token
+func getAccountID(r *http.Request) (uuid.UUID, error) {
+	id, ok := r.Context().Value(accountContextKey).(uuid.UUID)
+	if !ok {
+		return uuid.Nil, errAccessToken
+	}
+	return id, nil
+}
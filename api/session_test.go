@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetSessions(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	tokens := testTokens(t)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, mockRedis, nil, nil, nil, tokens, nil)
+
+	account := &types.Account{ID: uuid.New()}
+	session := &types.Session{
+		ID:           uuid.New(),
+		RefreshToken: "refresh-token",
+		UserID:       account.ID,
+		UserAgent:    "curl/8.0",
+		IP:           "192.0.2.1",
+	}
+	mockRedis.EXPECT().GetSessions(gomock.Any(), account.ID).Return([]*types.Session{session}, nil)
+
+	// the account comes from the access token
+	token, err := tokens.CreateJWT(account)
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodGet, "/account/sessions", nil)
+	request.Header.Set("x-jwt-token", token)
+	recorder := httptest.NewRecorder()
+	server.AuthJWT(server.HTTPHandler(server.getSessions))(recorder, request)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.NotContains(t, recorder.Body.String(), "refresh-token")
+
+	sessions := []*types.Session{}
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&sessions))
+	require.Len(t, sessions, 1)
+	require.Equal(t, session.ID, sessions[0].ID)
+	require.Equal(t, "curl/8.0", sessions[0].UserAgent)
+
+	t.Run("No token", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/account/sessions", nil)
+		recorder := httptest.NewRecorder()
+		server.AuthJWT(server.HTTPHandler(server.getSessions))(recorder, request)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
+
+func Test_RevokeSession(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, mockRedis, nil, nil, nil, nil, nil)
+
+	id := uuid.New()
+	newRequest := func(sessionId string) *http.Request {
+		request := httptest.NewRequest(http.MethodDelete, "/account/sessions/"+sessionId, nil)
+		request = request.WithContext(context.WithValue(request.Context(), accountContextKey, id))
+		return mux.SetURLVars(request, map[string]string{"session_id": sessionId})
+	}
+
+	t.Run("Revoke", func(t *testing.T) {
+		sessionId := uuid.New()
+		mockRedis.EXPECT().RevokeSession(gomock.Any(), id, sessionId).Return(nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.revokeSession(recorder, newRequest(sessionId.String())))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		sessionId := uuid.New()
+		mockRedis.EXPECT().RevokeSession(gomock.Any(), id, sessionId).Return(redis.Nil)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.revokeSession(recorder, newRequest(sessionId.String())))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("Revoke all", func(t *testing.T) {
+		mockRedis.EXPECT().RevokeSessions(gomock.Any(), id).Return(nil)
+
+		request := httptest.NewRequest(http.MethodDelete, "/account/sessions", nil)
+		request = request.WithContext(context.WithValue(request.Context(), accountContextKey, id))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.revokeSessions(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+}
+
+func Test_RefreshTokensReused(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, nil, mockRedis, nil, nil, nil, testTokens(t), nil)
+
+	// the storage revokes the session of a replaced token
+	mockRedis.EXPECT().RotateSession(gomock.Any(), "replaced-token", 86400).Return(nil, redis.Nil)
+
+	request := httptest.NewRequest(http.MethodPost, "/account/refresh", strings.NewReader(`{"refresh_token":"replaced-token"}`))
+	recorder := httptest.NewRecorder()
+	require.NoError(t, server.refreshTokens(recorder, request))
+	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	require.Empty(t, recorder.Header().Get("x-jwt-token"))
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Edbeer/paymentapi/pkg/totp"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	totpIssuer = "PaymentAPI"
+	// sign-in challenge lifetime in seconds
+	signInChallengeExpire = 300
+	recoveryCodes         = 10
+)
+
+var (
+	errTOTPEnabled   = types.NewError(types.CodeConflict, "two-factor authentication is already enabled")
+	errTOTPCode      = types.NewError(types.CodeUnauthorized, "invalid two-factor code")
+	errTOTPRequired  = types.NewError(types.CodeForbidden, "two-factor code is required")
+	errSignInTimeout = types.NewError(types.CodeUnauthorized, "invalid or expired sign-in challenge")
+	// wrong code of an authenticated request
+	errStepUpCode = types.NewError(types.CodeForbidden, "invalid two-factor code")
+	// the password confirms the enrollment isn't made with a stolen token
+	errNoPassword = types.NewError(types.CodeForbidden, "set a password before enabling two-factor authentication")
+)
+
+// enrollTOTP godoc
+// @Summary Enroll two-factor authentication
+// @Description start TOTP enrollment with the current password, returns secret, otpauth uri and recovery codes, which aren't shown again. The first code confirms the enrollment
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "account id"
+// @Param input body types.RequestTOTPEnrollment true "enroll two-factor info"
+// @Success 200 {object} types.TOTPEnrollment
+// @Failure 400  {object}  api.ApiError
+// @Failure 403  {object}  api.ApiError
+// @Failure 409  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/2fa [post]
+func (s *JSONApiServer) enrollTOTP(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.enrollTOTP")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestTOTPEnrollment{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	credential, err := s.storage.GetCredential(ctx, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return s.writeError(w, errNoPassword)
+	case err != nil:
+		return s.writeError(w, err)
+	case !utils.CheckPassword(credential.PasswordHash, req.CurrentPassword):
+		return s.writeError(w, errCurrentPassword)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return s.writeError(w, err)
+		}
+		codes[i] = hex.EncodeToString(b)
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if _, err := s.storage.SaveTOTP(ctx, &types.TOTP{
+		AccountID:     id,
+		Secret:        secret,
+		RecoveryCodes: hashes,
+	}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s.writeError(w, errTOTPEnabled)
+		}
+		return s.writeError(w, err)
+	}
+	return WriteJSON(w, http.StatusOK, &types.TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.URI(totpIssuer, id.String(), secret),
+		RecoveryCodes: codes,
+	})
+}
+
+// confirmTOTP godoc
+// @Summary Confirm two-factor authentication
+// @Description confirm TOTP enrollment with the first code, returns status
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param id path string true "account id"
+// @Param input body types.RequestTOTP true "confirm two-factor info"
+// @Success 200 {integer} 200
+// @Failure 400  {object}  api.ApiError
+// @Failure 401  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/{id}/2fa/confirm [post]
+func (s *JSONApiServer) confirmTOTP(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.confirmTOTP")
+	defer span.Finish()
+
+	id, err := GetUUID(r)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	req := &types.RequestTOTP{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	enrollment, err := s.storage.GetTOTP(ctx, id)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if enrollment.Enabled() {
+		return s.writeError(w, errTOTPEnabled)
+	}
+	ok, err := s.useTOTPCode(ctx, enrollment, req.Code)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if !ok {
+		return s.writeError(w, errTOTPCode)
+	}
+	return WriteJSON(w, http.StatusOK, "two-factor authentication is enabled")
+}
+
+// signInTOTP godoc
+// @Summary Login with two-factor code
+// @Description finish sign-in with the authenticator code or a recovery code, returns account
+// @Tags Account
+// @Accept json
+// @Produce json
+// @Param input body types.RequestSignInTOTP true "two-factor sign-in info"
+// @Success 200 {object} types.Account
+// @Failure 400  {object}  api.ApiError
+// @Failure 401  {object}  api.ApiError
+// @Failure 423  {object}  api.ApiError
+// @Failure 500  {object}  api.ApiError
+// @Router /account/sign-in/2fa [post]
+func (s *JSONApiServer) signInTOTP(w http.ResponseWriter, r *http.Request) error {
+	span, ctx := opentracing.StartSpanFromContext(r.Context(), "Account.signInTOTP")
+	defer span.Finish()
+
+	req := &types.RequestSignInTOTP{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return s.writeError(w, types.InvalidError(err))
+	}
+	defer r.Body.Close()
+	// every attempt needs a new challenge, so the password is checked again
+	id, err := s.redisStorage.ConsumeSignInChallenge(ctx, req.Challenge)
+	if err != nil {
+		return s.writeError(w, errSignInTimeout)
+	}
+	account, err := s.storage.GetAccountByID(ctx, id)
+	if err != nil {
+		return s.writeError(w, errCredentials)
+	}
+	credential, err := s.storage.GetCredential(ctx, id)
+	if err != nil {
+		return s.writeError(w, errCredentials)
+	}
+	now := time.Now()
+	if credential.Locked(now) {
+		return s.writeError(w, errLocked)
+	}
+	enrollment, err := s.storage.GetTOTP(ctx, id)
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	var ok bool
+	if req.RecoveryCode != "" {
+		ok, err = s.storage.UseRecoveryCode(ctx, id, hashRecoveryCode(req.RecoveryCode))
+	} else {
+		ok, err = s.useTOTPCode(ctx, enrollment, req.Code)
+	}
+	if err != nil {
+		return s.writeError(w, err)
+	}
+	if !ok {
+		if _, err := s.storage.SaveFailedLogin(ctx, id, s.maxFailedLogins(), now.Add(s.lockout())); err != nil {
+			return s.writeError(w, err)
+		}
+		return s.writeError(w, errTOTPCode)
+	}
+	return s.startSession(ctx, w, r, account, credential)
+}
+
+// step-up middleware: sensitive account requests need the current
+// authenticator code in X-TOTP-Code if two-factor authentication is enabled
+func (s *JSONApiServer) StepUp(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		// the code of the token account, which may act on another account
+		id, err := getAccountID(r)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		enrollment, err := s.storage.GetTOTP(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !enrollment.Enabled()) {
+			next(w, r)
+			return
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		code := r.Header.Get("X-TOTP-Code")
+		if code == "" {
+			s.writeError(w, errTOTPRequired)
+			return
+		}
+		now := time.Now()
+		if credential, err := s.storage.GetCredential(ctx, id); err == nil && credential.Locked(now) {
+			s.writeError(w, errLocked)
+			return
+		}
+		ok, err := s.useTOTPCode(ctx, enrollment, code)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if !ok {
+			// wrong codes count as failed sign-ins
+			if _, err := s.storage.SaveFailedLogin(ctx, id, s.maxFailedLogins(), now.Add(s.lockout())); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				s.writeError(w, err)
+				return
+			}
+			s.writeError(w, errStepUpCode)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Check the authenticator code, every code is accepted once
+func (s *JSONApiServer) useTOTPCode(ctx context.Context, enrollment *types.TOTP, code string) (bool, error) {
+	counter, ok := totp.Validate(enrollment.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.storage.UseTOTPCode(ctx, enrollment.AccountID, counter)
+}
+
+// Recovery codes are random, so a plain hash is enough
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mockstore "github.com/Edbeer/paymentapi/api/mock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/totp"
+	"github.com/Edbeer/paymentapi/pkg/utils"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EnrollTOTP(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	id := uuid.New()
+	newRequest := func(password string) *http.Request {
+		request := httptest.NewRequest(http.MethodPost, "/account/"+id.String()+"/2fa", strings.NewReader(`{"current_password":"`+password+`"}`))
+		return mux.SetURLVars(request, map[string]string{"id": id.String()})
+	}
+	passwordHash, err := utils.HashPassword("correct horse")
+	require.NoError(t, err)
+	credential := func() {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{AccountID: id, PasswordHash: passwordHash}, nil)
+	}
+
+	t.Run("Enroll", func(t *testing.T) {
+		credential()
+		var saved *types.TOTP
+		mockStorage.EXPECT().SaveTOTP(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(_ any, enrollment *types.TOTP) (*types.TOTP, error) {
+				saved = enrollment
+				return enrollment, nil
+			})
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.enrollTOTP(recorder, newRequest("correct horse")))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		enrollment := &types.TOTPEnrollment{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(enrollment))
+		require.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"+totpIssuer+":"+id.String()+"?"))
+		require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		require.Equal(t, saved.Secret, enrollment.Secret)
+		require.Len(t, enrollment.RecoveryCodes, recoveryCodes)
+		// only hashes are stored
+		require.Equal(t, hashRecoveryCode(enrollment.RecoveryCodes[0]), saved.RecoveryCodes[0])
+		require.NotContains(t, saved.RecoveryCodes, enrollment.RecoveryCodes[0])
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		credential()
+		mockStorage.EXPECT().SaveTOTP(gomock.Any(), gomock.Any()).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.enrollTOTP(recorder, newRequest("correct horse")))
+		require.Equal(t, http.StatusConflict, recorder.Code)
+	})
+
+	// an access token alone can't enroll, nothing is saved
+	t.Run("Wrong password", func(t *testing.T) {
+		credential()
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.enrollTOTP(recorder, newRequest("wrong horse")))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errCurrentPassword.Error())
+	})
+
+	t.Run("No password", func(t *testing.T) {
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.enrollTOTP(recorder, newRequest("")))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errNoPassword.Error())
+	})
+}
+
+func Test_SignInTOTP(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	mockRedis := mockstore.NewMockRedisStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, mockRedis, nil, nil, nil, testTokens(t), nil)
+
+	id := uuid.New()
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	confirmedAt := time.Now()
+	enrollment := &types.TOTP{AccountID: id, Secret: secret, ConfirmedAt: &confirmedAt}
+	passwordHash, err := utils.HashPassword("correct horse")
+	require.NoError(t, err)
+	credential := &types.Credential{AccountID: id, PasswordHash: passwordHash, FailedLogins: 2}
+
+	mockStorage.EXPECT().GetAccountByID(gomock.Any(), id).Return(&types.Account{ID: id}, nil).AnyTimes()
+	mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(credential, nil).AnyTimes()
+	mockStorage.EXPECT().GetTOTP(gomock.Any(), id).Return(enrollment, nil).AnyTimes()
+
+	t.Run("Challenge", func(t *testing.T) {
+		mockRedis.EXPECT().CreateSignInChallenge(gomock.Any(), id, signInChallengeExpire).Return("challenge", nil)
+
+		buffer, err := utils.AnyToBytesBuffer(&types.LoginRequest{ID: id, Password: "correct horse"})
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signIn(recorder, httptest.NewRequest(http.MethodPost, "/account/sign-in", buffer)))
+		require.Equal(t, http.StatusAccepted, recorder.Code)
+		require.Empty(t, recorder.Header().Get("x-jwt-token"))
+		require.Contains(t, recorder.Body.String(), "challenge")
+	})
+
+	t.Run("Code", func(t *testing.T) {
+		code, err := totp.Code(secret, totp.Counter(time.Now()))
+		require.NoError(t, err)
+		mockRedis.EXPECT().ConsumeSignInChallenge(gomock.Any(), "challenge").Return(id, nil)
+		mockStorage.EXPECT().UseTOTPCode(gomock.Any(), id, gomock.Any()).Return(true, nil)
+		mockStorage.EXPECT().ResetFailedLogins(gomock.Any(), id).Return(nil)
+		mockRedis.EXPECT().CreateSession(gomock.Any(), gomock.Any(), 86400).Return("refresh-token", nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/sign-in/2fa", strings.NewReader(`{"challenge":"challenge","code":"`+code+`"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signInTOTP(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NotEmpty(t, recorder.Header().Get("x-jwt-token"))
+	})
+
+	t.Run("Used code", func(t *testing.T) {
+		code, err := totp.Code(secret, totp.Counter(time.Now()))
+		require.NoError(t, err)
+		mockRedis.EXPECT().ConsumeSignInChallenge(gomock.Any(), "challenge").Return(id, nil)
+		mockStorage.EXPECT().UseTOTPCode(gomock.Any(), id, gomock.Any()).Return(false, nil)
+		mockStorage.EXPECT().SaveFailedLogin(gomock.Any(), id, defaultMaxFailedLogins, gomock.Any()).Return(credential, nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/sign-in/2fa", strings.NewReader(`{"challenge":"challenge","code":"`+code+`"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signInTOTP(recorder, request))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		mockRedis.EXPECT().ConsumeSignInChallenge(gomock.Any(), "challenge").Return(id, nil)
+		mockStorage.EXPECT().UseRecoveryCode(gomock.Any(), id, hashRecoveryCode("0123456789")).Return(true, nil)
+		mockStorage.EXPECT().ResetFailedLogins(gomock.Any(), id).Return(nil)
+		mockRedis.EXPECT().CreateSession(gomock.Any(), gomock.Any(), 86400).Return("refresh-token", nil)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/sign-in/2fa", strings.NewReader(`{"challenge":"challenge","recovery_code":"0123456789"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signInTOTP(recorder, request))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("Expired challenge", func(t *testing.T) {
+		mockRedis.EXPECT().ConsumeSignInChallenge(gomock.Any(), "challenge").Return(uuid.Nil, sql.ErrNoRows)
+
+		request := httptest.NewRequest(http.MethodPost, "/account/sign-in/2fa", strings.NewReader(`{"challenge":"challenge","code":"000000"}`))
+		recorder := httptest.NewRecorder()
+		require.NoError(t, server.signInTOTP(recorder, request))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
+
+func Test_StepUp(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mockstore.NewMockStorage(ctrl)
+	server := NewJSONApiServer(&config.Config{}, nil, nil, mockStorage, nil, nil, nil, nil, nil, nil)
+
+	calls := 0
+	handler := server.StepUp(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSON(w, http.StatusOK, nil)
+	})
+	id := uuid.New()
+	newRequest := func(code string) *http.Request {
+		request := httptest.NewRequest(http.MethodDelete, "/account/"+id.String(), nil)
+		if code != "" {
+			request.Header.Set("X-TOTP-Code", code)
+		}
+		request = request.WithContext(context.WithValue(request.Context(), accountContextKey, id))
+		return mux.SetURLVars(request, map[string]string{"id": id.String()})
+	}
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	confirmedAt := time.Now()
+	enrollment := &types.TOTP{AccountID: id, Secret: secret, ConfirmedAt: &confirmedAt}
+
+	t.Run("Without two-factor", func(t *testing.T) {
+		mockStorage.EXPECT().GetTOTP(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(""))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Code required", func(t *testing.T) {
+		mockStorage.EXPECT().GetTOTP(gomock.Any(), id).Return(enrollment, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(""))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Contains(t, recorder.Body.String(), errTOTPRequired.Error())
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Code", func(t *testing.T) {
+		code, err := totp.Code(secret, totp.Counter(time.Now()))
+		require.NoError(t, err)
+		mockStorage.EXPECT().GetTOTP(gomock.Any(), id).Return(enrollment, nil)
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(nil, sql.ErrNoRows)
+		mockStorage.EXPECT().UseTOTPCode(gomock.Any(), id, gomock.Any()).Return(true, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(code))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("Wrong code", func(t *testing.T) {
+		code, err := totp.Code(secret, totp.Counter(time.Now())+10)
+		require.NoError(t, err)
+		mockStorage.EXPECT().GetTOTP(gomock.Any(), id).Return(enrollment, nil)
+		mockStorage.EXPECT().GetCredential(gomock.Any(), id).Return(&types.Credential{}, nil)
+		mockStorage.EXPECT().SaveFailedLogin(gomock.Any(), id, defaultMaxFailedLogins, gomock.Any()).Return(&types.Credential{}, nil)
+
+		recorder := httptest.NewRecorder()
+		handler(recorder, newRequest(code))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Equal(t, 2, calls)
+	})
+}
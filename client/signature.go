@@ -0,0 +1,68 @@
+// Package client signs merchant requests to the payment API
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Signature headers
+const (
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// HMAC-SHA256 of the method, path, unix timestamp, nonce and body
+// separated by new lines, hex encoded
+func Signature(secret, method, path string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signs requests with the merchant secret API key and its signing secret
+type Signer struct {
+	APIKey        string
+	SigningSecret string
+}
+
+func NewSigner(apiKey, signingSecret string) *Signer {
+	return &Signer{
+		APIKey:        apiKey,
+		SigningSecret: signingSecret,
+	}
+}
+
+// Set the authorization and signature headers of the request,
+// the body is read and replaced by a copy
+func (s *Signer) Sign(r *http.Request) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	r.Header.Set("Authorization", "Bearer "+s.APIKey)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderNonce, hex.EncodeToString(nonce))
+	r.Header.Set(HeaderSignature, Signature(s.SigningSecret, r.Method, r.URL.Path, timestamp, r.Header.Get(HeaderNonce), body))
+	return nil
+}
@@ -0,0 +1,34 @@
+// Encrypts account and payment data with the current master key,
+// run it after adding a new master key or to encrypt data stored before encryption:
+//
+//	go run ./cmd/reencrypt
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/db/psql"
+	"github.com/Edbeer/paymentapi/pkg/envelope"
+	postgres "github.com/Edbeer/paymentapi/storage/psql"
+)
+
+func main() {
+	config := config.GetConfig()
+	db, err := psql.NewPostgresDB(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	keys, err := envelope.LoadLocalKeyProvider(config.Encryption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reencrypted, err := postgres.NewPostgresStorage(db, keys).Reencrypt(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("re-encrypted %d records with master key %d", reencrypted, keys.CurrentVersion())
+}
@@ -0,0 +1,36 @@
+// Prints the payment state machine in graphviz DOT format:
+//
+//	go run ./cmd/statediagram | dot -Tjpg -o img/schema.jpg
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Edbeer/paymentapi/types"
+)
+
+func main() {
+	if err := writeDOT(os.Stdout, types.Transitions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func writeDOT(w io.Writer, transitions []types.Transition) error {
+	if _, err := fmt.Fprintln(w, "digraph payment {\n\trankdir=LR;\n\tnode [shape=box, style=rounded];\n\tstart [shape=point];"); err != nil {
+		return err
+	}
+	for _, transition := range transitions {
+		from := "start"
+		if transition.From != "" {
+			from = fmt.Sprintf("%q", transition.From)
+		}
+		if _, err := fmt.Fprintf(w, "\t%s -> %q [label=%q];\n", from, transition.To, transition.Operation); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
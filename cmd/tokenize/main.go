@@ -0,0 +1,128 @@
+// Moves card numbers stored before the card vault into the vault,
+// accounts and payments get the tokens and the masked numbers of their cards:
+//
+//	go run ./cmd/tokenize
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/db/psql"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/Edbeer/paymentapi/vault"
+)
+
+func main() {
+	config := config.GetConfig()
+	db, err := psql.NewPostgresDB(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	cards, err := vault.NewVault(db, config.Vault)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenized, err := tokenize(context.Background(), db, cards)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("tokenized %d cards", tokenized)
+}
+
+// Replaces card numbers of accounts and payments with tokens,
+// can be run again after a failure
+func tokenize(ctx context.Context, db *sql.DB, cards *vault.Vault) (int, error) {
+	query := `SELECT card_token FROM account
+				WHERE card_token NOT LIKE 'tok\_%' AND card_token <> ''
+			UNION
+			SELECT card_token FROM payment
+				WHERE card_token NOT LIKE 'tok\_%' AND card_token <> ''`
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	pans := []string{}
+	for rows.Next() {
+		var pan string
+		if err := rows.Scan(&pan); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pans = append(pans, pan)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, pan := range pans {
+		card, err := cards.Tokenize(ctx, pan)
+		if err != nil {
+			return 0, err
+		}
+		if err := psql.WithTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, `UPDATE account SET card_token = $1, card_number = $2 WHERE card_token = $3`,
+				card.Token, card.CardNumber, pan); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `UPDATE payment SET card_token = $1, card_number = $2 WHERE card_token = $3`,
+				card.Token, card.CardNumber, pan)
+			return err
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if err := mask(ctx, db, cards); err != nil {
+		return 0, err
+	}
+	return len(pans), nil
+}
+
+// Masked numbers of cards tokenized before the vault kept them,
+// copied to the accounts and payments of the cards
+func mask(ctx context.Context, db *sql.DB, cards *vault.Vault) error {
+	rows, err := db.QueryContext(ctx, `SELECT token FROM card_vault WHERE card_number = ''`)
+	if err != nil {
+		return err
+	}
+	tokens := []string{}
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		pan, err := cards.Detokenize(ctx, token)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE card_vault SET card_number = $1 WHERE token = $2`,
+			types.MaskPAN(pan), token); err != nil {
+			return err
+		}
+	}
+	return psql.WithTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `UPDATE account SET card_number = card_vault.card_number
+			FROM card_vault
+			WHERE account.card_token = card_vault.token AND account.card_number = ''`); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE payment SET card_number = card_vault.card_number
+			FROM card_vault
+			WHERE payment.card_token = card_vault.token AND payment.card_number = ''`)
+		return err
+	})
+}
@@ -9,17 +9,32 @@ import (
 
 // Config
 type Config struct {
-	Server   Server
-	Postgres Postgres
+	Server     Server
+	Postgres   Postgres
+	FX         FX
+	Expiry     Expiry
+	Signature  Signature
+	Auth       Auth
+	Vault      Vault
+	Encryption Encryption
+	Notify     Notify
 }
 
-// Server config
+// Server config. Access tokens are signed with the PEM keys of JwtKeysDir
+// (RS256 or EdDSA, the file name is the key id) or with JwtSecretKey (HS256)
+// if it's not set. Token lifetimes are in seconds
 type Server struct {
-	Port         string `env:"PORT"`
-	JwtSecretKey string `env:"JWT_SECRET_KEY"`
-	ReadTimeout  int    `env:"READ_TIMEOUT"`
-	WriteTimeout int    `env:"WRITE_TIMEOUT"`
-	IdleTimeout  int    `env:"IDLE_TIMEOUT"`
+	Port            string `env:"PORT"`
+	JwtSecretKey    string `env:"JWT_SECRET_KEY"`
+	JwtKeysDir      string `env:"JWT_KEYS_DIR"`
+	JwtSigningKeyID string `env:"JWT_SIGNING_KEY_ID"`
+	JwtIssuer       string `env:"JWT_ISSUER" env-default:"paymentapi"`
+	JwtAudience     string `env:"JWT_AUDIENCE" env-default:"paymentapi"`
+	AccessTokenTTL  int    `env:"ACCESS_TOKEN_TTL" env-default:"900"`
+	RefreshTokenTTL int    `env:"REFRESH_TOKEN_TTL" env-default:"86400"`
+	ReadTimeout     int    `env:"READ_TIMEOUT"`
+	WriteTimeout    int    `env:"WRITE_TIMEOUT"`
+	IdleTimeout     int    `env:"IDLE_TIMEOUT"`
 }
 
 // Postgresql config
@@ -30,6 +45,52 @@ type Postgres struct {
 	PostgresqlDbname   string `env:"POSTGRES_DB"`
 }
 
+// Foreign exchange config
+type FX struct {
+	RatesFile string `env:"FX_RATES_FILE"`
+	MarkupBps int64  `env:"FX_MARKUP_BPS"`
+}
+
+// Authorization expiry config: default lifetime of authorizations in hours,
+// interval of the expiry worker in seconds
+type Expiry struct {
+	AuthorizationTTL int `env:"AUTHORIZATION_TTL"`
+	Interval         int `env:"EXPIRY_INTERVAL" env-default:"60"`
+}
+
+// Request signing config: accepted clock skew of signed requests in seconds
+type Signature struct {
+	Window int `env:"SIGNATURE_WINDOW" env-default:"300"`
+}
+
+// Sign-in config: failed sign-ins before lockout, lockout duration
+// and lifetime of password reset tokens in seconds
+type Auth struct {
+	MaxFailedLogins int `env:"MAX_FAILED_LOGINS" env-default:"5"`
+	Lockout         int `env:"LOGIN_LOCKOUT" env-default:"900"`
+	ResetTokenTTL   int `env:"PASSWORD_RESET_TTL" env-default:"3600"`
+}
+
+// Card vault config: hex encoded 256-bit key, card numbers
+// are encrypted and fingerprinted with keys derived from it
+type Vault struct {
+	Key string `env:"VAULT_KEY"`
+}
+
+// Envelope encryption config: master keys file with "version:hex key" lines,
+// data keys are wrapped with KeyVersion, the latest version by default
+type Encryption struct {
+	KeysFile   string `env:"ENCRYPTION_KEYS_FILE"`
+	KeyVersion int    `env:"ENCRYPTION_KEY_VERSION"`
+}
+
+// Notification config: webhook of the delivery service, messages are signed
+// with the secret. Password reset is disabled without the webhook
+type Notify struct {
+	WebhookURL    string `env:"NOTIFY_WEBHOOK_URL"`
+	WebhookSecret string `env:"NOTIFY_WEBHOOK_SECRET"`
+}
+
 var (
 	config *Config
 	once   sync.Once
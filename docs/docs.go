@@ -16,6 +16,26 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "public keys to verify access tokens, returns JWK set",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Access token keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.JWKS"
+                        }
+                    }
+                }
+            }
+        },
         "/account": {
             "get": {
                 "description": "get all accounts, returns accounts",
@@ -107,35 +127,33 @@ const docTemplate = `{
                 }
             }
         },
-        "/account/deposit": {
-            "post": {
-                "description": "deposit money to account, returns account",
-                "consumes": [
-                    "application/json"
-                ],
+        "/account/balance/{id}": {
+            "get": {
+                "description": "get account balances in all currencies, returns balances",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Account"
                 ],
-                "summary": "Deposit money",
+                "summary": "Get account balances",
                 "parameters": [
                     {
-                        "description": "deposit account info",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/types.RequestDeposit"
-                        }
+                        "type": "string",
+                        "description": "get balances info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.Account"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.Balance"
+                            }
                         }
                     },
                     "400": {
@@ -157,11 +175,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/account/refresh": {
+            },
             "post": {
-                "description": "refresh access and refresh tokens, returns tokens",
+                "description": "open account balance in a new currency, returns balance",
                 "consumes": [
                     "application/json"
                 ],
@@ -171,15 +187,22 @@ const docTemplate = `{
                 "tags": [
                     "Account"
                 ],
-                "summary": "Refresh tokens",
+                "summary": "Open account balance",
                 "parameters": [
                     {
-                        "description": "refresh tokens account info",
+                        "type": "string",
+                        "description": "open balance info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "open balance info",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/types.RefreshRequest"
+                            "$ref": "#/definitions/types.RequestBalance"
                         }
                     }
                 ],
@@ -187,7 +210,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.RefreshResponse"
+                            "$ref": "#/definitions/types.Balance"
                         }
                     },
                     "400": {
@@ -211,9 +234,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/account/sign-in": {
+        "/account/deposit/{id}": {
             "post": {
-                "description": "log in to your account, returns account",
+                "description": "deposit money with the card of the account to its balance in the currency, account currency by default, returns balance",
                 "consumes": [
                     "application/json"
                 ],
@@ -223,23 +246,36 @@ const docTemplate = `{
                 "tags": [
                     "Account"
                 ],
-                "summary": "Login",
+                "summary": "Deposit money",
                 "parameters": [
                     {
-                        "description": "login account info",
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "deposit account info",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/types.LoginRequest"
+                            "$ref": "#/definitions/types.RequestDeposit"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.Account"
+                            "$ref": "#/definitions/types.Balance"
                         }
                     },
                     "400": {
@@ -248,6 +284,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -263,21 +305,36 @@ const docTemplate = `{
                 }
             }
         },
-        "/account/sign-out": {
-            "post": {
-                "description": "log out of your account, returns status",
+        "/account/ledger/{id}": {
+            "get": {
+                "description": "get account balance derived from the ledger and reconcile it with the account balance",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Account"
                 ],
-                "summary": "Logout",
+                "summary": "Get account ledger balance",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "get ledger balance info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "currency, account currency by default",
+                        "name": "currency",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "integer"
+                            "$ref": "#/definitions/types.LedgerBalance"
                         }
                     },
                     "400": {
@@ -301,30 +358,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/account/statement/{id}": {
-            "get": {
-                "description": "get account statement, returns statement",
+        "/account/password/reset": {
+            "post": {
+                "description": "send password reset token to the account owner, returns status whether the account exists or not",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Account"
                 ],
-                "summary": "Get account statement",
+                "summary": "Reset password",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "get statement info",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "reset password info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestPasswordReset"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.Account"
+                            "type": "integer"
                         }
                     },
                     "400": {
@@ -333,8 +395,8 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/api.ApiError"
                         }
@@ -348,30 +410,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/account/{id}": {
-            "get": {
-                "description": "get account by id, returns account",
+        "/account/password/reset/confirm": {
+            "post": {
+                "description": "set account password with the reset token, unlocks sign-in and signs out all sessions, returns status",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Account"
                 ],
-                "summary": "Get account by id",
+                "summary": "Confirm password reset",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "get account by id info",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "confirm password reset info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestPasswordResetConfirm"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.Account"
+                            "type": "integer"
                         }
                     },
                     "400": {
@@ -380,12 +447,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/api.ApiError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -393,9 +454,11 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "update account, returns updated account",
+            }
+        },
+        "/account/refresh": {
+            "post": {
+                "description": "refresh access and refresh tokens, returns tokens",
                 "consumes": [
                     "application/json"
                 ],
@@ -405,22 +468,15 @@ const docTemplate = `{
                 "tags": [
                     "Account"
                 ],
-                "summary": "Update account",
+                "summary": "Refresh tokens",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "update account info",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "update account info",
+                        "description": "refresh tokens account info",
                         "name": "input",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/types.RequestUpdate"
+                            "$ref": "#/definitions/types.RefreshRequest"
                         }
                     }
                 ],
@@ -428,7 +484,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.Account"
+                            "$ref": "#/definitions/types.RefreshResponse"
                         }
                     },
                     "400": {
@@ -437,6 +493,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -450,30 +512,26 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "delete account, returns status",
+            }
+        },
+        "/account/sessions": {
+            "get": {
+                "description": "get active sessions of the account signed in with the access token, returns sessions",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "Account"
                 ],
-                "summary": "Delete account",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "delete account info",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
+                "summary": "Get sessions",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "integer"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.Session"
+                            }
                         }
                     },
                     "400": {
@@ -482,12 +540,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/api.ApiError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -495,44 +547,21 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/payment/auth": {
-            "post": {
-                "description": "Create payment: Acceptance of payment",
-                "consumes": [
-                    "application/json"
-                ],
+            },
+            "delete": {
+                "description": "sign out of all sessions of the account, returns status",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Payment"
-                ],
-                "summary": "Create payment",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "create payment info",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "create payment info",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/types.PaymentRequest"
-                        }
-                    }
+                    "Account"
                 ],
+                "summary": "Revoke all sessions",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.PaymentResponse"
+                            "type": "integer"
                         }
                     },
                     "400": {
@@ -541,12 +570,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/api.ApiError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -556,42 +579,30 @@ const docTemplate = `{
                 }
             }
         },
-        "/payment/cancel/{id}": {
-            "post": {
-                "description": "Cancel payment: cancel authorization payment",
-                "consumes": [
-                    "application/json"
-                ],
+        "/account/sessions/{session_id}": {
+            "delete": {
+                "description": "sign out of the session, its refresh token can't be used anymore, returns status",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Payment"
+                    "Account"
                 ],
-                "summary": "Cancel payment",
+                "summary": "Revoke session",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "cancel payment info",
-                        "name": "id",
+                        "description": "session id",
+                        "name": "session_id",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "description": "cancel payment info",
-                        "name": "input",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/types.PaidRequest"
-                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/types.PaymentResponse"
+                            "type": "integer"
                         }
                     },
                     "400": {
@@ -615,9 +626,961 @@ const docTemplate = `{
                 }
             }
         },
-        "/payment/capture/{id}": {
+        "/account/sign-in": {
             "post": {
-                "description": "Capture payment: Successful payment",
+                "description": "log in to your account with its password, returns account or the challenge of two-factor authentication",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Login",
+                "parameters": [
+                    {
+                        "description": "login account info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/types.SignInChallenge"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/sign-in/2fa": {
+            "post": {
+                "description": "finish sign-in with the authenticator code or a recovery code, returns account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Login with two-factor code",
+                "parameters": [
+                    {
+                        "description": "two-factor sign-in info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestSignInTOTP"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/sign-out": {
+            "post": {
+                "description": "log out of your account, returns status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Logout",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "integer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/statement/{id}": {
+            "get": {
+                "description": "get account statement, returns statement",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Get account statement",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "get statement info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}": {
+            "get": {
+                "description": "get account by id, returns account",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Get account by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "get account by id info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "update account, returns updated account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Update account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "update account info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "update account info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestUpdate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "delete account, returns status",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Delete account",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "delete account info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "integer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/2fa": {
+            "post": {
+                "description": "start TOTP enrollment with the current password, returns secret, otpauth uri and recovery codes, which aren't shown again. The first code confirms the enrollment",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Enroll two-factor authentication",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "enroll two-factor info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestTOTPEnrollment"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.TOTPEnrollment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/2fa/confirm": {
+            "post": {
+                "description": "confirm TOTP enrollment with the first code, returns status",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Confirm two-factor authentication",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "confirm two-factor info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestTOTP"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "integer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/keys": {
+            "get": {
+                "description": "get merchant API keys including revoked ones, returns keys without secrets",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Get API keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.APIKey"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "create merchant API key, returns the key and the signing secret of secret keys, which aren't shown again",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Create API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "create api key info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestAPIKey"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.APIKeyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/keys/{key_id}": {
+            "delete": {
+                "description": "revoke merchant API key, returns revoked key",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Revoke API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "api key id",
+                        "name": "key_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.APIKey"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/password": {
+            "put": {
+                "description": "set account password, the current password is required to change it, other sessions are signed out, returns status",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Set password",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "set password info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestPassword"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "integer"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/account/{id}/role": {
+            "put": {
+                "description": "change role of the account, admins only, returns updated account",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Account"
+                ],
+                "summary": "Change account role",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "account id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "account role info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.RequestRole"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Account"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/payment/auth": {
+            "post": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
+                "description": "Create payment: Acceptance of payment",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payment"
+                ],
+                "summary": "Create payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "create payment info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "create payment info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.PaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/payment/cancel/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
+                "description": "Cancel payment: cancel authorization payment",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payment"
+                ],
+                "summary": "Cancel payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "cancel payment info",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "cancel payment info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PaidRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.PaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/payment/capture/{id}": {
+            "post": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
+                "description": "Capture payment: Successful payment",
                 "consumes": [
                     "application/json"
                 ],
@@ -644,6 +1607,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/types.PaidRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -659,12 +1628,30 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -676,6 +1663,11 @@ const docTemplate = `{
         },
         "/payment/refund/{id}": {
             "post": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
                 "description": "Refund: Refunded payment, if there is a refund",
                 "consumes": [
                     "application/json"
@@ -703,6 +1695,100 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/types.PaidRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.PaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/payment/{id}/increment": {
+            "post": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
+                "description": "Increment authorization: raise the hold of the authorization",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payment"
+                ],
+                "summary": "Increment authorization",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorization payment id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "increment payment info",
+                        "name": "input",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PaidRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "idempotency key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -718,6 +1804,82 @@ const docTemplate = `{
                             "$ref": "#/definitions/api.ApiError"
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    }
+                }
+            }
+        },
+        "/payment/{id}/refunds": {
+            "get": {
+                "security": [
+                    {
+                        "MerchantKey": []
+                    }
+                ],
+                "description": "Refunds of the capture",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Payment"
+                ],
+                "summary": "Refund history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "capture payment id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.RefundHistory"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/api.ApiError"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -738,57 +1900,224 @@ const docTemplate = `{
         "api.ApiError": {
             "type": "object",
             "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "details": {
+                    "description": "invalid fields of the request",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/utils.FieldError"
+                    }
+                },
                 "error": {
                     "type": "string"
+                },
+                "payment": {
+                    "description": "failed payment of a decline",
+                    "$ref": "#/definitions/types.PaymentResponse"
+                }
+            }
+        },
+        "types.APIKey": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last4": {
+                    "type": "string"
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.APIKeyResponse": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "last4": {
+                    "type": "string"
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "signing_secret": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
                 }
             }
         },
         "types.Account": {
             "type": "object",
             "properties": {
+                "authorization_ttl": {
+                    "type": "integer"
+                },
+                "balances": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Balance"
+                    }
+                },
+                "card_expiry_month": {
+                    "type": "string"
+                },
+                "card_expiry_year": {
+                    "type": "string"
+                },
+                "card_number": {
+                    "type": "string"
+                },
+                "card_token": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "statement": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "types.Balance": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
                 "balance": {
                     "type": "integer"
                 },
                 "blocked_money": {
                     "type": "integer"
                 },
-                "card_expiry_month": {
+                "currency": {
                     "type": "string"
-                },
-                "card_expiry_year": {
+                }
+            }
+        },
+        "types.JWK": {
+            "type": "object",
+            "properties": {
+                "alg": {
                     "type": "string"
                 },
-                "card_number": {
+                "crv": {
                     "type": "string"
                 },
-                "card_security_code": {
+                "e": {
                     "type": "string"
                 },
-                "created_at": {
+                "kid": {
                     "type": "string"
                 },
-                "first_name": {
+                "kty": {
                     "type": "string"
                 },
-                "id": {
+                "n": {
                     "type": "string"
                 },
-                "last_name": {
+                "use": {
                     "type": "string"
                 },
-                "statement": {
+                "x": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.JWKS": {
+            "type": "object",
+            "properties": {
+                "keys": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/types.JWK"
                     }
                 }
             }
         },
+        "types.LedgerBalance": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "balance": {
+                    "type": "integer"
+                },
+                "blocked_money": {
+                    "type": "integer"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "reconciled": {
+                    "type": "boolean"
+                }
+            }
+        },
         "types.LoginRequest": {
             "type": "object",
             "properties": {
                 "id": {
                     "type": "string"
+                },
+                "password": {
+                    "type": "string"
                 }
             }
         },
@@ -798,6 +2127,10 @@ const docTemplate = `{
                 "amount": {
                     "type": "integer"
                 },
+                "final_capture": {
+                    "description": "capture only: release the rest of the hold to the buyer",
+                    "type": "boolean"
+                },
                 "operation": {
                     "type": "string"
                 },
@@ -809,6 +2142,86 @@ const docTemplate = `{
                 }
             }
         },
+        "types.Payment": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "amount": {
+                    "type": "integer"
+                },
+                "business_id": {
+                    "type": "string"
+                },
+                "captured_amount": {
+                    "type": "integer"
+                },
+                "card_brand": {
+                    "type": "string"
+                },
+                "card_expiry_month": {
+                    "type": "string"
+                },
+                "card_expiry_year": {
+                    "type": "string"
+                },
+                "card_number": {
+                    "type": "string"
+                },
+                "card_token": {
+                    "type": "string"
+                },
+                "creation_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "fx_rate": {
+                    "type": "string"
+                },
+                "fx_rate_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "order_id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reference_id": {
+                    "type": "string"
+                },
+                "refunded_amount": {
+                    "type": "integer"
+                },
+                "released_amount": {
+                    "type": "integer"
+                },
+                "settled_amount": {
+                    "type": "integer"
+                },
+                "settlement_amount": {
+                    "type": "integer"
+                },
+                "settlement_currency": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "types.PaymentRequest": {
             "type": "object",
             "properties": {
@@ -844,6 +2257,9 @@ const docTemplate = `{
                 "id": {
                     "type": "string"
                 },
+                "reason": {
+                    "type": "string"
+                },
                 "status": {
                     "type": "string"
                 }
@@ -868,6 +2284,48 @@ const docTemplate = `{
                 }
             }
         },
+        "types.RefundHistory": {
+            "type": "object",
+            "properties": {
+                "capture_id": {
+                    "type": "string"
+                },
+                "captured_amount": {
+                    "type": "integer"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "refunded_amount": {
+                    "type": "integer"
+                },
+                "refunds": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Payment"
+                    }
+                }
+            }
+        },
+        "types.RequestAPIKey": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestBalance": {
+            "type": "object",
+            "properties": {
+                "currency": {
+                    "type": "string"
+                }
+            }
+        },
         "types.RequestCreate": {
             "type": "object",
             "properties": {
@@ -881,6 +2339,10 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "card_security_code": {
+                    "description": "verified, never stored",
+                    "type": "string"
+                },
+                "currency": {
                     "type": "string"
                 },
                 "first_name": {
@@ -888,6 +2350,14 @@ const docTemplate = `{
                 },
                 "last_name": {
                     "type": "string"
+                },
+                "password": {
+                    "description": "optional, can be set later",
+                    "type": "string"
+                },
+                "role": {
+                    "description": "customer by default or merchant",
+                    "type": "string"
                 }
             }
         },
@@ -899,12 +2369,87 @@ const docTemplate = `{
                 },
                 "card_number": {
                     "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestPassword": {
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestPasswordReset": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestPasswordResetConfirm": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestRole": {
+            "type": "object",
+            "properties": {
+                "role": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestSignInTOTP": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestTOTP": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.RequestTOTPEnrollment": {
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "type": "string"
                 }
             }
         },
         "types.RequestUpdate": {
             "type": "object",
             "properties": {
+                "authorization_ttl": {
+                    "description": "lifetime of merchant authorizations in hours, unchanged if missing,\n0 goes back to the config or default lifetime",
+                    "type": "integer"
+                },
                 "card_expiry_month": {
                     "type": "string"
                 },
@@ -914,9 +2459,6 @@ const docTemplate = `{
                 "card_number": {
                     "type": "string"
                 },
-                "card_security_code": {
-                    "type": "string"
-                },
                 "first_name": {
                     "type": "string"
                 },
@@ -924,6 +2466,68 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "types.Session": {
+            "type": "object",
+            "properties": {
+                "account_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "last_used_at": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.SignInChallenge": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.TOTPEnrollment": {
+            "type": "object",
+            "properties": {
+                "otpauth_uri": {
+                    "type": "string"
+                },
+                "recovery_codes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                }
+            }
+        },
+        "utils.FieldError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
@@ -931,6 +2535,11 @@ const docTemplate = `{
             "type": "apiKey",
             "name": "Authorization",
             "in": "header"
+        },
+        "MerchantKey": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -942,7 +2551,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "",
 	Schemes:          []string{},
 	Title:            "Payment Application",
-	Description:      "Simple payment system",
+	Description:      "Merchant API key, \"Bearer sk_live_...\"",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 }
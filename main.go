@@ -9,8 +9,13 @@ import (
 
 	"github.com/Edbeer/paymentapi/api"
 	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/pkg/envelope"
+	"github.com/Edbeer/paymentapi/pkg/fx"
+	"github.com/Edbeer/paymentapi/pkg/notify"
+	"github.com/Edbeer/paymentapi/pkg/utils"
 	"github.com/Edbeer/paymentapi/storage/psql"
 	"github.com/Edbeer/paymentapi/storage/redis"
+	"github.com/Edbeer/paymentapi/vault"
 	"github.com/sirupsen/logrus"
 
 	"github.com/Edbeer/paymentapi/pkg/db/psql"
@@ -30,6 +35,11 @@ import (
 // @securitydefinitions.apikey
 // @in header
 // @name Authorization
+
+// @securityDefinitions.apikey MerchantKey
+// @in header
+// @name Authorization
+// @description Merchant API key, "Bearer sk_live_..."
 func main() {
 	// init config
 	config := config.GetConfig()
@@ -48,7 +58,54 @@ func main() {
 	defer redisClient.Close()
 	log.Println("init redis")
 
-	psql := postgres.NewPostgresStorage(db)
+	// init exchange rates
+	var rates fx.RateProvider
+	if config.FX.RatesFile != "" {
+		rates, err = fx.LoadStaticProvider(config.FX.RatesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("init exchange rates")
+	}
+
+	// init access token keys
+	tokens, err := utils.NewJWTManager(config.Server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("init jwt keys")
+
+	// init card vault
+	cards, err := vault.NewVault(db, config.Vault)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// raw card numbers mustn't be served
+	if err := cards.CheckTokenized(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("init card vault")
+
+	// init encryption keys
+	keys, err := envelope.LoadLocalKeyProvider(config.Encryption)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("init encryption keys")
+
+	// init notifier, password reset is disabled without it
+	var notifier api.Notifier
+	if config.Notify.WebhookURL != "" {
+		notifier, err = notify.NewWebhookNotifier(config.Notify)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("init notify webhook")
+	} else {
+		log.Warn("NOTIFY_WEBHOOK_URL isn't set, password reset is disabled")
+	}
+
+	psql := postgres.NewPostgresStorage(db, keys)
 	redisStore := redisrepo.NewRedisStorage(redisClient)
 
 	jaegerCfgInstance := jConfig.Configuration{
@@ -78,11 +135,16 @@ func main() {
 
 	// init server
 	log.Println("init server")
-	s := api.NewJSONApiServer(config, db, redisClient, psql, redisStore, log)
+	s := api.NewJSONApiServer(config, db, redisClient, psql, redisStore, cards, rates, notifier, tokens, log)
 	go func() {
 		s.Run()
 	}()
 
+	// release holds of expired authorizations
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	defer stopExpiry()
+	go s.RunExpiry(expiryCtx, time.Duration(config.Expiry.Interval)*time.Second)
+
 	quitCh := make(chan os.Signal, 1)
 	signal.Notify(quitCh, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger_line;
+DROP TABLE IF EXISTS journal_entry;
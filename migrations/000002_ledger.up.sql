@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS journal_entry
+(
+	id UUID PRIMARY KEY,
+	payment_id UUID,
+	description VARCHAR(100),
+	created_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ledger_line
+(
+	id BIGSERIAL PRIMARY KEY,
+	entry_id UUID NOT NULL REFERENCES journal_entry (id),
+	account_id UUID NOT NULL,
+	book VARCHAR(20) NOT NULL,
+	currency VARCHAR(3) NOT NULL,
+	debit BIGINT NOT NULL DEFAULT 0 CHECK (debit >= 0),
+	credit BIGINT NOT NULL DEFAULT 0 CHECK (credit >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_line_account_idx ON ledger_line (account_id, currency);
+CREATE INDEX IF NOT EXISTS journal_entry_payment_idx ON journal_entry (payment_id);
+
+-- opening balances for accounts created before the ledger existed
+INSERT INTO journal_entry (id, description, created_at)
+	SELECT id, 'Opening balance', now() FROM account
+	WHERE balance > 0 OR blocked_money > 0;
+
+INSERT INTO ledger_line (entry_id, account_id, book, currency, debit, credit)
+	SELECT id, id, 'available', 'RUB', 0, balance FROM account WHERE balance > 0
+	UNION ALL
+	SELECT id, '00000000-0000-0000-0000-000000000000', 'funding', 'RUB', balance, 0 FROM account WHERE balance > 0
+	UNION ALL
+	SELECT id, id, 'blocked', 'RUB', 0, blocked_money FROM account WHERE blocked_money > 0
+	UNION ALL
+	SELECT id, '00000000-0000-0000-0000-000000000000', 'clearing', 'RUB', blocked_money, 0 FROM account WHERE blocked_money > 0;
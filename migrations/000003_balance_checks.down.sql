@@ -0,0 +1,5 @@
+ALTER TABLE account
+	DROP CONSTRAINT IF EXISTS account_balance_check,
+	DROP CONSTRAINT IF EXISTS account_blocked_money_check,
+	ALTER COLUMN balance DROP NOT NULL,
+	ALTER COLUMN blocked_money DROP NOT NULL;
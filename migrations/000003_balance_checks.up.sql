@@ -0,0 +1,16 @@
+ALTER TABLE account
+	ALTER COLUMN balance DROP DEFAULT,
+	ALTER COLUMN blocked_money DROP DEFAULT;
+
+DROP SEQUENCE IF EXISTS account_balance_seq;
+DROP SEQUENCE IF EXISTS account_blocked_money_seq;
+
+ALTER TABLE account
+	ALTER COLUMN balance TYPE BIGINT,
+	ALTER COLUMN balance SET DEFAULT 0,
+	ALTER COLUMN balance SET NOT NULL,
+	ALTER COLUMN blocked_money TYPE BIGINT,
+	ALTER COLUMN blocked_money SET DEFAULT 0,
+	ALTER COLUMN blocked_money SET NOT NULL,
+	ADD CONSTRAINT account_balance_check CHECK (balance >= 0),
+	ADD CONSTRAINT account_blocked_money_check CHECK (blocked_money >= 0);
@@ -0,0 +1,13 @@
+ALTER TABLE account
+	ADD COLUMN balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+	ADD COLUMN blocked_money BIGINT NOT NULL DEFAULT 0 CHECK (blocked_money >= 0);
+
+UPDATE account
+	SET balance = b.balance,
+		blocked_money = b.blocked_money
+	FROM account_balance AS b
+	WHERE b.account_id = account.id AND b.currency = account.currency;
+
+DROP TABLE IF EXISTS account_balance;
+
+ALTER TABLE account DROP COLUMN currency;
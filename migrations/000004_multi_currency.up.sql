@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS account_balance;
+
+ALTER TABLE account
+	ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
+
+CREATE TABLE IF NOT EXISTS account_balance
+(
+	account_id UUID NOT NULL REFERENCES account (id) ON DELETE CASCADE,
+	currency VARCHAR(3) NOT NULL,
+	balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+	blocked_money BIGINT NOT NULL DEFAULT 0 CHECK (blocked_money >= 0),
+	PRIMARY KEY (account_id, currency)
+);
+
+-- existing balances become the account currency balance
+INSERT INTO account_balance (account_id, currency, balance, blocked_money)
+	SELECT id, currency, balance, blocked_money FROM account;
+
+ALTER TABLE account
+	DROP COLUMN balance,
+	DROP COLUMN blocked_money;
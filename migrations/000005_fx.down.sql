@@ -0,0 +1,5 @@
+ALTER TABLE payment
+	DROP COLUMN IF EXISTS settlement_amount,
+	DROP COLUMN IF EXISTS settlement_currency,
+	DROP COLUMN IF EXISTS fx_rate,
+	DROP COLUMN IF EXISTS fx_rate_at;
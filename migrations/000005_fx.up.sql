@@ -0,0 +1,12 @@
+ALTER TABLE payment
+	ADD COLUMN IF NOT EXISTS settlement_amount BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS settlement_currency VARCHAR(3) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS fx_rate NUMERIC NOT NULL DEFAULT 1,
+	ADD COLUMN IF NOT EXISTS fx_rate_at TIMESTAMP;
+
+-- payments before exchange settle in the payment currency
+UPDATE payment
+	SET settlement_amount = amount,
+		settlement_currency = currency,
+		fx_rate_at = created_at
+	WHERE settlement_currency = '';
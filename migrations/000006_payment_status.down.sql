@@ -0,0 +1,7 @@
+UPDATE payment SET status = reason WHERE status = 'Failed';
+UPDATE payment SET status = 'Approved' WHERE operation = 'Authorization' AND reason = '';
+UPDATE payment SET status = 'Successful payment' WHERE operation = 'Capture' AND reason = '';
+UPDATE payment SET status = 'Successful refund' WHERE operation = 'Refund' AND reason = '';
+UPDATE payment SET status = 'Successful cancel' WHERE operation = 'Cancel' AND reason = '';
+
+ALTER TABLE payment DROP COLUMN IF EXISTS reason;
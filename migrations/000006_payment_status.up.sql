@@ -0,0 +1,10 @@
+ALTER TABLE payment
+	ADD COLUMN IF NOT EXISTS reason VARCHAR(100) NOT NULL DEFAULT '';
+
+-- statuses of the payment state machine
+UPDATE payment SET status = 'Authorized' WHERE status = 'Approved';
+UPDATE payment SET status = 'Captured' WHERE status = 'Successful payment';
+UPDATE payment SET status = 'Refunded' WHERE status = 'Successful refund';
+UPDATE payment SET status = 'Voided' WHERE status = 'Successful cancel';
+UPDATE payment SET status = 'Failed', reason = status
+	WHERE status IN ('Insufficient funds', 'wrong payment request', 'Invalid amount');
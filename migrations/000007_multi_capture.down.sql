@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS payment_reference_idx;
+
+ALTER TABLE payment
+	DROP COLUMN IF EXISTS captured_amount,
+	DROP COLUMN IF EXISTS released_amount,
+	DROP COLUMN IF EXISTS settled_amount,
+	DROP COLUMN IF EXISTS reference_id;
+
+DROP INDEX IF EXISTS payment_id_idx;
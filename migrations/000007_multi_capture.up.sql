@@ -0,0 +1,15 @@
+-- captures re-inserted the authorization with the rest of the amount,
+-- keep the first row with the authorized amount
+DELETE FROM payment AS a
+	USING payment AS b
+	WHERE a.id = b.id AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS payment_id_idx ON payment (id);
+
+ALTER TABLE payment
+	ADD COLUMN IF NOT EXISTS captured_amount BIGINT NOT NULL DEFAULT 0 CHECK (captured_amount >= 0),
+	ADD COLUMN IF NOT EXISTS released_amount BIGINT NOT NULL DEFAULT 0 CHECK (released_amount >= 0),
+	ADD COLUMN IF NOT EXISTS settled_amount BIGINT NOT NULL DEFAULT 0 CHECK (settled_amount >= 0),
+	ADD COLUMN IF NOT EXISTS reference_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+
+CREATE INDEX IF NOT EXISTS payment_reference_idx ON payment (reference_id);
@@ -0,0 +1 @@
+ALTER TABLE payment DROP COLUMN IF EXISTS refunded_amount;
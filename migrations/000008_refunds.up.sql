@@ -0,0 +1,2 @@
+ALTER TABLE payment
+	ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS payment_expires_idx;
+
+ALTER TABLE payment DROP COLUMN IF EXISTS expires_at;
+
+ALTER TABLE account DROP COLUMN IF EXISTS authorization_ttl;
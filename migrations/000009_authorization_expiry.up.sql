@@ -0,0 +1,15 @@
+ALTER TABLE account
+	ADD COLUMN IF NOT EXISTS authorization_ttl INTEGER NOT NULL DEFAULT 0 CHECK (authorization_ttl >= 0);
+
+ALTER TABLE payment
+	ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+-- holds of existing authorizations expire in a week
+UPDATE payment
+	SET expires_at = created_at + INTERVAL '7 days'
+	WHERE operation = 'Authorization'
+		AND status IN ('Authorized', 'Partially captured')
+		AND expires_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS payment_expires_idx ON payment (expires_at)
+	WHERE operation = 'Authorization' AND status IN ('Authorized', 'Partially captured');
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS api_key;
+
+CREATE TABLE IF NOT EXISTS api_key
+(
+	id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+	account_id UUID NOT NULL REFERENCES account (id) ON DELETE CASCADE,
+	type VARCHAR(11) NOT NULL CHECK (type IN ('secret', 'publishable')),
+	mode VARCHAR(4) NOT NULL CHECK (mode IN ('test', 'live')),
+	prefix VARCHAR(8) NOT NULL,
+	last4 VARCHAR(4) NOT NULL,
+	hash VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_key_account_idx ON api_key (account_id);
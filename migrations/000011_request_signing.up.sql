@@ -0,0 +1,3 @@
+-- keys created before signing have no secret and must be replaced
+ALTER TABLE api_key
+	ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS credential;
+
+CREATE TABLE IF NOT EXISTS credential
+(
+	account_id UUID PRIMARY KEY REFERENCES account (id) ON DELETE CASCADE,
+	password_hash VARCHAR(60) NOT NULL,
+	failed_logins INTEGER NOT NULL DEFAULT 0 CHECK (failed_logins >= 0),
+	locked_until TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT now()
+);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS totp;
+
+CREATE TABLE IF NOT EXISTS totp
+(
+	account_id UUID PRIMARY KEY REFERENCES account (id) ON DELETE CASCADE,
+	secret VARCHAR(32) NOT NULL,
+	recovery_codes TEXT[] NOT NULL DEFAULT '{}',
+	last_counter BIGINT NOT NULL DEFAULT 0,
+	confirmed_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
@@ -0,0 +1,9 @@
+ALTER TABLE account DROP COLUMN IF EXISTS role;
+ALTER TABLE account
+	ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'customer'
+		CHECK (role IN ('customer', 'merchant', 'support', 'admin'));
+
+-- accounts with API keys accept payments
+UPDATE account
+	SET role = 'merchant'
+	WHERE id IN (SELECT account_id FROM api_key);
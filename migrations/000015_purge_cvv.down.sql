@@ -0,0 +1,2 @@
+-- purged codes can't be restored
+ALTER TABLE account ADD COLUMN IF NOT EXISTS card_security_code VARCHAR(3);
@@ -0,0 +1,11 @@
+-- card security codes must not be stored, overwrite them before
+-- dropping the column, VACUUM FULL account rewrites the old rows
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'account' AND column_name = 'card_security_code') THEN
+		UPDATE account SET card_security_code = NULL;
+	END IF;
+END $$;
+
+ALTER TABLE account DROP COLUMN IF EXISTS card_security_code;
@@ -0,0 +1,16 @@
+-- tokens stay in the card number columns, the cards are lost with the vault
+DROP INDEX IF EXISTS account_card_token_idx;
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'account' AND column_name = 'card_token') THEN
+		ALTER TABLE account RENAME COLUMN card_token TO card_number;
+	END IF;
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'payment' AND column_name = 'card_token') THEN
+		ALTER TABLE payment RENAME COLUMN card_token TO card_number;
+	END IF;
+END $$;
+
+DROP TABLE IF EXISTS card_vault;
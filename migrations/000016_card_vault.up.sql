@@ -0,0 +1,28 @@
+-- card numbers encrypted by the vault, found by their keyed fingerprint
+CREATE TABLE IF NOT EXISTS card_vault
+(
+	token VARCHAR(64) PRIMARY KEY,
+	fingerprint VARCHAR(64) NOT NULL UNIQUE,
+	pan BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- accounts and payments reference cards by token,
+-- go run ./cmd/tokenize replaces the stored card numbers,
+-- the service doesn't start until it's done
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'account' AND column_name = 'card_number') THEN
+		ALTER TABLE account RENAME COLUMN card_number TO card_token;
+	END IF;
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_name = 'payment' AND column_name = 'card_number') THEN
+		ALTER TABLE payment RENAME COLUMN card_number TO card_token;
+	END IF;
+END $$;
+
+ALTER TABLE account ALTER COLUMN card_token TYPE VARCHAR(64);
+ALTER TABLE payment ALTER COLUMN card_token TYPE VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS account_card_token_idx ON account (card_token);
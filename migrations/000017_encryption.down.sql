@@ -0,0 +1,2 @@
+-- encrypted values don't fit the previous column types,
+-- the columns stay TEXT
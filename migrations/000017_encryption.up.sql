@@ -0,0 +1,8 @@
+-- personal and card data is stored encrypted,
+-- go run ./cmd/reencrypt encrypts the stored values
+ALTER TABLE account ALTER COLUMN first_name TYPE TEXT;
+ALTER TABLE account ALTER COLUMN last_name TYPE TEXT;
+ALTER TABLE account ALTER COLUMN card_expiry_month TYPE TEXT;
+ALTER TABLE account ALTER COLUMN card_expiry_year TYPE TEXT;
+ALTER TABLE payment ALTER COLUMN card_expiry_month TYPE TEXT;
+ALTER TABLE payment ALTER COLUMN card_expiry_year TYPE TEXT;
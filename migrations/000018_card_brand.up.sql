@@ -0,0 +1,2 @@
+-- brand detected by the BIN of the card number
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS card_brand VARCHAR(16) NOT NULL DEFAULT '';
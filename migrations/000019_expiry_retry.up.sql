@@ -0,0 +1,7 @@
+-- authorizations the expiry worker failed to expire, skipped until retry_at
+CREATE TABLE IF NOT EXISTS expiry_retry
+(
+	payment_id UUID PRIMARY KEY,
+	attempts INTEGER NOT NULL DEFAULT 1,
+	retry_at TIMESTAMP NOT NULL
+);
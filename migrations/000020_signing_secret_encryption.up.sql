@@ -0,0 +1,3 @@
+-- signing secrets are stored encrypted,
+-- go run ./cmd/reencrypt encrypts the stored secrets
+ALTER TABLE api_key ALTER COLUMN signing_secret TYPE TEXT;
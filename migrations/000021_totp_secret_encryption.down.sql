@@ -0,0 +1,2 @@
+-- encrypted secrets don't fit the previous column type,
+-- the column stays TEXT
@@ -0,0 +1,3 @@
+-- TOTP secrets are stored encrypted,
+-- go run ./cmd/reencrypt encrypts the stored secrets
+ALTER TABLE totp ALTER COLUMN secret TYPE TEXT;
@@ -0,0 +1 @@
+ALTER TABLE payment DROP COLUMN IF EXISTS account_id;
@@ -0,0 +1,10 @@
+-- buyer account of the payment, accounts may share a card,
+-- so the buyer isn't found by the card token
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS account_id UUID;
+
+-- payments are in the statements of the merchant and the buyer
+UPDATE payment SET account_id = account.id
+	FROM account
+	WHERE payment.account_id IS NULL
+		AND account.id <> payment.business_id
+		AND payment.id::text = ANY(account.statement);
@@ -0,0 +1,3 @@
+ALTER TABLE payment DROP COLUMN IF EXISTS card_number;
+ALTER TABLE account DROP COLUMN IF EXISTS card_number;
+ALTER TABLE card_vault DROP COLUMN IF EXISTS card_number;
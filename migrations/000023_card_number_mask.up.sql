@@ -0,0 +1,6 @@
+-- the vault keeps the masked card number shown in responses,
+-- accounts and payments copy it with the token,
+-- go run ./cmd/tokenize fills it for cards tokenized before
+ALTER TABLE card_vault ADD COLUMN IF NOT EXISTS card_number VARCHAR(19) NOT NULL DEFAULT '';
+ALTER TABLE account ADD COLUMN IF NOT EXISTS card_number VARCHAR(19) NOT NULL DEFAULT '';
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS card_number VARCHAR(19) NOT NULL DEFAULT '';
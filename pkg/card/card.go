@@ -0,0 +1,148 @@
+// Package card validates payment card data
+// and detects the card brand by the BIN of the card number.
+package card
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Card brand
+type Brand string
+
+const (
+	Visa       Brand = "visa"
+	Mastercard Brand = "mastercard"
+	Mir        Brand = "mir"
+	Amex       Brand = "amex"
+	UnionPay   Brand = "unionpay"
+)
+
+var (
+	ErrNumber       = errors.New("invalid card number")
+	ErrBrand        = errors.New("unsupported card brand")
+	ErrExpiryMonth  = errors.New("invalid card expiry month")
+	ErrExpiryYear   = errors.New("invalid card expiry year")
+	ErrExpired      = errors.New("card expired")
+	ErrSecurityCode = errors.New("invalid card security code")
+)
+
+// Card number length
+const (
+	minLength = 12
+	maxLength = 19
+)
+
+// Range of the leading digits of card numbers of a brand
+type binRange struct {
+	from, to string
+	brand    Brand
+	lengths  []int
+}
+
+// Ranges of the same prefix length don't overlap
+var bins = []binRange{
+	{from: "4", to: "4", brand: Visa, lengths: []int{13, 16, 19}},
+	{from: "51", to: "55", brand: Mastercard, lengths: []int{16}},
+	{from: "2221", to: "2720", brand: Mastercard, lengths: []int{16}},
+	{from: "2200", to: "2204", brand: Mir, lengths: []int{16, 17, 18, 19}},
+	{from: "34", to: "34", brand: Amex, lengths: []int{15}},
+	{from: "37", to: "37", brand: Amex, lengths: []int{15}},
+	{from: "62", to: "62", brand: UnionPay, lengths: []int{16, 17, 18, 19}},
+	{from: "81", to: "81", brand: UnionPay, lengths: []int{16, 17, 18, 19}},
+}
+
+// Brand of the card number, false if the BIN isn't known
+func Detect(number string) (Brand, bool) {
+	bin, ok := lookup(number)
+	return bin.brand, ok
+}
+
+// Digits only, 12-19 digits with a valid check digit and a known BIN
+func ValidateNumber(number string) (Brand, error) {
+	if len(number) < minLength || len(number) > maxLength || !digits(number) || !luhn(number) {
+		return "", ErrNumber
+	}
+	bin, ok := lookup(number)
+	if !ok {
+		return "", ErrBrand
+	}
+	for _, length := range bin.lengths {
+		if len(number) == length {
+			return bin.brand, nil
+		}
+	}
+	return "", ErrNumber
+}
+
+// MM and YY of the expiry date, the card is valid through the end of the month
+func ValidateExpiry(month, year string, now time.Time) error {
+	m, _ := strconv.Atoi(month)
+	if len(month) != 2 || !digits(month) || m < 1 || m > 12 {
+		return ErrExpiryMonth
+	}
+	if len(year) != 2 || !digits(year) {
+		return ErrExpiryYear
+	}
+	y, _ := strconv.Atoi(year)
+	// first day after the expiry month
+	expiry := time.Date(2000+y, time.Month(m)+1, 1, 0, 0, 0, 0, time.UTC)
+	if !now.Before(expiry) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// 4 digits for Amex, 3 digits for other brands
+func ValidateSecurityCode(brand Brand, securityCode string) error {
+	if !digits(securityCode) {
+		return ErrSecurityCode
+	}
+	switch {
+	case brand == Amex && len(securityCode) == 4,
+		brand != Amex && len(securityCode) == 3:
+		return nil
+	}
+	return ErrSecurityCode
+}
+
+func lookup(number string) (binRange, bool) {
+	for _, bin := range bins {
+		if len(number) < len(bin.from) {
+			continue
+		}
+		prefix := number[:len(bin.from)]
+		if prefix >= bin.from && prefix <= bin.to {
+			return bin, true
+		}
+	}
+	return binRange{}, false
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Check digit of the number
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
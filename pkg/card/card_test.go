@@ -0,0 +1,152 @@
+package card
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// number of the length with the prefix and a valid check digit
+func number(prefix string, length int) string {
+	body := prefix + strings.Repeat("0", length-len(prefix)-1)
+	for digit := 0; digit <= 9; digit++ {
+		if n := body + strconv.Itoa(digit); luhn(n) {
+			return n
+		}
+	}
+	panic("no check digit")
+}
+
+func Test_ValidateNumber(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		number string
+		brand  Brand
+		err    error
+	}{
+		// check digit
+		{"Luhn visa", "4242424242424242", Visa, nil},
+		{"Luhn mastercard", "5555555555554444", Mastercard, nil},
+		{"Luhn amex", "378282246310005", Amex, nil},
+		{"Luhn unionpay", "6200000000000005", UnionPay, nil},
+		{"Wrong check digit", "4242424242424241", "", ErrNumber},
+		{"Swapped digits", "2424424242424242", "", ErrNumber},
+		{"Wrong amex check digit", "378282246310006", "", ErrNumber},
+		// format
+		{"Letters", "42424242424242a2", "", ErrNumber},
+		{"Spaces", "4242 4242 4242 4242", "", ErrNumber},
+		{"Empty", "", "", ErrNumber},
+		{"Too short", number("4", 11), "", ErrNumber},
+		{"Too long", number("4", 20), "", ErrNumber},
+		// mastercard 51-55
+		{"Below 51", number("50", 16), "", ErrBrand},
+		{"51", number("51", 16), Mastercard, nil},
+		{"55", number("55", 16), Mastercard, nil},
+		{"Above 55", number("56", 16), "", ErrBrand},
+		// mastercard 2221-2720
+		{"Below 2221", number("2220", 16), "", ErrBrand},
+		{"2221", number("2221", 16), Mastercard, nil},
+		{"2720", number("2720", 16), Mastercard, nil},
+		{"Above 2720", number("2721", 16), "", ErrBrand},
+		// mir 2200-2204
+		{"Below 2200", number("2199", 16), "", ErrBrand},
+		{"2200", number("2200", 16), Mir, nil},
+		{"2204", number("2204", 16), Mir, nil},
+		{"Above 2204", number("2205", 16), "", ErrBrand},
+		// length of the brand
+		{"Visa 13", number("4", 13), Visa, nil},
+		{"Visa 16", number("4", 16), Visa, nil},
+		{"Visa 19", number("4", 19), Visa, nil},
+		{"Visa 15", number("4", 15), "", ErrNumber},
+		{"Mastercard 15", number("51", 15), "", ErrNumber},
+		{"Mastercard 17", number("2221", 17), "", ErrNumber},
+		{"Mir 19", number("2200", 19), Mir, nil},
+		{"Mir 15", number("2200", 15), "", ErrNumber},
+		{"Amex 34", number("34", 15), Amex, nil},
+		{"Amex 16", number("37", 16), "", ErrNumber},
+		{"Unionpay 81", number("81", 19), UnionPay, nil},
+		{"Unionpay 15", number("62", 15), "", ErrNumber},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			brand, err := ValidateNumber(tt.number)
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.brand, brand)
+		})
+	}
+}
+
+func Test_Detect(t *testing.T) {
+	t.Parallel()
+
+	brand, ok := Detect("2221")
+	require.True(t, ok)
+	require.Equal(t, Mastercard, brand)
+
+	_, ok = Detect("9")
+	require.False(t, ok)
+	_, ok = Detect("")
+	require.False(t, ok)
+}
+
+func Test_ValidateExpiry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		month string
+		year  string
+		now   time.Time
+		err   error
+	}{
+		{"Last second of the month", "03", "26", time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC), nil},
+		{"First day after the month", "03", "26", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), ErrExpired},
+		{"End of the year", "12", "26", time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC), nil},
+		{"Next year", "12", "26", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), ErrExpired},
+		{"February of a leap year", "02", "28", time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC), nil},
+		{"Future", "01", "30", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"Month 00", "00", "30", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryMonth},
+		{"Month 13", "13", "30", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryMonth},
+		{"One digit month", "3", "30", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryMonth},
+		{"Signed month", "+3", "30", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryMonth},
+		{"Four digit year", "03", "2030", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryYear},
+		{"Letters in the year", "03", "3o", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), ErrExpiryYear},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, ValidateExpiry(tt.month, tt.year, tt.now), tt.err)
+		})
+	}
+}
+
+func Test_ValidateSecurityCode(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		brand Brand
+		code  string
+		err   error
+	}{
+		{"Amex 4 digits", Amex, "1234", nil},
+		{"Amex 3 digits", Amex, "123", ErrSecurityCode},
+		{"Visa 3 digits", Visa, "123", nil},
+		{"Visa 4 digits", Visa, "1234", ErrSecurityCode},
+		{"Mastercard 3 digits", Mastercard, "924", nil},
+		{"Mir 4 digits", Mir, "1234", ErrSecurityCode},
+		{"Unknown brand 3 digits", "", "123", nil},
+		{"Unknown brand 4 digits", "", "1234", ErrSecurityCode},
+		{"Letters", Visa, "12a", ErrSecurityCode},
+		{"Empty", Visa, "", ErrSecurityCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, ValidateSecurityCode(tt.brand, tt.code), tt.err)
+		})
+	}
+}
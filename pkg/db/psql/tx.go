@@ -0,0 +1,47 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// Number of attempts for transactions aborted by serialization failures or deadlocks
+const txAttempts = 3
+
+// Run fn in a transaction, commit it if fn succeeds.
+// The transaction is retried if postgres aborted it because of a
+// serialization failure or a deadlock.
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 0; attempt < txAttempts; attempt++ {
+		err = runTx(ctx, db, fn)
+		if !Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func runTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Serialization failure or deadlock
+func Retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
@@ -0,0 +1,124 @@
+// Package envelope encrypts columns with per-record data keys
+// wrapped by versioned master keys of a KeyProvider.
+//
+// Encrypted values are self-contained text:
+//
+//	enc:<master key version>:<wrapped data key>:<nonce and ciphertext>
+//
+// with base64 encoded binary parts, so the master key can be rotated
+// by re-encrypting records one by one.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Prefix of encrypted values
+const Prefix = "enc:"
+
+var errEnvelope = errors.New("malformed encrypted value")
+
+// Encrypts and decrypts columns
+type Cipher struct {
+	keys KeyProvider
+}
+
+func NewCipher(keys KeyProvider) *Cipher {
+	return &Cipher{keys: keys}
+}
+
+// Encrypts the columns of one record in place with a new data key.
+// Empty values stay empty, the column name is authenticated
+// so values can't be swapped between columns
+func (c *Cipher) Seal(ctx context.Context, table string, columns map[string]*string) error {
+	key, err := c.keys.GenerateDataKey(ctx)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key.Plaintext)
+	if err != nil {
+		return err
+	}
+	header := Prefix + strconv.Itoa(key.Version) + ":" + base64.RawStdEncoding.EncodeToString(key.Wrapped) + ":"
+	for column, value := range columns {
+		if *value == "" {
+			continue
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(*value), []byte(table+"."+column))
+		*value = header + base64.RawStdEncoding.EncodeToString(sealed)
+	}
+	return nil
+}
+
+// Decrypts the columns of one record in place,
+// values stored before encryption are left as they are
+func (c *Cipher) Open(ctx context.Context, table string, columns map[string]*string) error {
+	// columns of a record share the data key
+	keys := map[string]cipher.AEAD{}
+	for column, value := range columns {
+		if !strings.HasPrefix(*value, Prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(*value, Prefix), ":")
+		if len(parts) != 3 {
+			return errEnvelope
+		}
+		aead, ok := keys[parts[0]+":"+parts[1]]
+		if !ok {
+			version, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return errEnvelope
+			}
+			wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return errEnvelope
+			}
+			key, err := c.keys.DecryptDataKey(ctx, version, wrapped)
+			if err != nil {
+				return err
+			}
+			if aead, err = newAEAD(key); err != nil {
+				return err
+			}
+			keys[parts[0]+":"+parts[1]] = aead
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return errEnvelope
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(table+"."+column))
+		if err != nil {
+			return err
+		}
+		*value = string(plaintext)
+	}
+	return nil
+}
+
+// Check if the value isn't encrypted with the current master key
+func (c *Cipher) Stale(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, Prefix+strconv.Itoa(c.keys.CurrentVersion())+":")
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
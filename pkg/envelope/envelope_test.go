@@ -0,0 +1,156 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKeyV1 = bytes.Repeat([]byte{0x0f}, 32)
+	testKeyV2 = bytes.Repeat([]byte{0xf0}, 32)
+)
+
+func newKeys(t *testing.T, keys map[int][]byte, current int) KeyProvider {
+	provider, err := NewLocalKeyProvider(keys, current)
+	require.NoError(t, err)
+	return provider
+}
+
+// columns of a sealed record
+func sealRecord(t *testing.T, cipher *Cipher) (string, string) {
+	first, last := "Pasha", "volkov"
+	require.NoError(t, cipher.Seal(context.Background(), "account", map[string]*string{
+		"first_name": &first,
+		"last_name":  &last,
+	}))
+	return first, last
+}
+
+func Test_Cipher(t *testing.T) {
+	t.Parallel()
+
+	cipher := NewCipher(newKeys(t, map[int][]byte{1: testKeyV1}, 1))
+
+	t.Run("Round trip", func(t *testing.T) {
+		first, last := sealRecord(t, cipher)
+		require.True(t, strings.HasPrefix(first, Prefix+"1:"))
+		require.NotContains(t, first, "Pasha")
+		// columns of a record share the data key
+		require.Equal(t, first[:strings.LastIndex(first, ":")], last[:strings.LastIndex(last, ":")])
+		require.False(t, cipher.Stale(first))
+
+		require.NoError(t, cipher.Open(context.Background(), "account", map[string]*string{
+			"first_name": &first,
+			"last_name":  &last,
+		}))
+		require.Equal(t, "Pasha", first)
+		require.Equal(t, "volkov", last)
+	})
+
+	t.Run("Empty and plain values", func(t *testing.T) {
+		empty, plain := "", "Pasha"
+		require.NoError(t, cipher.Seal(context.Background(), "account", map[string]*string{"first_name": &empty}))
+		require.Empty(t, empty)
+		require.False(t, cipher.Stale(empty))
+
+		// stored before encryption
+		require.True(t, cipher.Stale(plain))
+		require.NoError(t, cipher.Open(context.Background(), "account", map[string]*string{"first_name": &plain}))
+		require.Equal(t, "Pasha", plain)
+	})
+
+	t.Run("Same value", func(t *testing.T) {
+		first, _ := sealRecord(t, cipher)
+		again, _ := sealRecord(t, cipher)
+		require.NotEqual(t, first, again)
+	})
+}
+
+func Test_CipherRotation(t *testing.T) {
+	t.Parallel()
+
+	old := NewCipher(newKeys(t, map[int][]byte{1: testKeyV1}, 1))
+	rotated := NewCipher(newKeys(t, map[int][]byte{1: testKeyV1, 2: testKeyV2}, 2))
+
+	first, last := sealRecord(t, old)
+	require.True(t, rotated.Stale(first))
+
+	// old records are read with the old master key
+	require.NoError(t, rotated.Open(context.Background(), "account", map[string]*string{
+		"first_name": &first,
+		"last_name":  &last,
+	}))
+	require.Equal(t, "Pasha", first)
+
+	// and sealed again with the current one
+	first, _ = sealRecord(t, rotated)
+	require.True(t, strings.HasPrefix(first, Prefix+"2:"))
+	require.False(t, rotated.Stale(first))
+
+	// the old master key is removed after re-encryption
+	current := NewCipher(newKeys(t, map[int][]byte{2: testKeyV2}, 2))
+	require.NoError(t, current.Open(context.Background(), "account", map[string]*string{"first_name": &first}))
+	require.Equal(t, "Pasha", first)
+
+	stale, _ := sealRecord(t, old)
+	require.ErrorIs(t, current.Open(context.Background(), "account", map[string]*string{"first_name": &stale}), errKeyVersion)
+}
+
+func Test_CipherTamper(t *testing.T) {
+	t.Parallel()
+
+	cipher := NewCipher(newKeys(t, map[int][]byte{1: testKeyV1}, 1))
+
+	// flips a bit of the part of the value
+	tamper := func(value string, part int) string {
+		parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+		raw, err := base64.RawStdEncoding.DecodeString(parts[part])
+		require.NoError(t, err)
+		raw[len(raw)-1] ^= 1
+		parts[part] = base64.RawStdEncoding.EncodeToString(raw)
+		return Prefix + strings.Join(parts, ":")
+	}
+
+	tests := []struct {
+		name  string
+		value func(first, last string) string
+		// column the value is opened as
+		column string
+	}{
+		{"Ciphertext", func(first, _ string) string { return tamper(first, 2) }, "first_name"},
+		{"Wrapped data key", func(first, _ string) string { return tamper(first, 1) }, "first_name"},
+		{"Version", func(first, _ string) string { return strings.Replace(first, Prefix+"1:", Prefix+"2:", 1) }, "first_name"},
+		{"Swapped columns", func(_, last string) string { return last }, "first_name"},
+		{"Other column", func(first, _ string) string { return first }, "card_expiry_month"},
+		{"Malformed", func(first, _ string) string { return first[:strings.LastIndex(first, ":")] }, "first_name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last := sealRecord(t, cipher)
+			value := tt.value(first, last)
+			require.Error(t, cipher.Open(context.Background(), "account", map[string]*string{tt.column: &value}))
+		})
+	}
+
+	t.Run("Other table", func(t *testing.T) {
+		first, _ := sealRecord(t, cipher)
+		require.Error(t, cipher.Open(context.Background(), "payment", map[string]*string{"first_name": &first}))
+	})
+}
+
+func Test_CipherWrongKey(t *testing.T) {
+	t.Parallel()
+
+	first, _ := sealRecord(t, NewCipher(newKeys(t, map[int][]byte{1: testKeyV1}, 1)))
+
+	// same version, another master key
+	other := NewCipher(newKeys(t, map[int][]byte{1: testKeyV2}, 1))
+	value := first
+	require.Error(t, other.Open(context.Background(), "account", map[string]*string{"first_name": &value}))
+	require.Equal(t, first, value)
+}
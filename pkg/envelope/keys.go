@@ -0,0 +1,151 @@
+package envelope
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Edbeer/paymentapi/config"
+)
+
+var (
+	errNoKeys     = errors.New("no master keys")
+	errKeyVersion = errors.New("unknown master key version")
+	errMasterKey  = errors.New("master key must be 32 hex encoded bytes")
+	errWrapped    = errors.New("malformed wrapped data key")
+)
+
+// Master keys wrapping the data keys, a KMS or a local stand-in
+type KeyProvider interface {
+	// New data key, plain and wrapped with the current master key
+	GenerateDataKey(ctx context.Context) (*DataKey, error)
+	// Plain data key wrapped with the master key of the version
+	DecryptDataKey(ctx context.Context, version int, wrapped []byte) ([]byte, error)
+	// Version of the master key wrapping new data keys
+	CurrentVersion() int
+}
+
+// Data key of a record, only the wrapped key is stored
+type DataKey struct {
+	Version   int
+	Plaintext []byte
+	Wrapped   []byte
+}
+
+// Master keys kept in memory, for development and tests
+type LocalKeyProvider struct {
+	keys    map[int]cipher.AEAD
+	current int
+}
+
+// Constructor, the current version must be one of the keys
+func NewLocalKeyProvider(keys map[int][]byte, current int) (*LocalKeyProvider, error) {
+	if len(keys) == 0 {
+		return nil, errNoKeys
+	}
+	provider := &LocalKeyProvider{keys: map[int]cipher.AEAD{}, current: current}
+	for version, key := range keys {
+		if len(key) != 32 {
+			return nil, errMasterKey
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		provider.keys[version] = aead
+	}
+	if _, ok := provider.keys[current]; !ok {
+		return nil, errKeyVersion
+	}
+	return provider, nil
+}
+
+// Master keys of the file, one "version:hex key" per line.
+// New data keys are wrapped with the configured version, the latest by default
+func LoadLocalKeyProvider(cfg config.Encryption) (*LocalKeyProvider, error) {
+	file, err := os.Open(cfg.KeysFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := map[int][]byte{}
+	latest := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v, k, ok := strings.Cut(line, ":")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid master key line %q", v)
+		}
+		key, err := hex.DecodeString(k)
+		if err != nil {
+			return nil, errMasterKey
+		}
+		keys[version] = key
+		if version > latest {
+			latest = version
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	current := cfg.KeyVersion
+	if current == 0 {
+		current = latest
+	}
+	return NewLocalKeyProvider(keys, current)
+}
+
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) (*DataKey, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	master := p.keys[p.current]
+	nonce := make([]byte, master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &DataKey{
+		Version:   p.current,
+		Plaintext: key,
+		Wrapped:   master.Seal(nonce, nonce, key, versionAAD(p.current)),
+	}, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(ctx context.Context, version int, wrapped []byte) ([]byte, error) {
+	master, ok := p.keys[version]
+	if !ok {
+		return nil, errKeyVersion
+	}
+	if len(wrapped) < master.NonceSize() {
+		return nil, errWrapped
+	}
+	nonce, ciphertext := wrapped[:master.NonceSize()], wrapped[master.NonceSize():]
+	return master.Open(nil, nonce, ciphertext, versionAAD(version))
+}
+
+func (p *LocalKeyProvider) CurrentVersion() int {
+	return p.current
+}
+
+func versionAAD(version int) []byte {
+	return []byte(strconv.Itoa(version))
+}
@@ -0,0 +1,93 @@
+package envelope
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewLocalKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		keys    map[int][]byte
+		current int
+		err     error
+	}{
+		{"No keys", map[int][]byte{}, 1, errNoKeys},
+		{"Short key", map[int][]byte{1: testKeyV1[:16]}, 1, errMasterKey},
+		{"Unknown version", map[int][]byte{1: testKeyV1}, 2, errKeyVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLocalKeyProvider(tt.keys, tt.current)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func Test_LocalKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	keys := newKeys(t, map[int][]byte{1: testKeyV1, 2: testKeyV2}, 2)
+	require.Equal(t, 2, keys.CurrentVersion())
+
+	key, err := keys.GenerateDataKey(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, key.Version)
+	require.Len(t, key.Plaintext, 32)
+	require.NotContains(t, string(key.Wrapped), string(key.Plaintext))
+
+	plaintext, err := keys.DecryptDataKey(context.Background(), 2, key.Wrapped)
+	require.NoError(t, err)
+	require.Equal(t, key.Plaintext, plaintext)
+
+	// the version is authenticated
+	_, err = keys.DecryptDataKey(context.Background(), 1, key.Wrapped)
+	require.Error(t, err)
+	_, err = keys.DecryptDataKey(context.Background(), 3, key.Wrapped)
+	require.ErrorIs(t, err, errKeyVersion)
+	_, err = keys.DecryptDataKey(context.Background(), 2, key.Wrapped[:4])
+	require.ErrorIs(t, err, errWrapped)
+}
+
+func Test_LoadLocalKeyProvider(t *testing.T) {
+	t.Parallel()
+
+	writeKeys := func(t *testing.T, lines ...string) string {
+		path := filepath.Join(t.TempDir(), "keys")
+		require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600))
+		return path
+	}
+	v1, v2 := "1:"+strings.Repeat("0f", 32), "2:"+strings.Repeat("f0", 32)
+
+	t.Run("Latest version", func(t *testing.T) {
+		keys, err := LoadLocalKeyProvider(config.Encryption{KeysFile: writeKeys(t, "# master keys", v2, "", v1)})
+		require.NoError(t, err)
+		require.Equal(t, 2, keys.CurrentVersion())
+	})
+
+	t.Run("Configured version", func(t *testing.T) {
+		keys, err := LoadLocalKeyProvider(config.Encryption{KeysFile: writeKeys(t, v1, v2), KeyVersion: 1})
+		require.NoError(t, err)
+		require.Equal(t, 1, keys.CurrentVersion())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, line := range []string{"0:" + strings.Repeat("0f", 32), "key", "1:zz"} {
+			_, err := LoadLocalKeyProvider(config.Encryption{KeysFile: writeKeys(t, line)})
+			require.Error(t, err, line)
+		}
+	})
+
+	t.Run("No file", func(t *testing.T) {
+		_, err := LoadLocalKeyProvider(config.Encryption{KeysFile: filepath.Join(t.TempDir(), "keys")})
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
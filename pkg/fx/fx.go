@@ -0,0 +1,53 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/Edbeer/paymentapi/types"
+)
+
+// Digits after the decimal point of a locked rate
+const ratePrecision = 10
+
+var ErrNoRate = errors.New("exchange rate isn't available")
+
+// Mid-market rate: units of To currency per unit of From currency
+type Rate struct {
+	From     string
+	To       string
+	Value    *big.Rat
+	QuotedAt time.Time
+}
+
+// Exchange rate provider
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (*Rate, error)
+}
+
+// Lock exchange rate for a payment. Markup in basis points
+// lowers the rate in favour of the service.
+func Quote(ctx context.Context, provider RateProvider, from, to string, markupBps int64) (*types.ExchangeRate, error) {
+	if from == to {
+		return types.IdentityRate(from, time.Now()), nil
+	}
+	if provider == nil {
+		return nil, ErrNoRate
+	}
+	rate, err := provider.Rate(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	value := new(big.Rat).Mul(rate.Value, big.NewRat(10000-markupBps, 10000))
+	if value.Sign() <= 0 {
+		return nil, ErrNoRate
+	}
+	return &types.ExchangeRate{
+		From:     from,
+		To:       to,
+		Rate:     value.FloatString(ratePrecision),
+		QuotedAt: rate.QuotedAt,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rates kept in memory, works offline.
+// Pairs are "FROM/TO", the reverse pair is derived.
+type StaticProvider struct {
+	mu       sync.RWMutex
+	rates    map[string]*big.Rat
+	quotedAt time.Time
+}
+
+// Rates file
+type ratesFile struct {
+	QuotedAt time.Time         `json:"quoted_at"`
+	Rates    map[string]string `json:"rates"`
+}
+
+func NewStaticProvider(rates map[string]string, quotedAt time.Time) (*StaticProvider, error) {
+	p := &StaticProvider{}
+	if err := p.Set(rates, quotedAt); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Load rates from a JSON file:
+// {"quoted_at": "2023-03-01T00:00:00Z", "rates": {"EUR/RUB": "79.5"}}
+func LoadStaticProvider(path string) (*StaticProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := &ratesFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, err
+	}
+	return NewStaticProvider(file.Rates, file.QuotedAt)
+}
+
+// Replace all rates
+func (p *StaticProvider) Set(rates map[string]string, quotedAt time.Time) error {
+	parsed := make(map[string]*big.Rat, len(rates))
+	for pair, rate := range rates {
+		if len(strings.Split(pair, "/")) != 2 {
+			return fmt.Errorf("invalid currency pair %q", pair)
+		}
+		value, ok := new(big.Rat).SetString(rate)
+		if !ok || value.Sign() <= 0 {
+			return fmt.Errorf("invalid rate %q for %s", rate, pair)
+		}
+		parsed[pair] = value
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rates = parsed
+	p.quotedAt = quotedAt
+	return nil
+}
+
+func (p *StaticProvider) Rate(ctx context.Context, from, to string) (*Rate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rate := &Rate{From: from, To: to, QuotedAt: p.quotedAt}
+	if value, ok := p.rates[from+"/"+to]; ok {
+		rate.Value = new(big.Rat).Set(value)
+		return rate, nil
+	}
+	if value, ok := p.rates[to+"/"+from]; ok {
+		rate.Value = new(big.Rat).Inv(value)
+		return rate, nil
+	}
+	return nil, ErrNoRate
+}
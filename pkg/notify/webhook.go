@@ -0,0 +1,97 @@
+// Package notify delivers messages to account owners
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+)
+
+var (
+	errWebhookURL    = errors.New("notify webhook url must be an https url")
+	errWebhookSecret = errors.New("notify webhook secret is required")
+)
+
+// Webhook request timeout
+const webhookTimeout = 10 * time.Second
+
+// Posts messages to the webhook of a delivery service (mail, sms),
+// which sends them to the account owner. Messages carry secrets,
+// so they're only posted over https and signed with the webhook secret
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewWebhookNotifier(cfg config.Notify) (*WebhookNotifier, error) {
+	u, err := url.Parse(cfg.WebhookURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, errWebhookURL
+	}
+	if cfg.WebhookSecret == "" {
+		return nil, errWebhookSecret
+	}
+	return &WebhookNotifier{
+		url:    cfg.WebhookURL,
+		secret: cfg.WebhookSecret,
+		client: &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+// Password reset message
+type passwordReset struct {
+	Event     string    `json:"event"`
+	AccountID uuid.UUID `json:"account_id"`
+	Token     string    `json:"token"`
+}
+
+func (n *WebhookNotifier) PasswordReset(ctx context.Context, account *types.Account, token string) error {
+	body, err := json.Marshal(passwordReset{
+		Event:     "password_reset",
+		AccountID: account.ID,
+		Token:     token,
+	})
+	if err != nil {
+		return err
+	}
+	return n.post(ctx, body)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", Signature(n.secret, body))
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notify webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// Hex encoded HMAC-SHA256 of the body, the delivery service checks
+// the X-Signature header with it
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
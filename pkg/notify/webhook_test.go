@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewWebhookNotifier(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		cfg  config.Notify
+		err  error
+	}{
+		{"Https", config.Notify{WebhookURL: "https://mail.internal/notify", WebhookSecret: "secret"}, nil},
+		{"Http", config.Notify{WebhookURL: "http://mail.internal/notify", WebhookSecret: "secret"}, errWebhookURL},
+		{"No host", config.Notify{WebhookURL: "https:///notify", WebhookSecret: "secret"}, errWebhookURL},
+		{"No secret", config.Notify{WebhookURL: "https://mail.internal/notify"}, errWebhookSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWebhookNotifier(tt.cfg)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func Test_PasswordReset(t *testing.T) {
+	t.Parallel()
+
+	account := &types.Account{ID: uuid.New()}
+	status := http.StatusNoContent
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, Signature("secret", body), r.Header.Get("X-Signature"))
+
+		message := passwordReset{}
+		require.NoError(t, json.Unmarshal(body, &message))
+		require.Equal(t, passwordReset{Event: "password_reset", AccountID: account.ID, Token: "token"}, message)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(config.Notify{WebhookURL: server.URL, WebhookSecret: "secret"})
+	require.NoError(t, err)
+	notifier.client = server.Client()
+
+	t.Run("Delivered", func(t *testing.T) {
+		require.NoError(t, notifier.PasswordReset(context.Background(), account, "token"))
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		status = http.StatusBadGateway
+		require.Error(t, notifier.PasswordReset(context.Background(), account, "token"))
+	})
+}
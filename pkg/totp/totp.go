@@ -0,0 +1,87 @@
+// Package totp implements RFC 6238 time-based one-time passwords
+// with HMAC-SHA1, 6 digits and 30 second steps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digits = 6
+	period = 30
+	// accepted clock drift in steps
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Random 160-bit secret in base32
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// Key URI for authenticator apps
+func URI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(digits))
+	query.Set("period", fmt.Sprint(period))
+	return (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}).String()
+}
+
+// Time step of the moment
+func Counter(now time.Time) int64 {
+	return now.Unix() / period
+}
+
+// Code of the time step
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1000000), nil
+}
+
+// Time step the code belongs to, codes of neighbouring steps
+// are accepted for clock drift
+func Validate(secret, code string, now time.Time) (int64, bool) {
+	counter := Counter(now)
+	for step := counter - skew; step <= counter+skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+)
+
+var errAPIKey = errors.New("invalid api key")
+
+// API key prefixes by type and mode
+var apiKeyPrefixes = map[string]map[string]string{
+	types.APIKeySecret: {
+		types.APIKeyModeLive: "sk_live_",
+	},
+	types.APIKeyPublishable: {
+		types.APIKeyModeLive: "pk_live_",
+	},
+}
+
+// Create API key of the type and mode, returns the key and its stored part,
+// secret keys get a secret to sign requests
+func CreateAPIKey(accountID uuid.UUID, keyType, mode string) (string, *types.APIKey, error) {
+	prefix, ok := apiKeyPrefixes[keyType][mode]
+	if !ok {
+		return "", nil, errAPIKey
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := prefix + hex.EncodeToString(secret)
+	signingSecret := ""
+	if keyType == types.APIKeySecret {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return "", nil, err
+		}
+		signingSecret = hex.EncodeToString(secret)
+	}
+	return key, &types.APIKey{
+		AccountID:     accountID,
+		Type:          keyType,
+		Mode:          mode,
+		Prefix:        prefix,
+		Last4:         key[len(key)-4:],
+		Hash:          HashAPIKey(key),
+		CreatedAt:     time.Now(),
+		SigningSecret: signingSecret,
+	}, nil
+}
+
+// Type and mode of the key by its prefix
+func ParseAPIKey(key string) (string, string, error) {
+	for keyType, modes := range apiKeyPrefixes {
+		for mode, prefix := range modes {
+			if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+				return keyType, mode, nil
+			}
+		}
+	}
+	return "", "", errAPIKey
+}
+
+// Keys are random, so a plain hash is enough to look them up
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -1,22 +1,179 @@
 package utils
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/Edbeer/paymentapi/config"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-// Create JWT
-func CreateJWT(account *types.Account) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":        account.ID.String(),
-		"card":      account.CardNumber,
-		"expire_at": 15000,
+// Defaults if the config doesn't set them
+const (
+	defaultJWTIssuer      = "paymentapi"
+	defaultAccessTokenTTL = 900
+	// key id of the HS256 secret
+	secretKeyID = "secret"
+)
+
+// Key of access tokens, public keys without the private part
+// only verify tokens signed before rotation
+type JWTKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// Signs and validates access tokens
+type JWTManager struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+	signing  *JWTKey
+	keys     map[string]*JWTKey
+}
+
+// Create manager from the server config: PEM keys of the keys directory
+// or the HS256 secret. The signing key is the configured one
+// or the last one by name
+func NewJWTManager(cfg config.Server) (*JWTManager, error) {
+	m := &JWTManager{
+		issuer:   cfg.JwtIssuer,
+		audience: cfg.JwtAudience,
+		ttl:      time.Duration(cfg.AccessTokenTTL) * time.Second,
+		keys:     make(map[string]*JWTKey),
+	}
+	if m.issuer == "" {
+		m.issuer = defaultJWTIssuer
+	}
+	if m.audience == "" {
+		m.audience = defaultJWTIssuer
+	}
+	if m.ttl <= 0 {
+		m.ttl = defaultAccessTokenTTL * time.Second
+	}
+
+	if cfg.JwtKeysDir == "" {
+		if cfg.JwtSecretKey == "" {
+			return nil, errors.New("JWT_SECRET_KEY or JWT_KEYS_DIR is required")
+		}
+		m.signing = &JWTKey{
+			ID:      secretKeyID,
+			Method:  jwt.SigningMethodHS256,
+			private: []byte(cfg.JwtSecretKey),
+			public:  []byte(cfg.JwtSecretKey),
+		}
+		m.keys[secretKeyID] = m.signing
+		return m, nil
+	}
+
+	keys, err := LoadJWTKeys(cfg.JwtKeysDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		m.keys[key.ID] = key
+		if key.private != nil && (cfg.JwtSigningKeyID == "" || key.ID == cfg.JwtSigningKeyID) {
+			m.signing = key
+		}
+	}
+	if m.signing == nil {
+		return nil, fmt.Errorf("no private signing key %q in %s", cfg.JwtSigningKeyID, cfg.JwtKeysDir)
+	}
+	return m, nil
+}
+
+// Load RSA and Ed25519 keys from the *.pem files of the directory sorted by name
+func LoadJWTKeys(dir string) ([]*JWTKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	keys := []*JWTKey{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseJWTKey(strings.TrimSuffix(filepath.Base(file), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Parse PEM encoded private (PKCS #8, PKCS #1) or public (PKIX) key
+func ParseJWTKey(id string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &JWTKey{ID: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+// Create access token of the account
+func (m *JWTManager) CreateJWT(account *types.Account) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(m.signing.Method, jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   account.ID.String(),
+		Issuer:    m.issuer,
+		Audience:  jwt.ClaimStrings{m.audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
 	})
+	token.Header["kid"] = m.signing.ID
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	tokenString, err := token.SignedString(m.signing.private)
 	if err != nil {
 		return "", err
 	}
@@ -24,16 +181,65 @@ func CreateJWT(account *types.Account) (string, error) {
 	return tokenString, nil
 }
 
-// Validate JWT
-func ValidateJWT(tokenString string) (*jwt.Token, error) {
-	secret := os.Getenv("JWT_SECRET_KEY")
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+// Validate access token: signature of a known key, expiry, issuer and audience
+func (m *JWTManager) ValidateJWT(tokenString string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
 		}
-
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return []byte(secret), nil
+		// the alg must be the one of the key
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
 	})
-}
\ No newline at end of file
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiry")
+	}
+	if !claims.VerifyIssuer(m.issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if !claims.VerifyAudience(m.audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
+	return claims, nil
+}
+
+// Public keys of access tokens, the HS256 secret is never published
+func (m *JWTManager) JWKS() *types.JWKS {
+	ids := make([]string, 0, len(m.keys))
+	for id := range m.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := &types.JWKS{Keys: []types.JWK{}}
+	for _, id := range ids {
+		key := m.keys[id]
+		jwk := types.JWK{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
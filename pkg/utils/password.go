@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errPassword = errors.New("password must be 8 to 72 characters long")
+
+// bcrypt ignores bytes after the 72nd
+func ValidatePassword(password string) error {
+	if len(password) < 8 || len(password) > 72 {
+		return errPassword
+	}
+	return nil
+}
+
+// Hash password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Check password against its hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Edbeer/paymentapi/pkg/card"
+)
+
+// Invalid field of a request, the field is its json name
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Field error codes
+const (
+	CodeRequired    = "required"
+	CodeInvalid     = "invalid"
+	CodeTooLong     = "too_long"
+	CodeExpired     = "expired"
+	CodeUnsupported = "unsupported"
+)
+
+// All invalid fields of a request
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, field := range e {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+var (
+	errRequired = errors.New("is required")
+	errPositive = errors.New("must be positive")
+)
+
+// Collects the invalid fields of a request
+type validation struct {
+	errs ValidationErrors
+}
+
+func (v *validation) add(field, code string, err error) {
+	v.errs = append(v.errs, FieldError{Field: field, Code: code, Message: err.Error()})
+}
+
+// nil if all fields are valid
+func (v *validation) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func (v *validation) required(field, value string) bool {
+	if value == "" {
+		v.add(field, CodeRequired, errRequired)
+		return false
+	}
+	return true
+}
+
+func (v *validation) positive(field string, value uint64) {
+	if value == 0 {
+		v.add(field, CodeRequired, errPositive)
+	}
+}
+
+func (v *validation) maxLength(field, value string, max int) {
+	if len(value) > max {
+		v.add(field, CodeTooLong, fmt.Errorf("must be at most %d characters", max))
+	}
+}
+
+func (v *validation) currency(field, code string) {
+	if !v.required(field, code) {
+		return
+	}
+	if err := ValidateCurrency(code); err != nil {
+		v.add(field, CodeInvalid, err)
+	}
+}
+
+// brand of a valid card number
+func (v *validation) cardNumber(field, number string) card.Brand {
+	if !v.required(field, number) {
+		return ""
+	}
+	brand, err := card.ValidateNumber(number)
+	if errors.Is(err, card.ErrBrand) {
+		v.add(field, CodeUnsupported, err)
+	} else if err != nil {
+		v.add(field, CodeInvalid, err)
+	}
+	return brand
+}
+
+func (v *validation) cardExpiry(month, year string) {
+	hasMonth, hasYear := v.required("card_expiry_month", month), v.required("card_expiry_year", year)
+	if !hasMonth || !hasYear {
+		return
+	}
+	switch err := card.ValidateExpiry(month, year, time.Now()); {
+	case errors.Is(err, card.ErrExpiryMonth):
+		v.add("card_expiry_month", CodeInvalid, err)
+	case errors.Is(err, card.ErrExpiryYear):
+		v.add("card_expiry_year", CodeInvalid, err)
+	case errors.Is(err, card.ErrExpired):
+		v.add("card_expiry_year", CodeExpired, err)
+	}
+}
+
+// the length depends on the brand, so it isn't checked
+// if the card number is invalid, the number is reported instead
+func (v *validation) cardSecurityCode(field string, brand card.Brand, code string) {
+	if !v.required(field, code) || brand == "" {
+		return
+	}
+	if err := card.ValidateSecurityCode(brand, code); err != nil {
+		v.add(field, CodeInvalid, err)
+	}
+}
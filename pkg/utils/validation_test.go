@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// field and code of every invalid field
+func fieldCodes(t *testing.T, err error) map[string]string {
+	if err == nil {
+		return map[string]string{}
+	}
+	fieldErrs, ok := err.(ValidationErrors)
+	require.True(t, ok, err)
+	codes := map[string]string{}
+	for _, fieldErr := range fieldErrs {
+		codes[fieldErr.Field] = fieldErr.Code
+	}
+	return codes
+}
+
+func Test_ValidatePaymentRequest(t *testing.T) {
+	t.Parallel()
+
+	valid := func() *types.PaymentRequest {
+		return &types.PaymentRequest{
+			AccountId:        uuid.New(),
+			Amount:           100,
+			Currency:         "RUB",
+			CardNumber:       "4242424242424242",
+			CardExpiryMonth:  "12",
+			CardExpiryYear:   time.Now().AddDate(2, 0, 0).Format("06"),
+			CardSecurityCode: "924",
+		}
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(req *types.PaymentRequest)
+		codes map[string]string
+	}{
+		{"Valid", func(req *types.PaymentRequest) {}, map[string]string{}},
+		{"Empty", func(req *types.PaymentRequest) { *req = types.PaymentRequest{} }, map[string]string{
+			"id":                 CodeRequired,
+			"amount":             CodeRequired,
+			"currency":           CodeRequired,
+			"card_number":        CodeRequired,
+			"card_expiry_month":  CodeRequired,
+			"card_expiry_year":   CodeRequired,
+			"card_security_code": CodeRequired,
+		}},
+		{"Unknown currency", func(req *types.PaymentRequest) { req.Currency = "XXX" }, map[string]string{"currency": CodeInvalid}},
+		{"Check digit", func(req *types.PaymentRequest) { req.CardNumber = "4242424242424241" }, map[string]string{"card_number": CodeInvalid}},
+		{"Unknown brand", func(req *types.PaymentRequest) { req.CardNumber = "9000000000000001" }, map[string]string{"card_number": CodeUnsupported}},
+		{"Month", func(req *types.PaymentRequest) { req.CardExpiryMonth = "13" }, map[string]string{"card_expiry_month": CodeInvalid}},
+		{"Year", func(req *types.PaymentRequest) { req.CardExpiryYear = "2030" }, map[string]string{"card_expiry_year": CodeInvalid}},
+		{"Expired", func(req *types.PaymentRequest) {
+			req.CardExpiryMonth, req.CardExpiryYear = "01", "20"
+		}, map[string]string{"card_expiry_year": CodeExpired}},
+		{"Security code of the brand", func(req *types.PaymentRequest) { req.CardSecurityCode = "9241" }, map[string]string{"card_security_code": CodeInvalid}},
+		{"Amex security code", func(req *types.PaymentRequest) {
+			req.CardNumber, req.CardSecurityCode = "378282246310005", "9241"
+		}, map[string]string{}},
+		// the number is reported, the security code length isn't known
+		{"Security code of an invalid number", func(req *types.PaymentRequest) {
+			req.CardNumber, req.CardSecurityCode = "4242424242424241", "9241"
+		}, map[string]string{"card_number": CodeInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.edit(req)
+			require.Equal(t, tt.codes, fieldCodes(t, ValidatePaymentRequest(req)))
+		})
+	}
+}
+
+func Test_ValidateCreateRequest(t *testing.T) {
+	t.Parallel()
+
+	valid := func() *types.RequestCreate {
+		return &types.RequestCreate{
+			FirstName:        "Pasha",
+			LastName:         "Volkov",
+			CardNumber:       "5555555555554444",
+			CardExpiryMonth:  "12",
+			CardExpiryYear:   time.Now().AddDate(2, 0, 0).Format("06"),
+			CardSecurityCode: "924",
+		}
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(req *types.RequestCreate)
+		codes map[string]string
+	}{
+		{"Valid", func(req *types.RequestCreate) {}, map[string]string{}},
+		{"Long names", func(req *types.RequestCreate) {
+			req.FirstName, req.LastName = strings.Repeat("a", maxNameLength+1), strings.Repeat("a", maxNameLength+1)
+		}, map[string]string{"first_name": CodeTooLong, "last_name": CodeTooLong}},
+		{"Short password", func(req *types.RequestCreate) { req.Password = "short" }, map[string]string{"password": CodeInvalid}},
+		{"Sign-up role", func(req *types.RequestCreate) { req.Role = types.RoleMerchant }, map[string]string{}},
+		{"Admin role", func(req *types.RequestCreate) { req.Role = types.RoleAdmin }, map[string]string{"role": CodeInvalid}},
+		{"Currency", func(req *types.RequestCreate) { req.Currency = "rub" }, map[string]string{"currency": CodeInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.edit(req)
+			require.Equal(t, tt.codes, fieldCodes(t, ValidateCreateRequest(req)))
+		})
+	}
+}
+
+func Test_ValidateUpdateRequest(t *testing.T) {
+	t.Parallel()
+
+	// expiry date is optional, but updated as a whole
+	err := ValidateUpdateRequest(&types.RequestUpdate{CardNumber: "4242424242424242"})
+	require.NoError(t, err)
+	err = ValidateUpdateRequest(&types.RequestUpdate{CardNumber: "4242424242424242", CardExpiryMonth: "12"})
+	require.Equal(t, map[string]string{"card_expiry_year": CodeRequired}, fieldCodes(t, err))
+}
+
+func Test_ValidateDepositRequest(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateDepositRequest(&types.RequestDeposit{CardNumber: "4242424242424242", Balance: 100}))
+	err := ValidateDepositRequest(&types.RequestDeposit{CardNumber: "4242424242424242", Currency: "XXX"})
+	require.Equal(t, map[string]string{"balance": CodeRequired, "currency": CodeInvalid}, fieldCodes(t, err))
+}
+
+func Test_ValidateAPIKeyRequest(t *testing.T) {
+	t.Parallel()
+
+	req := &types.RequestAPIKey{}
+	require.NoError(t, ValidateAPIKeyRequest(req))
+	require.Equal(t, types.APIKeySecret, req.Type)
+	require.Equal(t, types.APIKeyModeLive, req.Mode)
+
+	err := ValidateAPIKeyRequest(&types.RequestAPIKey{Type: "restricted"})
+	require.Equal(t, map[string]string{"type": CodeInvalid}, fieldCodes(t, err))
+	err = ValidateAPIKeyRequest(&types.RequestAPIKey{Mode: "sandbox"})
+	require.Equal(t, map[string]string{"mode": CodeInvalid}, fieldCodes(t, err))
+	err = ValidateAPIKeyRequest(&types.RequestAPIKey{Mode: "test"})
+	require.Equal(t, map[string]string{"mode": CodeInvalid}, fieldCodes(t, err))
+}
+
+func Test_ValidateRoleRequest(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateRoleRequest(&types.RequestRole{Role: types.RoleSupport}))
+	err := ValidateRoleRequest(&types.RequestRole{Role: "root"})
+	require.Equal(t, map[string]string{"role": CodeInvalid}, fieldCodes(t, err))
+}
+
+func Test_ValidationErrors(t *testing.T) {
+	t.Parallel()
+
+	err := ValidationErrors{
+		{Field: "card_number", Code: CodeInvalid, Message: "invalid card number"},
+		{Field: "card_security_code", Code: CodeRequired, Message: "is required"},
+	}
+	require.Equal(t, "card_number: invalid card number; card_security_code: is required", err.Error())
+}
@@ -4,33 +4,121 @@ import (
 	"errors"
 
 	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
 )
 
+var (
+	errCurrency = errors.New("invalid currency")
+	errRole     = errors.New("invalid role")
+	errKeyType  = errors.New("invalid api key type")
+	errKeyMode  = errors.New("invalid api key mode")
+)
+
+// Longest first and last name
+const maxNameLength = 29
+
 func ValidateCreateRequest(req *types.RequestCreate) error {
-	if len(req.CardNumber) != 16 || len(req.CardExpiryMonth) != 2 || len(req.CardExpiryYear) != 2 || len(req.CardSecurityCode) != 3 {
-		return errors.New("invalid parameters")
+	v := &validation{}
+	v.maxLength("first_name", req.FirstName, maxNameLength)
+	v.maxLength("last_name", req.LastName, maxNameLength)
+	brand := v.cardNumber("card_number", req.CardNumber)
+	v.cardExpiry(req.CardExpiryMonth, req.CardExpiryYear)
+	v.cardSecurityCode("card_security_code", brand, req.CardSecurityCode)
+	if req.Password != "" {
+		if err := ValidatePassword(req.Password); err != nil {
+			v.add("password", CodeInvalid, err)
+		}
 	}
-	return nil
+	if req.Role != "" && !signUpRole(req.Role) {
+		v.add("role", CodeInvalid, errRole)
+	}
+	if req.Currency != "" {
+		v.currency("currency", req.Currency)
+	}
+	return v.err()
 }
 
 func ValidatePaymentRequest(req *types.PaymentRequest) error {
-	if len(req.CardNumber) != 16 || len(req.CardExpiryMonth) != 2 || len(req.CardExpiryYear) != 2 || len(req.CardSecurityCode) != 3 {
-		return errors.New("invalid parameters")
+	v := &validation{}
+	if req.AccountId == uuid.Nil {
+		v.add("id", CodeRequired, errRequired)
 	}
-	return nil
+	v.positive("amount", req.Amount)
+	v.currency("currency", req.Currency)
+	brand := v.cardNumber("card_number", req.CardNumber)
+	v.cardExpiry(req.CardExpiryMonth, req.CardExpiryYear)
+	v.cardSecurityCode("card_security_code", brand, req.CardSecurityCode)
+	return v.err()
+}
+
+// Capture, refund, cancel and increment
+func ValidatePaidRequest(req *types.PaidRequest) error {
+	v := &validation{}
+	v.positive("amount", req.Amount)
+	return v.err()
 }
 
 func ValidateUpdateRequest(req *types.RequestUpdate) error {
-	if len(req.FirstName) >= 30 || len(req.LastName) >= 30 || len(req.CardNumber) != 16 {
-		return errors.New("invalid parameters")
+	v := &validation{}
+	v.maxLength("first_name", req.FirstName, maxNameLength)
+	v.maxLength("last_name", req.LastName, maxNameLength)
+	v.cardNumber("card_number", req.CardNumber)
+	// expiry date is updated as a whole
+	if req.CardExpiryMonth != "" || req.CardExpiryYear != "" {
+		v.cardExpiry(req.CardExpiryMonth, req.CardExpiryYear)
 	}
-	return nil
+	return v.err()
 }
 
 func ValidateDepositRequest(req *types.RequestDeposit) error {
-	if len(req.CardNumber) != 16 {
-		return errors.New("invalid parameters")
+	v := &validation{}
+	v.cardNumber("card_number", req.CardNumber)
+	v.positive("balance", req.Balance)
+	if req.Currency != "" {
+		v.currency("currency", req.Currency)
+	}
+	return v.err()
+}
+
+// ISO 4217 alphabetic code
+func ValidateCurrency(code string) error {
+	if _, ok := types.LookupCurrency(code); !ok {
+		return errCurrency
 	}
 	return nil
 }
 
+
+// Fills in the default type and mode of the key
+func ValidateAPIKeyRequest(req *types.RequestAPIKey) error {
+	if req.Type == "" {
+		req.Type = types.APIKeySecret
+	}
+	if req.Mode == "" {
+		req.Mode = types.APIKeyModeLive
+	}
+	v := &validation{}
+	if _, ok := apiKeyPrefixes[req.Type]; !ok {
+		v.add("type", CodeInvalid, errKeyType)
+	} else if _, ok := apiKeyPrefixes[req.Type][req.Mode]; !ok {
+		v.add("mode", CodeInvalid, errKeyMode)
+	}
+	return v.err()
+}
+
+func ValidateRoleRequest(req *types.RequestRole) error {
+	v := &validation{}
+	if !types.ValidRole(req.Role) {
+		v.add("role", CodeInvalid, errRole)
+	}
+	return v.err()
+}
+
+func signUpRole(role string) bool {
+	for _, signUpRole := range types.SignUpRoles {
+		if role == signUpRole {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+)
+
+// Records re-encrypted per query
+const reencryptBatch = 100
+
+// Table with encrypted columns, records are read in the order of the key
+type encryptedTable struct {
+	name    string
+	key     string
+	columns []string
+}
+
+var encryptedTables = []encryptedTable{
+	{name: "account", key: "id", columns: []string{"first_name", "last_name", "card_expiry_month", "card_expiry_year"}},
+	{name: "payment", key: "id", columns: []string{"card_expiry_month", "card_expiry_year"}},
+	{name: "api_key", key: "id", columns: []string{"signing_secret"}},
+	{name: "totp", key: "account_id", columns: []string{"secret"}},
+}
+
+// Encrypted columns of the account
+func accountColumns(acc *types.Account) map[string]*string {
+	return map[string]*string{
+		"first_name":        &acc.FirstName,
+		"last_name":         &acc.LastName,
+		"card_expiry_month": &acc.CardExpiryMonth,
+		"card_expiry_year":  &acc.CardExpiryYear,
+	}
+}
+
+// Encrypted columns of the payment
+func paymentColumns(pay *types.Payment) map[string]*string {
+	return map[string]*string{
+		"card_expiry_month": &pay.CardExpiryMonth,
+		"card_expiry_year":  &pay.CardExpiryYear,
+	}
+}
+
+// Encrypted columns of the API key
+func apiKeyColumns(key *types.APIKey) map[string]*string {
+	return map[string]*string{
+		"signing_secret": &key.SigningSecret,
+	}
+}
+
+// Encrypted columns of the TOTP enrollment
+func totpColumns(totp *types.TOTP) map[string]*string {
+	return map[string]*string{
+		"secret": &totp.Secret,
+	}
+}
+
+// Re-encrypts records which aren't encrypted with the current master key,
+// or aren't encrypted at all, with new data keys. Records are updated one by one
+// and only if they weren't changed meanwhile, so it runs next to the service.
+// Returns the number of re-encrypted records
+func (s *PostgresStorage) Reencrypt(ctx context.Context) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.Reencrypt")
+	defer span.Finish()
+
+	reencrypted := 0
+	for _, table := range encryptedTables {
+		count, err := s.reencryptTable(ctx, table)
+		reencrypted += count
+		if err != nil {
+			return reencrypted, err
+		}
+	}
+	return reencrypted, nil
+}
+
+func (s *PostgresStorage) reencryptTable(ctx context.Context, table encryptedTable) (int, error) {
+	count := len(table.columns)
+	selectQuery := fmt.Sprintf(`SELECT %[1]s, %[2]s FROM %[3]s
+				WHERE %[1]s > $1
+				ORDER BY %[1]s
+				LIMIT $2`, table.key, strings.Join(table.columns, ", "), table.name)
+	// new values, id and the values read
+	set, where := []string{}, []string{}
+	for i, column := range table.columns {
+		set = append(set, fmt.Sprintf("%s = $%d", column, i+1))
+		// nullable columns, NULL doesn't equal NULL
+		where = append(where, fmt.Sprintf("%s IS NOT DISTINCT FROM $%d", column, count+i+2))
+	}
+	updateQuery := fmt.Sprintf(`UPDATE %s
+				SET %s
+				WHERE %s = $%d AND %s`, table.name, strings.Join(set, ", "), table.key, count+1, strings.Join(where, " AND "))
+
+	reencrypted := 0
+	last := uuid.Nil
+	for {
+		rows, err := s.db.QueryContext(ctx, selectQuery, last, reencryptBatch)
+		if err != nil {
+			return reencrypted, err
+		}
+		ids, records := []uuid.UUID{}, [][]sql.NullString{}
+		for rows.Next() {
+			var id uuid.UUID
+			values := make([]sql.NullString, count)
+			dest := []any{&id}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return reencrypted, err
+			}
+			ids, records = append(ids, id), append(records, values)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return reencrypted, err
+		}
+		if len(ids) == 0 {
+			return reencrypted, nil
+		}
+		for i, id := range ids {
+			updated, err := s.reencryptRecord(ctx, table, updateQuery, id, records[i])
+			if err != nil {
+				return reencrypted, err
+			}
+			if updated {
+				reencrypted++
+			}
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
+func (s *PostgresStorage) reencryptRecord(ctx context.Context, table encryptedTable, query string, id uuid.UUID, values []sql.NullString) (bool, error) {
+	stale := false
+	for _, value := range values {
+		stale = stale || value.Valid && s.cipher.Stale(value.String)
+	}
+	if !stale {
+		return false, nil
+	}
+	// NULL stays NULL
+	sealed := append([]sql.NullString{}, values...)
+	columns := map[string]*string{}
+	for i, column := range table.columns {
+		if sealed[i].Valid {
+			columns[column] = &sealed[i].String
+		}
+	}
+	if err := s.cipher.Open(ctx, table.name, columns); err != nil {
+		return false, err
+	}
+	if err := s.cipher.Seal(ctx, table.name, columns); err != nil {
+		return false, err
+	}
+
+	args := []any{}
+	for _, value := range sealed {
+		args = append(args, value)
+	}
+	args = append(args, id)
+	for _, value := range values {
+		args = append(args, value)
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	// changed by the service meanwhile, with the current key
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
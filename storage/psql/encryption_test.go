@@ -0,0 +1,286 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Edbeer/paymentapi/pkg/envelope"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKeyV1 = bytes.Repeat([]byte{0x0f}, 32)
+	testKeyV2 = bytes.Repeat([]byte{0xf0}, 32)
+)
+
+// master key 1
+func testKeys(t *testing.T) envelope.KeyProvider {
+	keys, err := envelope.NewLocalKeyProvider(map[int][]byte{1: testKeyV1}, 1)
+	require.NoError(t, err)
+	return keys
+}
+
+// master keys 1 and 2, data keys are wrapped with 2
+func rotatedKeys(t *testing.T) envelope.KeyProvider {
+	keys, err := envelope.NewLocalKeyProvider(map[int][]byte{1: testKeyV1, 2: testKeyV2}, 2)
+	require.NoError(t, err)
+	return keys
+}
+
+// value of the column encrypted with the current master key
+type sealedArg struct {
+	keys   envelope.KeyProvider
+	table  string
+	column string
+	value  string
+}
+
+func (a sealedArg) Match(v driver.Value) bool {
+	sealed, ok := v.(string)
+	if !ok || sealed == a.value || a.keys == nil ||
+		!strings.HasPrefix(sealed, envelope.Prefix) || envelope.NewCipher(a.keys).Stale(sealed) {
+		return false
+	}
+	err := envelope.NewCipher(a.keys).Open(context.Background(), a.table, map[string]*string{a.column: &sealed})
+	return err == nil && sealed == a.value
+}
+
+func Test_Encryption(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := rotatedKeys(t)
+	psql := NewPostgresStorage(db, keys)
+	cipher := envelope.NewCipher(keys)
+
+	colums := []string{
+		"id",
+		"first_name",
+		"last_name",
+		"card_token",
+		"card_expiry_month",
+		"card_expiry_year",
+		"statement",
+		"created_at",
+		"currency",
+		"authorization_ttl",
+		"role",
+		"card_number",
+	}
+	account := types.NewAccount(&types.RequestCreate{
+		FirstName:       "Pasha",
+		LastName:        "volkov",
+		CardExpiryMonth: "12",
+		CardExpiryYear:  "24",
+	}, &types.Card{Token: "tok_1"})
+	query := regexp.QuoteMeta(`SELECT * FROM account WHERE id = $1`)
+
+	t.Run("Decrypt", func(t *testing.T) {
+		// first name encrypted with the old master key
+		firstName := "Pasha"
+		oldCipher := envelope.NewCipher(testKeys(t))
+		require.NoError(t, oldCipher.Seal(context.Background(), "account", map[string]*string{"first_name": &firstName}))
+		sealed := *account
+		require.NoError(t, cipher.Seal(context.Background(), "account", map[string]*string{
+			"last_name":         &sealed.LastName,
+			"card_expiry_month": &sealed.CardExpiryMonth,
+			"card_expiry_year":  &sealed.CardExpiryYear,
+		}))
+		require.True(t, strings.HasPrefix(sealed.LastName, envelope.Prefix+"2:"))
+
+		rows := sqlmock.NewRows(colums).AddRow(
+			account.ID,
+			firstName,
+			sealed.LastName,
+			"tok_1",
+			sealed.CardExpiryMonth,
+			sealed.CardExpiryYear,
+			pq.Array(account.Statement),
+			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
+		)
+		mock.ExpectQuery(query).WithArgs(account.ID).WillReturnRows(rows)
+		acc, err := psql.GetAccountByID(context.Background(), account.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Pasha", acc.FirstName)
+		require.Equal(t, "volkov", acc.LastName)
+		require.Equal(t, "12", acc.CardExpiryMonth)
+		require.Equal(t, "24", acc.CardExpiryYear)
+	})
+
+	t.Run("Moved to another column", func(t *testing.T) {
+		sealed := *account
+		require.NoError(t, cipher.Seal(context.Background(), "account", accountColumns(&sealed)))
+
+		rows := sqlmock.NewRows(colums).AddRow(
+			account.ID,
+			sealed.LastName,
+			sealed.FirstName,
+			"tok_1",
+			sealed.CardExpiryMonth,
+			sealed.CardExpiryYear,
+			pq.Array(account.Statement),
+			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
+		)
+		mock.ExpectQuery(query).WithArgs(account.ID).WillReturnRows(rows)
+		_, err := psql.GetAccountByID(context.Background(), account.ID)
+		require.Error(t, err)
+	})
+
+	t.Run("Unknown master key", func(t *testing.T) {
+		sealed := *account
+		require.NoError(t, cipher.Seal(context.Background(), "account", accountColumns(&sealed)))
+
+		rows := sqlmock.NewRows(colums).AddRow(
+			account.ID,
+			sealed.FirstName,
+			sealed.LastName,
+			"tok_1",
+			sealed.CardExpiryMonth,
+			sealed.CardExpiryYear,
+			pq.Array(account.Statement),
+			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
+		)
+		mock.ExpectQuery(query).WithArgs(account.ID).WillReturnRows(rows)
+		// master key 2 was removed
+		_, err := NewPostgresStorage(db, testKeys(t)).GetAccountByID(context.Background(), account.ID)
+		require.Error(t, err)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_Reencrypt(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := rotatedKeys(t)
+	psql := NewPostgresStorage(db, keys)
+
+	// payment encrypted with the old master key
+	oldMonth, oldYear := "12", "24"
+	require.NoError(t, envelope.NewCipher(testKeys(t)).Seal(context.Background(), "payment", map[string]*string{
+		"card_expiry_month": &oldMonth,
+		"card_expiry_year":  &oldYear,
+	}))
+	// account encrypted with the current master key
+	current := "Pasha"
+	require.NoError(t, envelope.NewCipher(keys).Seal(context.Background(), "account", map[string]*string{"first_name": &current}))
+
+	legacyID, currentID, paymentID, apiKeyID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	selectAccount := regexp.QuoteMeta(`SELECT id, first_name, last_name, card_expiry_month, card_expiry_year FROM account
+				WHERE id > $1
+				ORDER BY id
+				LIMIT $2`)
+	selectPayment := regexp.QuoteMeta(`SELECT id, card_expiry_month, card_expiry_year FROM payment
+				WHERE id > $1
+				ORDER BY id
+				LIMIT $2`)
+	accountColumns := []string{"id", "first_name", "last_name", "card_expiry_month", "card_expiry_year"}
+
+	mock.ExpectQuery(selectAccount).WithArgs(uuid.Nil, reencryptBatch).WillReturnRows(
+		sqlmock.NewRows(accountColumns).
+			AddRow(legacyID, "Pasha", nil, "", "").
+			AddRow(currentID, current, "", "", ""),
+	)
+	// stored before encryption, empty values stay empty, NULL stays NULL
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE account
+				SET first_name = $1, last_name = $2, card_expiry_month = $3, card_expiry_year = $4
+				WHERE id = $5 AND first_name IS NOT DISTINCT FROM $6 AND last_name IS NOT DISTINCT FROM $7 AND card_expiry_month IS NOT DISTINCT FROM $8 AND card_expiry_year IS NOT DISTINCT FROM $9`)).
+		WithArgs(
+			sealedArg{keys: keys, table: "account", column: "first_name", value: "Pasha"},
+			nil,
+			"",
+			"",
+			legacyID,
+			"Pasha",
+			nil,
+			"",
+			"",
+		).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectAccount).WithArgs(currentID, reencryptBatch).WillReturnRows(sqlmock.NewRows(accountColumns))
+
+	mock.ExpectQuery(selectPayment).WithArgs(uuid.Nil, reencryptBatch).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "card_expiry_month", "card_expiry_year"}).
+			AddRow(paymentID, oldMonth, oldYear),
+	)
+	// changed meanwhile
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE payment
+				SET card_expiry_month = $1, card_expiry_year = $2
+				WHERE id = $3 AND card_expiry_month IS NOT DISTINCT FROM $4 AND card_expiry_year IS NOT DISTINCT FROM $5`)).
+		WithArgs(
+			sealedArg{keys: keys, table: "payment", column: "card_expiry_month", value: "12"},
+			sealedArg{keys: keys, table: "payment", column: "card_expiry_year", value: "24"},
+			paymentID,
+			oldMonth,
+			oldYear,
+		).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectPayment).WithArgs(paymentID, reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "card_expiry_month", "card_expiry_year"}))
+
+	// signing secret stored before encryption
+	selectAPIKey := regexp.QuoteMeta(`SELECT id, signing_secret FROM api_key
+				WHERE id > $1
+				ORDER BY id
+				LIMIT $2`)
+	mock.ExpectQuery(selectAPIKey).WithArgs(uuid.Nil, reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "signing_secret"}).AddRow(apiKeyID, "secret"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE api_key
+				SET signing_secret = $1
+				WHERE id = $2 AND signing_secret IS NOT DISTINCT FROM $3`)).
+		WithArgs(
+			sealedArg{keys: keys, table: "api_key", column: "signing_secret", value: "secret"},
+			apiKeyID,
+			"secret",
+		).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectAPIKey).WithArgs(apiKeyID, reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "signing_secret"}))
+
+	// TOTP records are read in the order of the account
+	selectTOTP := regexp.QuoteMeta(`SELECT account_id, secret FROM totp
+				WHERE account_id > $1
+				ORDER BY account_id
+				LIMIT $2`)
+	mock.ExpectQuery(selectTOTP).WithArgs(uuid.Nil, reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "secret"}).AddRow(legacyID, "SECRET"))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE totp
+				SET secret = $1
+				WHERE account_id = $2 AND secret IS NOT DISTINCT FROM $3`)).
+		WithArgs(
+			sealedArg{keys: keys, table: "totp", column: "secret", value: "SECRET"},
+			legacyID,
+			"SECRET",
+		).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectTOTP).WithArgs(legacyID, reencryptBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"account_id", "secret"}))
+
+	reencrypted, err := psql.Reencrypt(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 3, reencrypted)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
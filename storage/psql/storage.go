@@ -3,7 +3,11 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"sort"
+	"time"
 
+	"github.com/Edbeer/paymentapi/pkg/envelope"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
@@ -12,48 +16,67 @@ import (
 )
 
 type PostgresStorage struct {
-	db *sql.DB
+	db     *sql.DB
+	cipher *envelope.Cipher
 }
 
-func NewPostgresStorage(db *sql.DB) *PostgresStorage {
+// Constructor, personal and card data of accounts
+// and payments is encrypted with data keys of the provider
+func NewPostgresStorage(db *sql.DB, keys envelope.KeyProvider) *PostgresStorage {
 	return &PostgresStorage{
-		db: db,
+		db:     db,
+		cipher: envelope.NewCipher(keys),
 	}
 }
 
-func (s *PostgresStorage) CreateAccount(ctx context.Context, reqAcc *types.RequestCreate) (*types.Account, error) {
+func (s *PostgresStorage) CreateAccount(ctx context.Context, reqAcc *types.RequestCreate, card *types.Card) (*types.Account, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.CreateAccount")
 	defer span.Finish()
 
-	query := `INSERT INTO account (first_name, 
-		last_name, card_number, card_expiry_month, 
-		card_expiry_year, card_security_code, 
-		balance, blocked_money, statement, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now())
-			RETURNING *`
-	req := types.NewAccount(reqAcc)
+	// account and its balance in the account currency
+	query := `WITH acc AS (
+		INSERT INTO account (first_name, 
+		last_name, card_token, card_expiry_month, 
+		card_expiry_year, statement, 
+		created_at, currency, role, card_number)
+			VALUES ($1, $2, $3, $4, $5, $6, now(), $7, $8, $9)
+			RETURNING *
+		), balance AS (
+		INSERT INTO account_balance (account_id, currency)
+			SELECT id, currency FROM acc
+		)
+		SELECT * FROM acc`
+	req := types.NewAccount(reqAcc, card)
+	if err := s.cipher.Seal(ctx, "account", accountColumns(req)); err != nil {
+		return nil, err
+	}
 	acc := &types.Account{}
 	if err := s.db.QueryRowContext(
 		ctx, query,
 		req.FirstName,
 		req.LastName,
-		req.CardNumber,
+		req.CardToken,
 		req.CardExpiryMonth,
 		req.CardExpiryYear,
-		req.CardSecurityCode,
-		req.Balance,
-		req.BlockedMoney,
 		pq.Array(req.Statement),
+		req.Currency,
+		req.Role,
+		req.CardNumber,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+		return nil, err
+	}
+	acc.Balances = []*types.Balance{{AccountID: acc.ID, Currency: acc.Currency}}
 
 	return acc, nil
 }
@@ -74,14 +97,18 @@ func (s *PostgresStorage) GetAccount(ctx context.Context) ([]*types.Account, err
 		acc := &types.Account{}
 		if err := rows.Scan(
 			&acc.ID, &acc.FirstName,
-			&acc.LastName, &acc.CardNumber,
+			&acc.LastName, &acc.CardToken,
 			&acc.CardExpiryMonth, &acc.CardExpiryYear,
-			&acc.CardSecurityCode, &acc.Balance,
-			&acc.BlockedMoney, pq.Array(&acc.Statement),
-			&acc.CreatedAt,
+			pq.Array(&acc.Statement),
+			&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 		); err != nil {
 			return nil, err
 		}
+		if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+			return nil, err
+		}
 		accounts = append(accounts, acc)
 	}
 	return accounts, nil
@@ -99,72 +126,101 @@ func (s *PostgresStorage) GetAccountByID(ctx context.Context, id uuid.UUID) (*ty
 		ctx, query, id,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+		return nil, err
+	}
 	return acc, nil
 }
 
-func (s *PostgresStorage) GetAccountByCard(ctx context.Context, card string) (*types.Account, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetAccountByCard")
+func (s *PostgresStorage) UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, card *types.Card, id uuid.UUID) (*types.Account, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UpdateAccount")
 	defer span.Finish()
 	
-	query := `SELECT * FROM account WHERE card_number = $1`
+	query := `UPDATE account
+	SET first_name = COALESCE(NULLIF($1, ''), first_name),
+		last_name = COALESCE(NULLIF($2, ''), last_name),
+		card_token = COALESCE(NULLIF($3, ''), card_token),
+		card_expiry_month = COALESCE(NULLIF($4, ''), card_expiry_month),
+		card_expiry_year = COALESCE(NULLIF($5, ''), card_expiry_year),
+		authorization_ttl = COALESCE($6, authorization_ttl),
+		card_number = COALESCE(NULLIF($7, ''), card_number)
+	WHERE id = $8
+	RETURNING *`
+	// unchanged columns stay encrypted with their data key
+	sealed := *reqUp
+	if err := s.cipher.Seal(ctx, "account", map[string]*string{
+		"first_name":        &sealed.FirstName,
+		"last_name":         &sealed.LastName,
+		"card_expiry_month": &sealed.CardExpiryMonth,
+		"card_expiry_year":  &sealed.CardExpiryYear,
+	}); err != nil {
+		return nil, err
+	}
 	acc := &types.Account{}
+
 	if err := s.db.QueryRowContext(
-		ctx, query, card,
+		ctx, query,
+		sealed.FirstName,
+		sealed.LastName,
+		card.Token,
+		sealed.CardExpiryMonth,
+		sealed.CardExpiryYear,
+		reqUp.AuthorizationTTL,
+		card.CardNumber,
+		id,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+		return nil, err
+	}
 	return acc, nil
 }
 
-func (s *PostgresStorage) UpdateAccount(ctx context.Context, reqUp *types.RequestUpdate, id uuid.UUID) (*types.Account, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UpdateAccount")
+// Change account role
+func (s *PostgresStorage) UpdateRole(ctx context.Context, id uuid.UUID, role string) (*types.Account, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UpdateRole")
 	defer span.Finish()
-	
+
 	query := `UPDATE account
-	SET first_name = COALESCE(NULLIF($1, ''), first_name),
-		last_name = COALESCE(NULLIF($2, ''), last_name),
-		card_number = COALESCE(NULLIF($3, ''), card_number),
-		card_expiry_month = COALESCE(NULLIF($4, ''), card_expiry_month),
-		card_expiry_year = COALESCE(NULLIF($5, ''), card_expiry_year),
-		card_security_code = COALESCE(NULLIF($6, ''), card_security_code)
-	WHERE id = $7
+	SET role = $1
+	WHERE id = $2
 	RETURNING *`
 	acc := &types.Account{}
 
 	if err := s.db.QueryRowContext(
-		ctx, query,
-		reqUp.FirstName,
-		reqUp.LastName,
-		reqUp.CardNumber,
-		reqUp.CardExpiryMonth,
-		reqUp.CardExpiryYear,
-		reqUp.CardSecurityCode,
-		id,
+		ctx, query, role, id,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+		return nil, err
+	}
 	return acc, nil
 }
 
@@ -184,14 +240,18 @@ func (s *PostgresStorage) UpdateStatement(ctx context.Context, tx *sql.Tx, id, p
 		id,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "account", accountColumns(acc)); err != nil {
+		return nil, err
+	}
 	return acc, nil
 }
 
@@ -207,32 +267,88 @@ func (s *PostgresStorage) DeleteAccount(ctx context.Context, id uuid.UUID) error
 	return nil
 }
 
-func (s *PostgresStorage) DepositAccount(ctx context.Context, reqDep *types.RequestDeposit) (*types.Account, error) {
+// Top up balance of the account in the deposit currency,
+// account currency by default
+func (s *PostgresStorage) DepositAccount(ctx context.Context, tx *sql.Tx, reqDep *types.RequestDeposit, id uuid.UUID) (*types.Balance, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.DepositAccount")
 	defer span.Finish()
 	
-	query := `UPDATE account
-				SET balance = COALESCE(NULLIF($1, 0), balance)
-				WHERE card_number = $2
-				RETURNING *`
-	acc := &types.Account{}
+	query := `UPDATE account_balance AS b
+				SET balance = b.balance + $1
+				FROM account AS a
+				WHERE a.id = b.account_id
+					AND a.id = $2
+					AND b.currency = COALESCE(NULLIF($3, ''), a.currency)
+				RETURNING b.*`
+	balance := &types.Balance{}
 
-	if err := s.db.QueryRowContext(
+	if err := tx.QueryRowContext(
 		ctx,
 		query,
 		reqDep.Balance,
-		reqDep.CardNumber,
+		id,
+		reqDep.Currency,
 	).Scan(
-		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
-		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		&balance.AccountID, &balance.Currency,
+		&balance.Balance, &balance.BlockedMoney,
 	); err != nil {
 		return nil, err
 	}
-	return acc, nil
+	return balance, nil
+}
+
+// Account balances in all currencies
+func (s *PostgresStorage) GetBalances(ctx context.Context, id uuid.UUID) ([]*types.Balance, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetBalances")
+	defer span.Finish()
+
+	query := `SELECT * FROM account_balance
+				WHERE account_id = $1
+				ORDER BY currency`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []*types.Balance{}
+	for rows.Next() {
+		balance := &types.Balance{}
+		if err := rows.Scan(
+			&balance.AccountID, &balance.Currency,
+			&balance.Balance, &balance.BlockedMoney,
+		); err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return balances, nil
+}
+
+// Open account balance in the currency,
+// existing balance is returned as is
+func (s *PostgresStorage) OpenBalance(ctx context.Context, id uuid.UUID, currency string) (*types.Balance, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.OpenBalance")
+	defer span.Finish()
+
+	query := `INSERT INTO account_balance (account_id, currency)
+				VALUES ($1, $2)
+				ON CONFLICT (account_id, currency)
+				DO UPDATE SET currency = EXCLUDED.currency
+				RETURNING *`
+	balance := &types.Balance{}
+	if err := s.db.QueryRowContext(
+		ctx, query, id, currency,
+	).Scan(
+		&balance.AccountID, &balance.Currency,
+		&balance.Balance, &balance.BlockedMoney,
+	); err != nil {
+		return nil, err
+	}
+	return balance, nil
 }
 
 func (s *PostgresStorage) GetAccountStatement(ctx context.Context, id uuid.UUID) ([]string, error) {
@@ -248,11 +364,12 @@ func (s *PostgresStorage) GetAccountStatement(ctx context.Context, id uuid.UUID)
 		id,
 	).Scan(
 		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
+		&acc.LastName, &acc.CardToken,
 		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		pq.Array(&acc.Statement),
+		&acc.CreatedAt, &acc.Currency,
+		&acc.AuthorizationTTL, &acc.Role,
+		&acc.CardNumber,
 	); err != nil {
 		return nil, err
 	}
@@ -266,10 +383,18 @@ func (s *PostgresStorage) SavePayment(ctx context.Context, tx *sql.Tx, payment *
 	
 	query := `INSERT INTO payment (id, business_id, 
 		order_id, operation, amount, status, 
-		currency, card_number, card_expiry_month,
-		 card_expiry_year, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		currency, card_token, card_expiry_month,
+		 card_expiry_year, created_at, settlement_amount,
+		 settlement_currency, fx_rate, fx_rate_at, reason,
+		 captured_amount, released_amount, settled_amount, reference_id,
+		 refunded_amount, expires_at, card_brand, account_id, card_number)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+				$11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
 			RETURNING *`
+	sealed := *payment
+	if err := s.cipher.Seal(ctx, "payment", paymentColumns(&sealed)); err != nil {
+		return nil, err
+	}
 	pay := &types.Payment{}
 	if err := tx.QueryRowContext(
 		ctx, query,
@@ -280,20 +405,93 @@ func (s *PostgresStorage) SavePayment(ctx context.Context, tx *sql.Tx, payment *
 		payment.Amount,
 		payment.Status,
 		payment.Currency,
-		payment.CardNumber,
-		payment.CardExpiryMonth,
-		payment.CardExpiryYear,
+		payment.CardToken,
+		sealed.CardExpiryMonth,
+		sealed.CardExpiryYear,
 		payment.CreatedAt,
+		payment.SettlementAmount,
+		payment.SettlementCurrency,
+		payment.FxRate,
+		payment.FxRateAt,
+		payment.Reason,
+		payment.CapturedAmount,
+		payment.ReleasedAmount,
+		payment.SettledAmount,
+		payment.ReferenceId,
+		payment.RefundedAmount,
+		payment.ExpiresAt,
+		payment.CardBrand,
+		payment.AccountId,
+		payment.CardNumber,
 	).Scan(
 		&pay.ID, &pay.BusinessId,
 		&pay.OrderId, &pay.Operation,
 		&pay.Amount, &pay.Status,
-		&pay.Currency, &pay.CardNumber,
+		&pay.Currency, &pay.CardToken,
 		&pay.CardExpiryMonth, &pay.CardExpiryYear,
-		&pay.CreatedAt,
+		&pay.CreatedAt, &pay.SettlementAmount,
+		&pay.SettlementCurrency, &pay.FxRate,
+		&pay.FxRateAt, &pay.Reason,
+		&pay.CapturedAmount, &pay.ReleasedAmount,
+		&pay.SettledAmount, &pay.ReferenceId,
+		&pay.RefundedAmount, &pay.ExpiresAt,
+		&pay.CardBrand, &pay.AccountId,
+		&pay.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+		return nil, err
+	}
+	return pay, nil
+}
+
+// Update status and amounts of the payment
+func (s *PostgresStorage) UpdatePayment(ctx context.Context, tx *sql.Tx, payment *types.Payment) (*types.Payment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UpdatePayment")
+	defer span.Finish()
+
+	query := `UPDATE payment
+				SET amount = $1,
+					status = $2,
+					settlement_amount = $3,
+					captured_amount = $4,
+					released_amount = $5,
+					settled_amount = $6,
+					refunded_amount = $7
+				WHERE id = $8
+				RETURNING *`
+	pay := &types.Payment{}
+	if err := tx.QueryRowContext(
+		ctx, query,
+		payment.Amount,
+		payment.Status,
+		payment.SettlementAmount,
+		payment.CapturedAmount,
+		payment.ReleasedAmount,
+		payment.SettledAmount,
+		payment.RefundedAmount,
+		payment.ID,
+	).Scan(
+		&pay.ID, &pay.BusinessId,
+		&pay.OrderId, &pay.Operation,
+		&pay.Amount, &pay.Status,
+		&pay.Currency, &pay.CardToken,
+		&pay.CardExpiryMonth, &pay.CardExpiryYear,
+		&pay.CreatedAt, &pay.SettlementAmount,
+		&pay.SettlementCurrency, &pay.FxRate,
+		&pay.FxRateAt, &pay.Reason,
+		&pay.CapturedAmount, &pay.ReleasedAmount,
+		&pay.SettledAmount, &pay.ReferenceId,
+		&pay.RefundedAmount, &pay.ExpiresAt,
+		&pay.CardBrand, &pay.AccountId,
+		&pay.CardNumber,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+		return nil, err
+	}
 	return pay, nil
 }
 
@@ -309,39 +507,611 @@ func (s *PostgresStorage) GetPaymentByID(ctx context.Context, id uuid.UUID) (*ty
 		&pay.ID, &pay.BusinessId,
 		&pay.OrderId, &pay.Operation,
 		&pay.Amount, &pay.Status,
-		&pay.Currency, &pay.CardNumber,
+		&pay.Currency, &pay.CardToken,
 		&pay.CardExpiryMonth, &pay.CardExpiryYear,
-		&pay.CreatedAt,
+		&pay.CreatedAt, &pay.SettlementAmount,
+		&pay.SettlementCurrency, &pay.FxRate,
+		&pay.FxRateAt, &pay.Reason,
+		&pay.CapturedAmount, &pay.ReleasedAmount,
+		&pay.SettledAmount, &pay.ReferenceId,
+		&pay.RefundedAmount, &pay.ExpiresAt,
+		&pay.CardBrand, &pay.AccountId,
+		&pay.CardNumber,
 	); err != nil {
 		return nil, err
 	}
+	if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+		return nil, err
+	}
 	return pay, nil
 }
 
-func (s *PostgresStorage) SaveBalance(ctx context.Context, tx *sql.Tx, account *types.Account, balance, bmoney uint64) (*types.Account, error) {
+// Apply balance delta to account balance, balances can't become negative
+func (s *PostgresStorage) SaveBalance(ctx context.Context, tx *sql.Tx, balance *types.Balance, delta types.BalanceDelta) (*types.Balance, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveBalance")
 	defer span.Finish()
 	
-	query := `UPDATE account
-				SET balance = COALESCE(NULLIF($1, 0), balance),
-					blocked_money = COALESCE(NULLIF($2, 0), blocked_money)
-				WHERE id = $3
+	query := `UPDATE account_balance
+				SET balance = balance + $1,
+					blocked_money = blocked_money + $2
+				WHERE account_id = $3 AND currency = $4
 				RETURNING *`
-	acc := &types.Account{}
+	updated := &types.Balance{}
 	if err := tx.QueryRowContext(
 		ctx, query,
-		balance,
-		bmoney,
-		account.ID,
+		delta.Balance,
+		delta.BlockedMoney,
+		balance.AccountID,
+		balance.Currency,
 	).Scan(
-		&acc.ID, &acc.FirstName,
-		&acc.LastName, &acc.CardNumber,
-		&acc.CardExpiryMonth, &acc.CardExpiryYear,
-		&acc.CardSecurityCode, &acc.Balance,
-		&acc.BlockedMoney, pq.Array(&acc.Statement),
-		&acc.CreatedAt,
+		&updated.AccountID, &updated.Currency,
+		&updated.Balance, &updated.BlockedMoney,
 	); err != nil {
 		return nil, err
 	}
-	return acc, nil
+	return updated, nil
+}
+
+func (s *PostgresStorage) SaveJournalEntry(ctx context.Context, tx *sql.Tx, entry *types.JournalEntry) (*types.JournalEntry, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveJournalEntry")
+	defer span.Finish()
+
+	if !entry.Balanced() {
+		return nil, errors.New("unbalanced journal entry")
+	}
+	query := `INSERT INTO journal_entry (id, payment_id, description, created_at)
+			VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(
+		ctx, query,
+		entry.ID,
+		uuid.NullUUID{UUID: entry.PaymentID, Valid: entry.PaymentID != uuid.Nil},
+		entry.Description,
+		entry.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	lineQuery := `INSERT INTO ledger_line (entry_id, account_id, 
+		book, currency, debit, credit)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+	for _, line := range entry.Lines {
+		if _, err := tx.ExecContext(
+			ctx, lineQuery,
+			entry.ID,
+			line.AccountID,
+			line.Book,
+			line.Currency,
+			line.Debit,
+			line.Credit,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (s *PostgresStorage) GetLedgerBalance(ctx context.Context, id uuid.UUID, currency string) (*types.LedgerBalance, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetLedgerBalance")
+	defer span.Finish()
+
+	query := `SELECT 
+		COALESCE(SUM(credit - debit) FILTER (WHERE book = $3), 0),
+		COALESCE(SUM(credit - debit) FILTER (WHERE book = $4), 0)
+			FROM ledger_line
+			WHERE account_id = $1 AND currency = $2`
+	balance := &types.LedgerBalance{
+		AccountID: id,
+		Currency:  currency,
+	}
+	if err := s.db.QueryRowContext(
+		ctx, query,
+		id,
+		currency,
+		types.BookAvailable,
+		types.BookBlocked,
+	).Scan(
+		&balance.Balance,
+		&balance.BlockedMoney,
+	); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// Lock account balances until the end of the transaction, wallets map
+// account id to currency. Rows are locked in account id order so concurrent
+// payments can't deadlock. sql.ErrNoRows means one of the accounts doesn't
+// hold the currency.
+func (s *PostgresStorage) LockBalances(ctx context.Context, tx *sql.Tx, wallets map[uuid.UUID]string) (map[uuid.UUID]*types.Balance, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.LockBalances")
+	defer span.Finish()
+
+	query := `SELECT * FROM account_balance
+				WHERE (account_id, currency) IN (
+					SELECT * FROM unnest($1::uuid[], $2::varchar[])
+				)
+				ORDER BY account_id
+				FOR UPDATE`
+	ids := make([]uuid.UUID, 0, len(wallets))
+	for id := range wallets {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	accountIds := make([]string, 0, len(ids))
+	currencies := make([]string, 0, len(ids))
+	for _, id := range ids {
+		accountIds = append(accountIds, id.String())
+		currencies = append(currencies, wallets[id])
+	}
+	rows, err := tx.QueryContext(ctx, query, pq.Array(accountIds), pq.Array(currencies))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := map[uuid.UUID]*types.Balance{}
+	for rows.Next() {
+		balance := &types.Balance{}
+		if err := rows.Scan(
+			&balance.AccountID, &balance.Currency,
+			&balance.Balance, &balance.BlockedMoney,
+		); err != nil {
+			return nil, err
+		}
+		balances[balance.AccountID] = balance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for id, currency := range wallets {
+		if balance, ok := balances[id]; !ok || balance.Currency != currency {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return balances, nil
+}
+
+// Lock payment row until the end of the transaction
+func (s *PostgresStorage) LockPayment(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*types.Payment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.LockPayment")
+	defer span.Finish()
+
+	query := `SELECT * FROM payment WHERE id = $1 FOR UPDATE`
+	pay := &types.Payment{}
+	if err := tx.QueryRowContext(
+		ctx, query, id,
+	).Scan(
+		&pay.ID, &pay.BusinessId,
+		&pay.OrderId, &pay.Operation,
+		&pay.Amount, &pay.Status,
+		&pay.Currency, &pay.CardToken,
+		&pay.CardExpiryMonth, &pay.CardExpiryYear,
+		&pay.CreatedAt, &pay.SettlementAmount,
+		&pay.SettlementCurrency, &pay.FxRate,
+		&pay.FxRateAt, &pay.Reason,
+		&pay.CapturedAmount, &pay.ReleasedAmount,
+		&pay.SettledAmount, &pay.ReferenceId,
+		&pay.RefundedAmount, &pay.ExpiresAt,
+		&pay.CardBrand, &pay.AccountId,
+		&pay.CardNumber,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+		return nil, err
+	}
+	return pay, nil
+}
+
+// Lock the oldest expired authorization with a hold,
+// authorizations locked by other workers are skipped
+func (s *PostgresStorage) LockExpiredPayment(ctx context.Context, tx *sql.Tx, now time.Time) (*types.Payment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.LockExpiredPayment")
+	defer span.Finish()
+
+	query := `SELECT * FROM payment
+				WHERE operation = $1
+					AND status IN ($2, $3)
+					AND expires_at <= $4
+					AND NOT EXISTS (
+						SELECT 1 FROM expiry_retry
+						WHERE expiry_retry.payment_id = payment.id AND expiry_retry.retry_at > $4
+					)
+				ORDER BY expires_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED`
+	pay := &types.Payment{}
+	if err := tx.QueryRowContext(
+		ctx, query,
+		types.OperationAuthorization,
+		types.StatusAuthorized,
+		types.StatusPartiallyCaptured,
+		now,
+	).Scan(
+		&pay.ID, &pay.BusinessId,
+		&pay.OrderId, &pay.Operation,
+		&pay.Amount, &pay.Status,
+		&pay.Currency, &pay.CardToken,
+		&pay.CardExpiryMonth, &pay.CardExpiryYear,
+		&pay.CreatedAt, &pay.SettlementAmount,
+		&pay.SettlementCurrency, &pay.FxRate,
+		&pay.FxRateAt, &pay.Reason,
+		&pay.CapturedAmount, &pay.ReleasedAmount,
+		&pay.SettledAmount, &pay.ReferenceId,
+		&pay.RefundedAmount, &pay.ExpiresAt,
+		&pay.CardBrand, &pay.AccountId,
+		&pay.CardNumber,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+		return nil, err
+	}
+	return pay, nil
+}
+
+// Skip the authorization that failed to expire until retryAt,
+// returns the number of failed attempts
+func (s *PostgresStorage) DeferExpiry(ctx context.Context, id uuid.UUID, retryAt time.Time) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.DeferExpiry")
+	defer span.Finish()
+
+	query := `INSERT INTO expiry_retry (payment_id, retry_at)
+				VALUES ($1, $2)
+				ON CONFLICT (payment_id) DO UPDATE
+				SET attempts = expiry_retry.attempts + 1, retry_at = EXCLUDED.retry_at
+				RETURNING attempts`
+	attempts := 0
+	if err := s.db.QueryRowContext(ctx, query, id, retryAt).Scan(&attempts); err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// Refunds of the capture, oldest first
+func (s *PostgresStorage) GetRefunds(ctx context.Context, id uuid.UUID) ([]*types.Payment, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetRefunds")
+	defer span.Finish()
+
+	query := `SELECT * FROM payment
+				WHERE reference_id = $1 AND operation = $2
+				ORDER BY created_at`
+	rows, err := s.db.QueryContext(ctx, query, id, types.OperationRefund)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []*types.Payment{}
+	for rows.Next() {
+		pay := &types.Payment{}
+		if err := rows.Scan(
+			&pay.ID, &pay.BusinessId,
+			&pay.OrderId, &pay.Operation,
+			&pay.Amount, &pay.Status,
+			&pay.Currency, &pay.CardToken,
+			&pay.CardExpiryMonth, &pay.CardExpiryYear,
+			&pay.CreatedAt, &pay.SettlementAmount,
+			&pay.SettlementCurrency, &pay.FxRate,
+			&pay.FxRateAt, &pay.Reason,
+			&pay.CapturedAmount, &pay.ReleasedAmount,
+			&pay.SettledAmount, &pay.ReferenceId,
+			&pay.RefundedAmount, &pay.ExpiresAt,
+			&pay.CardBrand, &pay.AccountId,
+			&pay.CardNumber,
+		); err != nil {
+			return nil, err
+		}
+		if err := s.cipher.Open(ctx, "payment", paymentColumns(pay)); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, pay)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (s *PostgresStorage) SaveAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveAPIKey")
+	defer span.Finish()
+
+	query := `INSERT INTO api_key (account_id, type, mode, prefix, last4, hash, created_at, signing_secret)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				RETURNING *`
+	sealed := *key
+	if err := s.cipher.Seal(ctx, "api_key", apiKeyColumns(&sealed)); err != nil {
+		return nil, err
+	}
+	apiKey := &types.APIKey{}
+	if err := s.db.QueryRowContext(
+		ctx, query,
+		key.AccountID,
+		key.Type,
+		key.Mode,
+		key.Prefix,
+		key.Last4,
+		key.Hash,
+		key.CreatedAt,
+		sealed.SigningSecret,
+	).Scan(
+		&apiKey.ID, &apiKey.AccountID,
+		&apiKey.Type, &apiKey.Mode,
+		&apiKey.Prefix, &apiKey.Last4,
+		&apiKey.Hash, &apiKey.CreatedAt,
+		&apiKey.RevokedAt, &apiKey.SigningSecret,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "api_key", apiKeyColumns(apiKey)); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// API keys of the account including revoked ones, oldest first
+func (s *PostgresStorage) GetAPIKeys(ctx context.Context, id uuid.UUID) ([]*types.APIKey, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetAPIKeys")
+	defer span.Finish()
+
+	query := `SELECT * FROM api_key
+				WHERE account_id = $1
+				ORDER BY created_at`
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*types.APIKey{}
+	for rows.Next() {
+		apiKey := &types.APIKey{}
+		if err := rows.Scan(
+			&apiKey.ID, &apiKey.AccountID,
+			&apiKey.Type, &apiKey.Mode,
+			&apiKey.Prefix, &apiKey.Last4,
+			&apiKey.Hash, &apiKey.CreatedAt,
+			&apiKey.RevokedAt, &apiKey.SigningSecret,
+		); err != nil {
+			return nil, err
+		}
+		if err := s.cipher.Open(ctx, "api_key", apiKeyColumns(apiKey)); err != nil {
+			return nil, err
+		}
+		keys = append(keys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Active API key by the hash of the key
+func (s *PostgresStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKey, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetAPIKeyByHash")
+	defer span.Finish()
+
+	query := `SELECT * FROM api_key
+				WHERE hash = $1 AND revoked_at IS NULL`
+	apiKey := &types.APIKey{}
+	if err := s.db.QueryRowContext(ctx, query, hash).Scan(
+		&apiKey.ID, &apiKey.AccountID,
+		&apiKey.Type, &apiKey.Mode,
+		&apiKey.Prefix, &apiKey.Last4,
+		&apiKey.Hash, &apiKey.CreatedAt,
+		&apiKey.RevokedAt, &apiKey.SigningSecret,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "api_key", apiKeyColumns(apiKey)); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// Revoke active API key of the account
+func (s *PostgresStorage) RevokeAPIKey(ctx context.Context, accountId, id uuid.UUID) (*types.APIKey, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.RevokeAPIKey")
+	defer span.Finish()
+
+	query := `UPDATE api_key
+				SET revoked_at = $1
+				WHERE id = $2 AND account_id = $3 AND revoked_at IS NULL
+				RETURNING *`
+	apiKey := &types.APIKey{}
+	if err := s.db.QueryRowContext(ctx, query, time.Now(), id, accountId).Scan(
+		&apiKey.ID, &apiKey.AccountID,
+		&apiKey.Type, &apiKey.Mode,
+		&apiKey.Prefix, &apiKey.Last4,
+		&apiKey.Hash, &apiKey.CreatedAt,
+		&apiKey.RevokedAt, &apiKey.SigningSecret,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "api_key", apiKeyColumns(apiKey)); err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+// Set account password, failed sign-ins and lockout are cleared
+func (s *PostgresStorage) SaveCredential(ctx context.Context, credential *types.Credential) (*types.Credential, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveCredential")
+	defer span.Finish()
+
+	query := `INSERT INTO credential (account_id, password_hash, updated_at)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (account_id)
+				DO UPDATE SET password_hash = EXCLUDED.password_hash,
+					failed_logins = 0,
+					locked_until = NULL,
+					updated_at = EXCLUDED.updated_at
+				RETURNING *`
+	cred := &types.Credential{}
+	if err := s.db.QueryRowContext(
+		ctx, query,
+		credential.AccountID,
+		credential.PasswordHash,
+		time.Now(),
+	).Scan(
+		&cred.AccountID, &cred.PasswordHash,
+		&cred.FailedLogins, &cred.LockedUntil,
+		&cred.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (s *PostgresStorage) GetCredential(ctx context.Context, id uuid.UUID) (*types.Credential, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetCredential")
+	defer span.Finish()
+
+	query := `SELECT * FROM credential WHERE account_id = $1`
+	cred := &types.Credential{}
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&cred.AccountID, &cred.PasswordHash,
+		&cred.FailedLogins, &cred.LockedUntil,
+		&cred.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// Count failed sign-in, the maximum number of failures locks sign-in
+// until lockedUntil and starts counting again
+func (s *PostgresStorage) SaveFailedLogin(ctx context.Context, id uuid.UUID, maxFailures int, lockedUntil time.Time) (*types.Credential, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveFailedLogin")
+	defer span.Finish()
+
+	query := `UPDATE credential
+				SET failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+					locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END
+				WHERE account_id = $1
+				RETURNING *`
+	cred := &types.Credential{}
+	if err := s.db.QueryRowContext(ctx, query, id, maxFailures, lockedUntil).Scan(
+		&cred.AccountID, &cred.PasswordHash,
+		&cred.FailedLogins, &cred.LockedUntil,
+		&cred.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// Clear failed sign-ins after a successful one
+func (s *PostgresStorage) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.ResetFailedLogins")
+	defer span.Finish()
+
+	query := `UPDATE credential
+				SET failed_logins = 0, locked_until = NULL
+				WHERE account_id = $1`
+	if _, err := s.db.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Start TOTP enrollment, an unconfirmed one is replaced,
+// sql.ErrNoRows if two-factor authentication is already enabled
+func (s *PostgresStorage) SaveTOTP(ctx context.Context, totp *types.TOTP) (*types.TOTP, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.SaveTOTP")
+	defer span.Finish()
+
+	query := `INSERT INTO totp (account_id, secret, recovery_codes, created_at)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (account_id)
+				DO UPDATE SET secret = EXCLUDED.secret,
+					recovery_codes = EXCLUDED.recovery_codes,
+					last_counter = 0,
+					created_at = EXCLUDED.created_at
+				WHERE totp.confirmed_at IS NULL
+				RETURNING *`
+	sealed := *totp
+	if err := s.cipher.Seal(ctx, "totp", totpColumns(&sealed)); err != nil {
+		return nil, err
+	}
+	saved := &types.TOTP{}
+	if err := s.db.QueryRowContext(
+		ctx, query,
+		totp.AccountID,
+		sealed.Secret,
+		pq.Array(totp.RecoveryCodes),
+		time.Now(),
+	).Scan(
+		&saved.AccountID, &saved.Secret,
+		pq.Array(&saved.RecoveryCodes), &saved.LastCounter,
+		&saved.ConfirmedAt, &saved.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "totp", totpColumns(saved)); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (s *PostgresStorage) GetTOTP(ctx context.Context, id uuid.UUID) (*types.TOTP, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.GetTOTP")
+	defer span.Finish()
+
+	query := `SELECT * FROM totp WHERE account_id = $1`
+	totp := &types.TOTP{}
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&totp.AccountID, &totp.Secret,
+		pq.Array(&totp.RecoveryCodes), &totp.LastCounter,
+		&totp.ConfirmedAt, &totp.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := s.cipher.Open(ctx, "totp", totpColumns(totp)); err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// Accept the code of the time step, false if a code
+// of this or a later step was already accepted.
+// The first accepted code confirms the enrollment
+func (s *PostgresStorage) UseTOTPCode(ctx context.Context, id uuid.UUID, counter int64) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UseTOTPCode")
+	defer span.Finish()
+
+	query := `UPDATE totp
+				SET last_counter = $2,
+					confirmed_at = COALESCE(confirmed_at, $3)
+				WHERE account_id = $1 AND last_counter < $2`
+	result, err := s.db.ExecContext(ctx, query, id, counter, time.Now())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// Remove the recovery code by its hash, false if there is no such code
+func (s *PostgresStorage) UseRecoveryCode(ctx context.Context, id uuid.UUID, hash string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Psql.UseRecoveryCode")
+	defer span.Finish()
+
+	query := `UPDATE totp
+				SET recovery_codes = array_remove(recovery_codes, $2)
+				WHERE account_id = $1 AND confirmed_at IS NOT NULL AND $2 = ANY(recovery_codes)`
+	result, err := s.db.ExecContext(ctx, query, id, hash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
 }
@@ -2,11 +2,13 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Edbeer/paymentapi/pkg/envelope"
 	"github.com/Edbeer/paymentapi/types"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
@@ -20,7 +22,8 @@ func Test_CreateAccount(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	keys := testKeys(t)
+	psql := NewPostgresStorage(db, keys)
 
 	t.Run("Create", func(t *testing.T) {
 		req := &types.RequestCreate{
@@ -31,51 +34,63 @@ func Test_CreateAccount(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(req)
+		card := &types.Card{Token: "tok_1", CardNumber: "444444*****4444"}
+		account := types.NewAccount(req, card)
 
 		colums := []string{
 			"id",
 			"first_name",
 			"last_name",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
+			"statement",
 			"created_at",
+			"currency",
+			"authorization_ttl",
+			"role",
+			"card_number",
 		}
 		rows := sqlmock.NewRows(colums).AddRow(
 			account.ID,
 			"Pasha1",
 			"volkov1",
-			"444444444444444",
+			"tok_1",
 			"12",
 			"24",
-			"924",
-			0,
-			0,
 			pq.Array(account.Statement),
 			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"444444*****4444",
 		)
-		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO account (first_name, 
-			last_name, card_number, card_expiry_month, 
-			card_expiry_year, card_security_code, 
-			balance, blocked_money, statement, created_at)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, now())
-				RETURNING *`)).WithArgs(
-			account.FirstName,
-			account.LastName,
-			account.CardNumber,
-			account.CardExpiryMonth,
-			account.CardExpiryYear,
-			account.CardSecurityCode,
-			account.Balance,
-			account.BlockedMoney,
-			pq.Array(account.Statement)).WillReturnRows(rows)
-		createdUser, err := psql.CreateAccount(context.Background(), req)
+		mock.ExpectQuery(regexp.QuoteMeta(`WITH acc AS (
+			INSERT INTO account (first_name, 
+			last_name, card_token, card_expiry_month, 
+			card_expiry_year, statement, 
+			created_at, currency, role, card_number)
+				VALUES ($1, $2, $3, $4, $5, $6, now(), $7, $8, $9)
+				RETURNING *
+			), balance AS (
+			INSERT INTO account_balance (account_id, currency)
+				SELECT id, currency FROM acc
+			)
+			SELECT * FROM acc`)).WithArgs(
+			sealedArg{keys: keys, table: "account", column: "first_name", value: account.FirstName},
+			sealedArg{keys: keys, table: "account", column: "last_name", value: account.LastName},
+			account.CardToken,
+			sealedArg{keys: keys, table: "account", column: "card_expiry_month", value: account.CardExpiryMonth},
+			sealedArg{keys: keys, table: "account", column: "card_expiry_year", value: account.CardExpiryYear},
+			pq.Array(account.Statement),
+			types.DefaultCurrency,
+			types.RoleCustomer,
+			"444444*****4444").WillReturnRows(rows)
+		createdUser, err := psql.CreateAccount(context.Background(), req, card)
 		require.NoError(t, err)
 		require.NotNil(t, createdUser)
 		require.Equal(t, createdUser.ID, account.ID)
+		account.Balances = []*types.Balance{{AccountID: account.ID, Currency: types.DefaultCurrency}}
 		require.Equal(t, createdUser, account)
 	})
 }
@@ -87,7 +102,7 @@ func Test_GetAccount(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("GetAccounts", func(t *testing.T) {
 		req1 := &types.RequestCreate{
@@ -98,17 +113,20 @@ func Test_GetAccount(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account1 := types.NewAccount(req1)
+		account1 := types.NewAccount(req1, &types.Card{Token: "tok_1"})
 		colums := []string{
 			"id",
 			"first_name",
 			"last_name",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
+			"statement",
 			"created_at",
+			"currency",
+			"authorization_ttl",
+			"role",
+			"card_number",
 		}
 		rows1 := sqlmock.NewRows(colums).AddRow(
 			account1.ID,
@@ -117,11 +135,12 @@ func Test_GetAccount(t *testing.T) {
 			"444444444444444",
 			"12",
 			"24",
-			"924",
-			0,
-			0,
 			pq.Array(account1.Statement),
 			account1.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
 		)
 		req2 := &types.RequestCreate{
 			FirstName:        "Pasha",
@@ -131,7 +150,7 @@ func Test_GetAccount(t *testing.T) {
 			CardExpiryYear:   "25",
 			CardSecurityCode: "934",
 		}
-		account2 := types.NewAccount(req2)
+		account2 := types.NewAccount(req2, &types.Card{Token: "tok_1"})
 
 		rows2 := sqlmock.NewRows(colums).AddRow(
 			account2.ID,
@@ -140,11 +159,12 @@ func Test_GetAccount(t *testing.T) {
 			"444444444444444",
 			"12",
 			"24",
-			"924",
-			0,
-			0,
 			pq.Array(account2.Statement),
 			account2.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
 		)
 
 		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM account`)).WillReturnRows(rows1, rows2)
@@ -161,7 +181,8 @@ func Test_UpdateAccount(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	keys := testKeys(t)
+	psql := NewPostgresStorage(db, keys)
 
 	t.Run("Update", func(t *testing.T) {
 		reqToUpdate := &types.RequestUpdate{
@@ -170,19 +191,21 @@ func Test_UpdateAccount(t *testing.T) {
 			CardNumber:       "444444444444444",
 			CardExpiryMonth:  "",
 			CardExpiryYear:   "",
-			CardSecurityCode: "",
 		}
 
 		colums := []string{
 			"id",
 			"first_name",
 			"last_name",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
+			"statement",
 			"created_at",
+			"currency",
+			"authorization_ttl",
+			"role",
+			"card_number",
 		}
 		reqToCreate := &types.RequestCreate{
 			FirstName:        "Pasha",
@@ -192,7 +215,7 @@ func Test_UpdateAccount(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(reqToCreate)
+		account := types.NewAccount(reqToCreate, &types.Card{Token: "tok_1"})
 		rows := sqlmock.NewRows(colums).AddRow(
 			account.ID,
 			"Pasha1",
@@ -200,36 +223,70 @@ func Test_UpdateAccount(t *testing.T) {
 			 "444444444444444",
 			 "12",
 			 "24",
-			 "924",
-			0,
-			0,
 			pq.Array(account.Statement),
 			time.Now(),
+			"RUB",
+			0,
+			"customer",
+			"",
 		)
 
 		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account
 		SET first_name = COALESCE(NULLIF($1, ''), first_name),
 			last_name = COALESCE(NULLIF($2, ''), last_name),
-			card_number = COALESCE(NULLIF($3, ''), card_number),
+			card_token = COALESCE(NULLIF($3, ''), card_token),
 			card_expiry_month = COALESCE(NULLIF($4, ''), card_expiry_month),
 			card_expiry_year = COALESCE(NULLIF($5, ''), card_expiry_year),
-			card_security_code = COALESCE(NULLIF($6, ''), card_security_code)
-		WHERE id = $7
+			authorization_ttl = COALESCE($6, authorization_ttl),
+			card_number = COALESCE(NULLIF($7, ''), card_number)
+		WHERE id = $8
 		RETURNING *`)).WithArgs(
-			reqToUpdate.FirstName,
-			reqToUpdate.LastName,
-			reqToUpdate.CardNumber,
+			sealedArg{keys: keys, table: "account", column: "first_name", value: reqToUpdate.FirstName},
+			sealedArg{keys: keys, table: "account", column: "last_name", value: reqToUpdate.LastName},
+			"tok_2",
 			reqToUpdate.CardExpiryMonth,
 			reqToUpdate.CardExpiryYear,
-			reqToUpdate.CardSecurityCode,
+			reqToUpdate.AuthorizationTTL,
+			"444444*****4444",
 			account.ID).WillReturnRows(rows)
 
-		updatedUser, err := psql.UpdateAccount(context.Background(), reqToUpdate, account.ID)
+		updatedUser, err := psql.UpdateAccount(context.Background(), reqToUpdate, &types.Card{Token: "tok_2", CardNumber: "444444*****4444"}, account.ID)
 		require.NoError(t, err)
 		require.NotEqual(t, updatedUser, account)
 	})
 }
 
+func Test_UpdateRole(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("Update", func(t *testing.T) {
+		account := types.NewAccount(&types.RequestCreate{FirstName: "Pasha", LastName: "volkov"}, &types.Card{Token: "tok_1"})
+		account.ID = uuid.New()
+		rows := sqlmock.NewRows([]string{
+			"id", "first_name", "last_name", "card_token", "card_expiry_month", "card_expiry_year",
+			"statement", "created_at", "currency", "authorization_ttl", "role", "card_number",
+		}).AddRow(
+			account.ID, "Pasha", "volkov", "", "", "",
+			pq.Array(account.Statement), account.CreatedAt, "RUB", 0, types.RoleSupport, "",
+		)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account
+		SET role = $1
+		WHERE id = $2
+		RETURNING *`)).WithArgs(types.RoleSupport, account.ID).WillReturnRows(rows)
+
+		updated, err := psql.UpdateRole(context.Background(), account.ID, types.RoleSupport)
+		require.NoError(t, err)
+		require.Equal(t, types.RoleSupport, updated.Role)
+	})
+}
+
 func Test_DeleteAccount(t *testing.T) {
 	t.Parallel()
 
@@ -237,7 +294,7 @@ func Test_DeleteAccount(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("Delete", func(t *testing.T) {
 		uid := uuid.New()
@@ -255,7 +312,7 @@ func Test_GetAccountByID(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("GetAccountByID", func(t *testing.T) {
 		req := &types.RequestCreate{
@@ -266,30 +323,34 @@ func Test_GetAccountByID(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(req)
+		account := types.NewAccount(req, &types.Card{Token: "tok_1"})
 		colums := []string{
 			"id",
 			"first_name",
 			"last_name",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
+			"statement",
 			"created_at",
+			"currency",
+			"authorization_ttl",
+			"role",
+			"card_number",
 		}
 		rows := sqlmock.NewRows(colums).AddRow(
 			account.ID,
 			"Pasha1",
 			"volkov1",
-			"444444444444444",
+			"tok_1",
 			"12",
 			"24",
-			"924",
-			0,
-			0,
 			pq.Array(account.Statement),
 			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
 		)
 
 		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM account WHERE id = $1`)).WithArgs(account.ID).WillReturnRows(rows)
@@ -299,116 +360,94 @@ func Test_GetAccountByID(t *testing.T) {
 	})
 }
 
-func Test_GetAccountByCard(t *testing.T) {
+func Test_DepositAccount(t *testing.T) {
 	t.Parallel()
 
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
-	t.Run("GetAccountByCard", func(t *testing.T) {
-		req := &types.RequestCreate{
-			FirstName:        "Pasha1",
-			LastName:         "volkov1",
-			CardNumber:       "444444444444444",
-			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-		}
-		account := types.NewAccount(req)
-		colums := []string{
-			"id",
-			"first_name",
-			"last_name",
-			"card_number",
-			"card_expiry_month",
-			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
-			"created_at",
+	t.Run("Daposit", func(t *testing.T) {
+		id := uuid.New()
+		reqDep := &types.RequestDeposit{
+			CardNumber: "444444444444444",
+			Currency:   "EUR",
+			Balance:    50,
 		}
-		rows := sqlmock.NewRows(colums).AddRow(
-			account.ID,
-			"Pasha1",
-			"volkov1",
-			"444444444444444",
-			"12",
-			"24",
-			"924",
-			0,
-			0,
-			pq.Array(account.Statement),
-			account.CreatedAt,
-		)
 
-		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM account WHERE card_number = $1`)).WithArgs(account.CardNumber).WillReturnRows(rows)
-		acc, err := psql.GetAccountByCard(context.Background(), account.CardNumber)
+		rows := sqlmock.NewRows([]string{
+			"account_id", "currency", "balance", "blocked_money",
+		}).AddRow(id, "EUR", 50, 0)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account_balance AS b
+		SET balance = b.balance + $1
+		FROM account AS a
+		WHERE a.id = b.account_id
+			AND a.id = $2
+			AND b.currency = COALESCE(NULLIF($3, ''), a.currency)
+		RETURNING b.*`)).WithArgs(uint64(50), id, "EUR").WillReturnRows(rows)
+		tx, _ := db.BeginTx(context.Background(), nil)
+		balance, err := psql.DepositAccount(context.Background(), tx, reqDep, id)
 		require.NoError(t, err)
-		require.NotNil(t, acc)
-		require.Equal(t, acc.ID, account.ID)
+		require.Equal(t, id, balance.AccountID)
+		require.Equal(t, "EUR", balance.Currency)
+		require.Equal(t, uint64(50), balance.Balance)
 	})
 }
 
-func Test_DepositAccount(t *testing.T) {
+func Test_GetBalances(t *testing.T) {
 	t.Parallel()
 
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
-	t.Run("Daposit", func(t *testing.T) {
-		req := &types.RequestCreate{
-			FirstName:        "Pasha1",
-			LastName:         "volkov1",
-			CardNumber:       "444444444444444",
-			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
-		}
-		account := types.NewAccount(req)
+	t.Run("GetBalances", func(t *testing.T) {
+		id := uuid.New()
+		rows := sqlmock.NewRows([]string{
+			"account_id", "currency", "balance", "blocked_money",
+		}).AddRow(id, "EUR", 10, 0).AddRow(id, "RUB", 50, 20)
 
-		reqDep := &types.RequestDeposit{
-			CardNumber: "444444444444444",
-			Balance:    50,
-		}
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM account_balance
+		WHERE account_id = $1
+		ORDER BY currency`)).WithArgs(id).WillReturnRows(rows)
+		balances, err := psql.GetBalances(context.Background(), id)
+		require.NoError(t, err)
+		require.Len(t, balances, 2)
+		require.Equal(t, "EUR", balances[0].Currency)
+		require.Equal(t, uint64(20), balances[1].BlockedMoney)
+	})
+}
 
-		colums := []string{
-			"id",
-			"first_name",
-			"last_name",
-			"card_number",
-			"card_expiry_month",
-			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
-			"created_at",
-		}
-		rows := sqlmock.NewRows(colums).AddRow(
-			account.ID,
-			"Pasha1",
-			"volkov1",
-			"444444444444444",
-			"12",
-			"24",
-			"924",
-			50,
-			0,
-			pq.Array(account.Statement),
-			account.CreatedAt,
-		)
+func Test_OpenBalance(t *testing.T) {
+	t.Parallel()
 
-		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account
-		SET balance = COALESCE(NULLIF($1, 0), balance)
-		WHERE card_number = $2
-		RETURNING *`)).WithArgs(uint64(50), account.CardNumber).WillReturnRows(rows)
-		acc, err := psql.DepositAccount(context.Background(), reqDep)
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("OpenBalance", func(t *testing.T) {
+		id := uuid.New()
+		rows := sqlmock.NewRows([]string{
+			"account_id", "currency", "balance", "blocked_money",
+		}).AddRow(id, "USD", 0, 0)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO account_balance (account_id, currency)
+		VALUES ($1, $2)
+		ON CONFLICT (account_id, currency)
+		DO UPDATE SET currency = EXCLUDED.currency
+		RETURNING *`)).WithArgs(id, "USD").WillReturnRows(rows)
+		balance, err := psql.OpenBalance(context.Background(), id, "USD")
 		require.NoError(t, err)
-		require.Equal(t, acc.CardNumber, account.CardNumber)
-		require.Equal(t, acc.Balance, uint64(50))
+		require.Equal(t, id, balance.AccountID)
+		require.Equal(t, "USD", balance.Currency)
 	})
 }
 
@@ -419,7 +458,7 @@ func Test_GetAccountStatement(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("GetAccountStatement", func(t *testing.T) {
 		req := &types.RequestCreate{
@@ -430,30 +469,34 @@ func Test_GetAccountStatement(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(req)
+		account := types.NewAccount(req, &types.Card{Token: "tok_1"})
 		colums := []string{
 			"id",
 			"first_name",
 			"last_name",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
+			"statement",
 			"created_at",
+			"currency",
+			"authorization_ttl",
+			"role",
+			"card_number",
 		}
 		rows := sqlmock.NewRows(colums).AddRow(
 			account.ID,
 			"Pasha1",
 			"volkov1",
-			"444444444444444",
+			"tok_1",
 			"12",
 			"24",
-			"924",
-			0,
-			0,
 			pq.Array(account.Statement),
 			account.CreatedAt,
+			"RUB",
+			0,
+			"customer",
+			"",
 		)
 
 		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM account WHERE id = $1`)).WithArgs(account.ID).WillReturnRows(rows)
@@ -471,7 +514,8 @@ func Test_SavePayment(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	keys := testKeys(t)
+	psql := NewPostgresStorage(db, keys)
 
 	t.Run("SavePayment", func(t *testing.T) {
 		reqAcc := &types.RequestCreate{
@@ -482,7 +526,7 @@ func Test_SavePayment(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(reqAcc)
+		account := types.NewAccount(reqAcc, &types.Card{Token: "tok_1"})
 
 		reqMer := &types.RequestCreate{
 			FirstName:        "Pasha",
@@ -492,7 +536,7 @@ func Test_SavePayment(t *testing.T) {
 			CardExpiryYear:   "22",
 			CardSecurityCode: "934",
 		}
-		merchant := types.NewAccount(reqMer)
+		merchant := types.NewAccount(reqMer, &types.Card{Token: "tok_1"})
 
 		payReq := &types.PaymentRequest{
 			AccountId: account.ID,
@@ -504,7 +548,7 @@ func Test_SavePayment(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		payment := types.CreateAuthPayment(payReq, account, merchant, "")
+		payment := types.CreateAuthPayment(payReq, account, merchant, types.StatusAuthorized)
 
 		colums := []string{
 			"id",
@@ -514,10 +558,24 @@ func Test_SavePayment(t *testing.T) {
 			"amount",
 			"status",
 			"currency",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
 			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
 		}
 		rows := sqlmock.NewRows(colums).AddRow(
 			payment.ID,
@@ -531,14 +589,32 @@ func Test_SavePayment(t *testing.T) {
 			"12",
 			"24",
 			payment.CreatedAt,
+			50,
+			"RUB",
+			"1",
+			payment.CreatedAt,
+			"",
+			0,
+			0,
+			0,
+			uuid.Nil,
+			0,
+			nil,
+			"visa",
+			account.ID,
+			"",
 		)
 
 		mock.ExpectBegin()
 		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO payment (id, business_id, 
 			order_id, operation, amount, status, 
-			currency, card_number, card_expiry_month,
-			 card_expiry_year, created_at)
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			currency, card_token, card_expiry_month,
+			 card_expiry_year, created_at, settlement_amount,
+			 settlement_currency, fx_rate, fx_rate_at, reason,
+			 captured_amount, released_amount, settled_amount, reference_id,
+			 refunded_amount, expires_at, card_brand, account_id, card_number)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+					$11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
 				RETURNING *`)).WithArgs(payment.ID,
 					payment.BusinessId,
 					payment.OrderId,
@@ -546,15 +622,30 @@ func Test_SavePayment(t *testing.T) {
 					payment.Amount,
 					payment.Status,
 					payment.Currency,
-					payment.CardNumber,
-					payment.CardExpiryMonth,
-					payment.CardExpiryYear,
-					payment.CreatedAt,).WillReturnRows(rows)
+					payment.CardToken,
+					sealedArg{keys: keys, table: "payment", column: "card_expiry_month", value: payment.CardExpiryMonth},
+					sealedArg{keys: keys, table: "payment", column: "card_expiry_year", value: payment.CardExpiryYear},
+					payment.CreatedAt,
+					payment.SettlementAmount,
+					payment.SettlementCurrency,
+					payment.FxRate,
+					payment.FxRateAt,
+					payment.Reason,
+					payment.CapturedAmount,
+					payment.ReleasedAmount,
+					payment.SettledAmount,
+					payment.ReferenceId,
+					payment.RefundedAmount,
+					payment.ExpiresAt,
+					payment.CardBrand,
+					payment.AccountId,
+					payment.CardNumber,).WillReturnRows(rows)
 
 		tx, _ := db.BeginTx(context.Background(), nil)
 		pay, err := psql.SavePayment(context.Background(), tx, payment)
 		require.NoError(t, err)
 		require.NotNil(t, pay)
+		require.Equal(t, account.ID, pay.AccountId)
 	})
 }
 
@@ -565,55 +656,34 @@ func Test_SaveBalance(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("SaveBalance", func(t *testing.T) {
-		req := &types.RequestCreate{
-			FirstName:        "Pasha1",
-			LastName:         "volkov1",
-			CardNumber:       "444444444444444",
-			CardExpiryMonth:  "12",
-			CardExpiryYear:   "24",
-			CardSecurityCode: "924",
+		balance := &types.Balance{
+			AccountID: uuid.New(),
+			Currency:  "RUB",
+			Balance:   100,
 		}
-		account := types.NewAccount(req)
 
-		colums := []string{
-			"id",
-			"first_name",
-			"last_name",
-			"card_number",
-			"card_expiry_month",
-			"card_expiry_year",
-			"card_security_code",
-			"balance", "blocked_money", "statement",
-			"created_at",
-		}
-		rows := sqlmock.NewRows(colums).AddRow(
-			account.ID,
-			"Pasha1",
-			"volkov1",
-			"444444444444444",
-			"12",
-			"24",
-			"924",
-			50,
-			50,
-			pq.Array(account.Statement),
-			account.CreatedAt,
-		)
+		rows := sqlmock.NewRows([]string{
+			"account_id", "currency", "balance", "blocked_money",
+		}).AddRow(balance.AccountID, "RUB", 50, 50)
 
 		mock.ExpectBegin()
-		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account
-		SET balance = COALESCE(NULLIF($1, 0), balance),
-			blocked_money = COALESCE(NULLIF($2, 0), blocked_money)
-		WHERE id = $3
-		RETURNING *`)).WithArgs(50, 50, account.ID).WillReturnRows(rows)
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE account_balance
+		SET balance = balance + $1,
+			blocked_money = blocked_money + $2
+		WHERE account_id = $3 AND currency = $4
+		RETURNING *`)).WithArgs(-50, 50, balance.AccountID, "RUB").WillReturnRows(rows)
 		
 		tx, _ := db.BeginTx(context.Background(), nil)
-		acc, err := psql.SaveBalance(context.Background(), tx, account, 50, 50)
+		updated, err := psql.SaveBalance(context.Background(), tx, balance, types.BalanceDelta{
+			Balance:      -50,
+			BlockedMoney: 50,
+		})
 		require.NoError(t, err)
-		require.NotNil(t, acc)
+		require.Equal(t, uint64(50), updated.Balance)
+		require.Equal(t, uint64(50), updated.BlockedMoney)
 	})
 }
 
@@ -624,7 +694,7 @@ func Test_GetPaymentByID(t *testing.T) {
 	require.NoError(t, err)
 	defer db.Close()
 
-	psql := NewPostgresStorage(db)
+	psql := NewPostgresStorage(db, testKeys(t))
 
 	t.Run("GetPaymentByID", func(t *testing.T) {
 		reqAcc := &types.RequestCreate{
@@ -635,7 +705,7 @@ func Test_GetPaymentByID(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		account := types.NewAccount(reqAcc)
+		account := types.NewAccount(reqAcc, &types.Card{Token: "tok_1"})
 
 		reqMer := &types.RequestCreate{
 			FirstName:        "Pasha",
@@ -645,7 +715,7 @@ func Test_GetPaymentByID(t *testing.T) {
 			CardExpiryYear:   "22",
 			CardSecurityCode: "934",
 		}
-		merchant := types.NewAccount(reqMer)
+		merchant := types.NewAccount(reqMer, &types.Card{Token: "tok_1"})
 
 		payReq := &types.PaymentRequest{
 			AccountId: account.ID,
@@ -657,7 +727,7 @@ func Test_GetPaymentByID(t *testing.T) {
 			CardExpiryYear:   "24",
 			CardSecurityCode: "924",
 		}
-		payment := types.CreateAuthPayment(payReq, account, merchant, "")
+		payment := types.CreateAuthPayment(payReq, account, merchant, types.StatusAuthorized)
 
 		colums := []string{
 			"id",
@@ -667,10 +737,24 @@ func Test_GetPaymentByID(t *testing.T) {
 			"amount",
 			"status",
 			"currency",
-			"card_number",
+			"card_token",
 			"card_expiry_month",
 			"card_expiry_year",
 			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
 		}
 		rows := sqlmock.NewRows(colums).AddRow(
 			payment.ID,
@@ -684,6 +768,20 @@ func Test_GetPaymentByID(t *testing.T) {
 			"12",
 			"24",
 			payment.CreatedAt,
+			50,
+			"RUB",
+			"1",
+			payment.CreatedAt,
+			"",
+			0,
+			0,
+			0,
+			uuid.Nil,
+			0,
+			nil,
+			"visa",
+			nil,
+			"",
 		)
 
 		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM payment WHERE id = $1`)).WithArgs(payment.ID).WillReturnRows(rows)
@@ -692,4 +790,800 @@ func Test_GetPaymentByID(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, pay)
 	})
-}
\ No newline at end of file
+}
+
+func Test_SaveJournalEntry(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("SaveJournalEntry", func(t *testing.T) {
+		accountID := uuid.New()
+		entry := types.DepositEntry(accountID, types.DefaultCurrency, 50)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO journal_entry (id, payment_id, description, created_at)
+			VALUES ($1, $2, $3, $4)`)).WithArgs(
+			entry.ID,
+			uuid.NullUUID{},
+			entry.Description,
+			entry.CreatedAt,
+		).WillReturnResult(sqlmock.NewResult(0, 1))
+		for _, line := range entry.Lines {
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO ledger_line (entry_id, account_id, 
+				book, currency, debit, credit)
+					VALUES ($1, $2, $3, $4, $5, $6)`)).WithArgs(
+				entry.ID,
+				line.AccountID,
+				line.Book,
+				line.Currency,
+				line.Debit,
+				line.Credit,
+			).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		saved, err := psql.SaveJournalEntry(context.Background(), tx, entry)
+		require.NoError(t, err)
+		require.Equal(t, entry, saved)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Unbalanced", func(t *testing.T) {
+		entry := types.NewJournalEntry(uuid.New(), "Authorization")
+		entry.Lines = append(entry.Lines, &types.LedgerLine{
+			EntryID:   entry.ID,
+			AccountID: uuid.New(),
+			Book:      types.BookAvailable,
+			Currency:  types.DefaultCurrency,
+			Credit:    50,
+		})
+
+		mock.ExpectBegin()
+		tx, _ := db.BeginTx(context.Background(), nil)
+		saved, err := psql.SaveJournalEntry(context.Background(), tx, entry)
+		require.Error(t, err)
+		require.Nil(t, saved)
+	})
+}
+
+func Test_GetLedgerBalance(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("GetLedgerBalance", func(t *testing.T) {
+		accountID := uuid.New()
+		rows := sqlmock.NewRows([]string{"balance", "blocked_money"}).AddRow(50, 20)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT 
+			COALESCE(SUM(credit - debit) FILTER (WHERE book = $3), 0),
+			COALESCE(SUM(credit - debit) FILTER (WHERE book = $4), 0)
+				FROM ledger_line
+				WHERE account_id = $1 AND currency = $2`)).WithArgs(
+			accountID, types.DefaultCurrency, types.BookAvailable, types.BookBlocked,
+		).WillReturnRows(rows)
+
+		balance, err := psql.GetLedgerBalance(context.Background(), accountID, types.DefaultCurrency)
+		require.NoError(t, err)
+		require.Equal(t, int64(50), balance.Balance)
+		require.Equal(t, int64(20), balance.BlockedMoney)
+	})
+}
+
+func Test_LockBalances(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	colums := []string{
+		"account_id", "currency", "balance", "blocked_money",
+	}
+	accountID, merchantID := uuid.New(), uuid.New()
+	ids := []string{accountID.String(), merchantID.String()}
+	currencies := []string{"EUR", "RUB"}
+	if ids[0] > ids[1] {
+		ids[0], ids[1] = ids[1], ids[0]
+		currencies[0], currencies[1] = currencies[1], currencies[0]
+	}
+	query := regexp.QuoteMeta(`SELECT * FROM account_balance
+		WHERE (account_id, currency) IN (
+			SELECT * FROM unnest($1::uuid[], $2::varchar[])
+		)
+		ORDER BY account_id
+		FOR UPDATE`)
+	wallets := map[uuid.UUID]string{
+		accountID:  "EUR",
+		merchantID: "RUB",
+	}
+
+	t.Run("LockBalances", func(t *testing.T) {
+		rows := sqlmock.NewRows(colums).
+			AddRow(accountID, "EUR", 50, 0).
+			AddRow(merchantID, "RUB", 0, 0)
+		mock.ExpectBegin()
+		mock.ExpectQuery(query).WithArgs(
+			pq.Array(ids),
+			pq.Array(currencies),
+		).WillReturnRows(rows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		balances, err := psql.LockBalances(context.Background(), tx, wallets)
+		require.NoError(t, err)
+		require.Len(t, balances, 2)
+		require.Equal(t, uint64(50), balances[accountID].Balance)
+		require.Equal(t, "RUB", balances[merchantID].Currency)
+	})
+
+	t.Run("Currency isn't held", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(query).WithArgs(
+			pq.Array(ids),
+			pq.Array(currencies),
+		).WillReturnRows(sqlmock.NewRows(colums).AddRow(accountID, "EUR", 50, 0))
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		balances, err := psql.LockBalances(context.Background(), tx, wallets)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.Nil(t, balances)
+	})
+
+	t.Run("Lock order", func(t *testing.T) {
+		// rows are locked in the account id order whatever the order of the wallets,
+		// so concurrent payments of the same accounts don't deadlock
+		first := uuid.MustParse("00000000-0000-4000-8000-000000000001")
+		last := uuid.MustParse("ffffffff-ffff-4fff-bfff-ffffffffffff")
+		rows := sqlmock.NewRows(colums).
+			AddRow(last, "RUB", 0, 0).
+			AddRow(first, "EUR", 50, 0)
+		mock.ExpectBegin()
+		mock.ExpectQuery(query).WithArgs(
+			pq.Array([]string{first.String(), last.String()}),
+			pq.Array([]string{"EUR", "RUB"}),
+		).WillReturnRows(rows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		balances, err := psql.LockBalances(context.Background(), tx, map[uuid.UUID]string{
+			last:  "RUB",
+			first: "EUR",
+		})
+		require.NoError(t, err)
+		require.Equal(t, uint64(50), balances[first].Balance)
+		require.Equal(t, "RUB", balances[last].Currency)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_LockPayment(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("LockPayment", func(t *testing.T) {
+		colums := []string{
+			"id",
+			"business_id",
+			"order_id",
+			"operation",
+			"amount",
+			"status",
+			"currency",
+			"card_token",
+			"card_expiry_month",
+			"card_expiry_year",
+			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
+		}
+		id := uuid.New()
+		rows := sqlmock.NewRows(colums).AddRow(
+			id,
+			uuid.New(),
+			"1",
+			"Authorization",
+			50,
+			"Authorized",
+			"RUB",
+			"444444444444444",
+			"12",
+			"24",
+			time.Now(),
+			50,
+			"RUB",
+			"1",
+			time.Now(),
+			"",
+			0,
+			0,
+			0,
+			uuid.Nil,
+			0,
+			nil,
+			"visa",
+			nil,
+			"",
+		)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM payment WHERE id = $1 FOR UPDATE`)).WithArgs(id).WillReturnRows(rows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		pay, err := psql.LockPayment(context.Background(), tx, id)
+		require.NoError(t, err)
+		require.Equal(t, id, pay.ID)
+		require.Equal(t, types.StatusAuthorized, pay.Status)
+	})
+}
+
+func Test_UpdatePayment(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("UpdatePayment", func(t *testing.T) {
+		colums := []string{
+			"id",
+			"business_id",
+			"order_id",
+			"operation",
+			"amount",
+			"status",
+			"currency",
+			"card_token",
+			"card_expiry_month",
+			"card_expiry_year",
+			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
+		}
+		payment := &types.Payment{
+			ID:               uuid.New(),
+			Operation:        types.OperationAuthorization,
+			Amount:           50,
+			Status:           types.StatusPartiallyCaptured,
+			SettlementAmount: 50,
+			CapturedAmount:   30,
+			SettledAmount:    30,
+		}
+		rows := sqlmock.NewRows(colums).AddRow(
+			payment.ID,
+			uuid.New(),
+			"1",
+			payment.Operation,
+			50,
+			"Partially captured",
+			"RUB",
+			"444444444444444",
+			"12",
+			"24",
+			time.Now(),
+			50,
+			"RUB",
+			"1",
+			time.Now(),
+			"",
+			30,
+			0,
+			30,
+			uuid.Nil,
+			0,
+			nil,
+			"visa",
+			nil,
+			"",
+		)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE payment
+			SET amount = $1,
+				status = $2,
+				settlement_amount = $3,
+				captured_amount = $4,
+				released_amount = $5,
+				settled_amount = $6,
+				refunded_amount = $7
+			WHERE id = $8
+			RETURNING *`)).WithArgs(
+			payment.Amount,
+			payment.Status,
+			payment.SettlementAmount,
+			payment.CapturedAmount,
+			payment.ReleasedAmount,
+			payment.SettledAmount,
+			payment.RefundedAmount,
+			payment.ID,
+		).WillReturnRows(rows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		updated, err := psql.UpdatePayment(context.Background(), tx, payment)
+		require.NoError(t, err)
+		require.Equal(t, payment.ID, updated.ID)
+		require.Equal(t, types.StatusPartiallyCaptured, updated.Status)
+		require.Equal(t, uint64(50), updated.Amount)
+		require.Equal(t, uint64(20), updated.Remaining())
+	})
+}
+
+func Test_GetRefunds(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	t.Run("GetRefunds", func(t *testing.T) {
+		colums := []string{
+			"id",
+			"business_id",
+			"order_id",
+			"operation",
+			"amount",
+			"status",
+			"currency",
+			"card_token",
+			"card_expiry_month",
+			"card_expiry_year",
+			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
+		}
+		captureID, merchantID := uuid.New(), uuid.New()
+		rows := sqlmock.NewRows(colums)
+		for _, amount := range []uint64{10, 15} {
+			rows.AddRow(
+				uuid.New(),
+				merchantID,
+				"1",
+				"Refund",
+				amount,
+				"Refunded",
+				"RUB",
+				"444444444444444",
+				"12",
+				"24",
+				time.Now(),
+				amount,
+				"RUB",
+				"1",
+				time.Now(),
+				"",
+				0,
+				0,
+				0,
+				captureID,
+				0,
+				nil,
+				"visa",
+				nil,
+				"",
+			)
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM payment
+			WHERE reference_id = $1 AND operation = $2
+			ORDER BY created_at`)).WithArgs(captureID, types.OperationRefund).WillReturnRows(rows)
+
+		refunds, err := psql.GetRefunds(context.Background(), captureID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 2)
+		require.Equal(t, uint64(10), refunds[0].Amount)
+		require.Equal(t, captureID, refunds[1].ReferenceId)
+		require.Equal(t, types.StatusRefunded, refunds[1].Status)
+	})
+}
+
+func Test_LockExpiredPayment(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+	query := regexp.QuoteMeta(`SELECT * FROM payment
+		WHERE operation = $1
+			AND status IN ($2, $3)
+			AND expires_at <= $4
+			AND NOT EXISTS (
+				SELECT 1 FROM expiry_retry
+				WHERE expiry_retry.payment_id = payment.id AND expiry_retry.retry_at > $4
+			)
+		ORDER BY expires_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`)
+	now := time.Now()
+
+	t.Run("LockExpiredPayment", func(t *testing.T) {
+		colums := []string{
+			"id",
+			"business_id",
+			"order_id",
+			"operation",
+			"amount",
+			"status",
+			"currency",
+			"card_token",
+			"card_expiry_month",
+			"card_expiry_year",
+			"created_at",
+			"settlement_amount",
+			"settlement_currency",
+			"fx_rate",
+			"fx_rate_at",
+			"reason",
+			"captured_amount",
+			"released_amount",
+			"settled_amount",
+			"reference_id",
+			"refunded_amount",
+			"expires_at",
+			"card_brand",
+			"account_id",
+			"card_number",
+		}
+		id := uuid.New()
+		expiresAt := now.Add(-time.Hour)
+		rows := sqlmock.NewRows(colums).AddRow(
+			id,
+			uuid.New(),
+			"1",
+			"Authorization",
+			50,
+			"Authorized",
+			"RUB",
+			"444444444444444",
+			"12",
+			"24",
+			now,
+			50,
+			"RUB",
+			"1",
+			now,
+			"",
+			0,
+			0,
+			0,
+			uuid.Nil,
+			0,
+			expiresAt,
+			"visa",
+			nil,
+			"",
+		)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(query).WithArgs(
+			types.OperationAuthorization,
+			types.StatusAuthorized,
+			types.StatusPartiallyCaptured,
+			now,
+		).WillReturnRows(rows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		pay, err := psql.LockExpiredPayment(context.Background(), tx, now)
+		require.NoError(t, err)
+		require.Equal(t, id, pay.ID)
+		require.Equal(t, expiresAt, *pay.ExpiresAt)
+	})
+
+	t.Run("Nothing expired", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(query).WillReturnError(sql.ErrNoRows)
+
+		tx, _ := db.BeginTx(context.Background(), nil)
+		pay, err := psql.LockExpiredPayment(context.Background(), tx, now)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.Nil(t, pay)
+	})
+}
+
+func Test_DeferExpiry(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+	id := uuid.New()
+	retryAt := time.Now().Add(time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO expiry_retry (payment_id, retry_at)
+		VALUES ($1, $2)
+		ON CONFLICT (payment_id) DO UPDATE
+		SET attempts = expiry_retry.attempts + 1, retry_at = EXCLUDED.retry_at
+		RETURNING attempts`)).
+		WithArgs(id, retryAt).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(2))
+
+	attempts, err := psql.DeferExpiry(context.Background(), id, retryAt)
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_APIKey(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := testKeys(t)
+	psql := NewPostgresStorage(db, keys)
+
+	colums := []string{
+		"id",
+		"account_id",
+		"type",
+		"mode",
+		"prefix",
+		"last4",
+		"hash",
+		"created_at",
+		"revoked_at",
+		"signing_secret",
+	}
+	id, accountID := uuid.New(), uuid.New()
+	key := &types.APIKey{
+		AccountID:     accountID,
+		Type:          types.APIKeySecret,
+		Mode:          types.APIKeyModeLive,
+		Prefix:        "sk_live_",
+		Last4:         "a1b2",
+		Hash:          "hash",
+		CreatedAt:     time.Now(),
+		SigningSecret: "secret",
+	}
+	// stored encrypted
+	sealedSecret := key.SigningSecret
+	require.NoError(t, envelope.NewCipher(keys).Seal(context.Background(), "api_key", map[string]*string{"signing_secret": &sealedSecret}))
+
+	t.Run("SaveAPIKey", func(t *testing.T) {
+		rows := sqlmock.NewRows(colums).AddRow(
+			id, accountID, key.Type, key.Mode, key.Prefix, key.Last4, key.Hash, key.CreatedAt, nil, sealedSecret,
+		)
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO api_key (account_id, type, mode, prefix, last4, hash, created_at, signing_secret)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING *`)).WithArgs(
+			accountID, key.Type, key.Mode, key.Prefix, key.Last4, key.Hash, key.CreatedAt,
+			sealedArg{keys: keys, table: "api_key", column: "signing_secret", value: key.SigningSecret},
+		).WillReturnRows(rows)
+
+		saved, err := psql.SaveAPIKey(context.Background(), key)
+		require.NoError(t, err)
+		require.Equal(t, id, saved.ID)
+		require.Nil(t, saved.RevokedAt)
+		require.Equal(t, key.SigningSecret, saved.SigningSecret)
+	})
+
+	t.Run("GetAPIKeyByHash", func(t *testing.T) {
+		rows := sqlmock.NewRows(colums).AddRow(
+			id, accountID, key.Type, key.Mode, key.Prefix, key.Last4, key.Hash, key.CreatedAt, nil, sealedSecret,
+		)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM api_key
+			WHERE hash = $1 AND revoked_at IS NULL`)).WithArgs(key.Hash).WillReturnRows(rows)
+
+		found, err := psql.GetAPIKeyByHash(context.Background(), key.Hash)
+		require.NoError(t, err)
+		require.Equal(t, accountID, found.AccountID)
+		require.Equal(t, key.SigningSecret, found.SigningSecret)
+	})
+
+	t.Run("RevokeAPIKey", func(t *testing.T) {
+		revokedAt := time.Now()
+		rows := sqlmock.NewRows(colums).AddRow(
+			id, accountID, key.Type, key.Mode, key.Prefix, key.Last4, key.Hash, key.CreatedAt, revokedAt, sealedSecret,
+		)
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE api_key
+			SET revoked_at = $1
+			WHERE id = $2 AND account_id = $3 AND revoked_at IS NULL
+			RETURNING *`)).WithArgs(sqlmock.AnyArg(), id, accountID).WillReturnRows(rows)
+
+		revoked, err := psql.RevokeAPIKey(context.Background(), accountID, id)
+		require.NoError(t, err)
+		require.NotNil(t, revoked.RevokedAt)
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE api_key`)).WithArgs(sqlmock.AnyArg(), id, accountID).WillReturnError(sql.ErrNoRows)
+
+		_, err := psql.RevokeAPIKey(context.Background(), accountID, id)
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_Credential(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	psql := NewPostgresStorage(db, testKeys(t))
+
+	colums := []string{
+		"account_id",
+		"password_hash",
+		"failed_logins",
+		"locked_until",
+		"updated_at",
+	}
+	id := uuid.New()
+
+	t.Run("SaveCredential", func(t *testing.T) {
+		rows := sqlmock.NewRows(colums).AddRow(id, "hash", 0, nil, time.Now())
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO credential (account_id, password_hash, updated_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (account_id)
+			DO UPDATE SET password_hash = EXCLUDED.password_hash,
+				failed_logins = 0,
+				locked_until = NULL,
+				updated_at = EXCLUDED.updated_at
+			RETURNING *`)).WithArgs(id, "hash", sqlmock.AnyArg()).WillReturnRows(rows)
+
+		credential, err := psql.SaveCredential(context.Background(), &types.Credential{
+			AccountID:    id,
+			PasswordHash: "hash",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "hash", credential.PasswordHash)
+		require.Nil(t, credential.LockedUntil)
+	})
+
+	t.Run("SaveFailedLogin", func(t *testing.T) {
+		lockedUntil := time.Now().Add(15 * time.Minute)
+		rows := sqlmock.NewRows(colums).AddRow(id, "hash", 0, lockedUntil, time.Now())
+		mock.ExpectQuery(regexp.QuoteMeta(`UPDATE credential
+			SET failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+				locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END
+			WHERE account_id = $1
+			RETURNING *`)).WithArgs(id, 5, lockedUntil).WillReturnRows(rows)
+
+		credential, err := psql.SaveFailedLogin(context.Background(), id, 5, lockedUntil)
+		require.NoError(t, err)
+		require.True(t, credential.Locked(time.Now()))
+	})
+
+	t.Run("ResetFailedLogins", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE credential
+			SET failed_logins = 0, locked_until = NULL
+			WHERE account_id = $1`)).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, psql.ResetFailedLogins(context.Background(), id))
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_TOTP(t *testing.T) {
+	t.Parallel()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	keys := testKeys(t)
+	psql := NewPostgresStorage(db, keys)
+
+	colums := []string{
+		"account_id",
+		"secret",
+		"recovery_codes",
+		"last_counter",
+		"confirmed_at",
+		"created_at",
+	}
+	id := uuid.New()
+	codes := []string{"hash1", "hash2"}
+	// stored encrypted
+	sealedSecret := "SECRET"
+	require.NoError(t, envelope.NewCipher(keys).Seal(context.Background(), "totp", map[string]*string{"secret": &sealedSecret}))
+
+	t.Run("SaveTOTP", func(t *testing.T) {
+		rows := sqlmock.NewRows(colums).AddRow(id, sealedSecret, pq.Array(codes), 0, nil, time.Now())
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO totp (account_id, secret, recovery_codes, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (account_id)
+			DO UPDATE SET secret = EXCLUDED.secret,
+				recovery_codes = EXCLUDED.recovery_codes,
+				last_counter = 0,
+				created_at = EXCLUDED.created_at
+			WHERE totp.confirmed_at IS NULL
+			RETURNING *`)).WithArgs(
+			id,
+			sealedArg{keys: keys, table: "totp", column: "secret", value: "SECRET"},
+			pq.Array(codes),
+			sqlmock.AnyArg(),
+		).WillReturnRows(rows)
+
+		totp, err := psql.SaveTOTP(context.Background(), &types.TOTP{
+			AccountID:     id,
+			Secret:        "SECRET",
+			RecoveryCodes: codes,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "SECRET", totp.Secret)
+		require.Equal(t, codes, totp.RecoveryCodes)
+		require.False(t, totp.Enabled())
+	})
+
+	t.Run("UseTOTPCode", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE totp
+			SET last_counter = $2,
+				confirmed_at = COALESCE(confirmed_at, $3)
+			WHERE account_id = $1 AND last_counter < $2`)).WithArgs(id, int64(100), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		ok, err := psql.UseTOTPCode(context.Background(), id, 100)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("UseRecoveryCode", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE totp
+			SET recovery_codes = array_remove(recovery_codes, $2)
+			WHERE account_id = $1 AND confirmed_at IS NOT NULL AND $2 = ANY(recovery_codes)`)).WithArgs(id, "hash3").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ok, err := psql.UseRecoveryCode(context.Background(), id, "hash3")
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
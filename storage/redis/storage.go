@@ -2,9 +2,12 @@ package redisrepo
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"math/rand"
+	"errors"
+	"sort"
 	"time"
 
 	"github.com/Edbeer/paymentapi/types"
@@ -23,61 +26,399 @@ func NewRedisStorage(redis  *redis.Client) *RedisStorage {
 	}
 }
 
-// Add refresh token in redis
+// Session keys: the session by id, the session id by refresh token
+// and ids of the account sessions
+const (
+	sessionPrefix         = "session:"
+	refreshTokenPrefix    = "refresh:"
+	accountSessionsPrefix = "sessions:"
+)
+
+// Rotated refresh token was used again, its session is revoked
+var ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+
+// stored session with the hash of the current refresh token of its family,
+// refresh tokens themselves are never stored
+type sessionRecord struct {
+	*types.Session
+	TokenHash string `json:"token_hash"`
+}
+
+// Create session with the first refresh token of its family
 func (s *RedisStorage) CreateSession(ctx context.Context, session *types.Session, expire int) (string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.CreateSession")
 	defer span.Finish()
-	
-	session.RefreshToken = newRefreshToken()
 
-	sessionBytes, err := json.Marshal(&session)
+	refreshToken, err := newToken()
 	if err != nil {
 		return "", err
 	}
-	if err := s.redis.Set(ctx, session.RefreshToken, sessionBytes, time.Second*time.Duration(expire)).Err(); err != nil {
+	session.ID = uuid.New()
+	session.RefreshToken = refreshToken
+	session.CreatedAt = time.Now().UTC()
+	session.LastUsedAt = session.CreatedAt
+
+	if _, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return saveSession(ctx, pipe, session, expire)
+	}); err != nil {
 		return "", err
 	}
 	return session.RefreshToken, nil
 }
 
-// Get user id from session
-func (s *RedisStorage) GetUserID(ctx context.Context, refreshToken string) (uuid.UUID, error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.GetUserID")
+// Replace the refresh token of its session, returns the session with the new token.
+// A token that was already replaced revokes the session
+func (s *RedisStorage) RotateSession(ctx context.Context, refreshToken string, expire int) (*types.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.RotateSession")
 	defer span.Finish()
 
-	sessionBytes, err := s.redis.Get(ctx, refreshToken).Bytes()
+	id, err := s.redis.Get(ctx, tokenKey(refreshTokenPrefix, refreshToken)).Result()
 	if err != nil {
-		return uuid.Nil , err
+		return nil, err
 	}
-	session := &types.Session{}
-	if err = json.Unmarshal(sessionBytes, session); err != nil {
-		return uuid.Nil, err
+
+	var session *types.Session
+	key := sessionPrefix + id
+	err = s.redis.Watch(ctx, func(tx *redis.Tx) error {
+		record, err := getSession(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if record.TokenHash != hashToken(refreshToken) {
+			if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, key)
+				pipe.SRem(ctx, accountSessionsPrefix+record.UserID.String(), id)
+				return nil
+			}); err != nil {
+				return err
+			}
+			return ErrRefreshTokenReused
+		}
+
+		refreshToken, err := newToken()
+		if err != nil {
+			return err
+		}
+		session = record.Session
+		session.RefreshToken = refreshToken
+		session.LastUsedAt = time.Now().UTC()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return saveSession(ctx, pipe, session, expire)
+		})
+		return err
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Active sessions of the account, the last used first
+func (s *RedisStorage) GetSessions(ctx context.Context, userID uuid.UUID) ([]*types.Session, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.GetSessions")
+	defer span.Finish()
+
+	accountKey := accountSessionsPrefix + userID.String()
+	ids, err := s.redis.SMembers(ctx, accountKey).Result()
+	if err != nil {
+		return nil, err
 	}
+	sessions := []*types.Session{}
+	for _, id := range ids {
+		record, err := getSession(ctx, s.redis, sessionPrefix+id)
+		if errors.Is(err, redis.Nil) {
+			// expired session
+			if err := s.redis.SRem(ctx, accountKey, id).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, record.Session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
 
-	return session.UserID, nil
+// Revoke session of the account, redis.Nil if the account has no such session
+func (s *RedisStorage) RevokeSession(ctx context.Context, userID, id uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.RevokeSession")
+	defer span.Finish()
+
+	removed, err := s.redis.SRem(ctx, accountSessionsPrefix+userID.String(), id.String()).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return redis.Nil
+	}
+	return s.redis.Del(ctx, sessionPrefix+id.String()).Err()
 }
 
-// Delete session cookie
+// Revoke all sessions of the account
+func (s *RedisStorage) RevokeSessions(ctx context.Context, userID uuid.UUID) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.RevokeSessions")
+	defer span.Finish()
+
+	accountKey := accountSessionsPrefix + userID.String()
+	ids, err := s.redis.SMembers(ctx, accountKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{accountKey}
+	for _, id := range ids {
+		keys = append(keys, sessionPrefix+id)
+	}
+	return s.redis.Del(ctx, keys...).Err()
+}
+
+// Revoke all sessions of the account but the session of the current
+// refresh token, replaced tokens don't keep their session
+func (s *RedisStorage) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, refreshToken string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.RevokeOtherSessions")
+	defer span.Finish()
+
+	current, err := s.redis.Get(ctx, tokenKey(refreshTokenPrefix, refreshToken)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if current != "" {
+		record, err := getSession(ctx, s.redis, sessionPrefix+current)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if record == nil || record.UserID != userID || record.TokenHash != hashToken(refreshToken) {
+			current = ""
+		}
+	}
+
+	accountKey := accountSessionsPrefix + userID.String()
+	ids, err := s.redis.SMembers(ctx, accountKey).Result()
+	if err != nil {
+		return err
+	}
+	keys, others := []string{}, []interface{}{}
+	for _, id := range ids {
+		if id != current {
+			keys = append(keys, sessionPrefix+id)
+			others = append(others, id)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keys...)
+		pipe.SRem(ctx, accountKey, others...)
+		return nil
+	})
+	return err
+}
+
+// Delete session of the refresh token
 func (s *RedisStorage) DeleteSession(ctx context.Context, refreshToken string) error {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.DeleteSession")
 	defer span.Finish()
-	
-	if err := s.redis.Del(ctx, refreshToken).Err(); err != nil {
+
+	id, err := s.redis.Get(ctx, tokenKey(refreshTokenPrefix, refreshToken)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
+	record, err := getSession(ctx, s.redis, sessionPrefix+id)
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, sessionPrefix+id)
+		pipe.SRem(ctx, accountSessionsPrefix+record.UserID.String(), id)
+		return nil
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save the session and its current token, replaced tokens
+// are kept until expiry to detect reuse
+func saveSession(ctx context.Context, pipe redis.Pipeliner, session *types.Session, expire int) error {
+	recordBytes, err := json.Marshal(&sessionRecord{
+		Session:   session,
+		TokenHash: hashToken(session.RefreshToken),
+	})
+	if err != nil {
+		return err
+	}
+	ttl := time.Second * time.Duration(expire)
+	id := session.ID.String()
+	accountKey := accountSessionsPrefix + session.UserID.String()
+	pipe.Set(ctx, sessionPrefix+id, recordBytes, ttl)
+	pipe.Set(ctx, tokenKey(refreshTokenPrefix, session.RefreshToken), id, ttl)
+	pipe.SAdd(ctx, accountKey, id)
+	pipe.Expire(ctx, accountKey, ttl)
 	return nil
 }
 
-func newRefreshToken() string {
+func getSession(ctx context.Context, cmd redis.Cmdable, key string) (*sessionRecord, error) {
+	recordBytes, err := cmd.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	record := &sessionRecord{}
+	if err := json.Unmarshal(recordBytes, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Random 256-bit token from the CSPRNG
+func newToken() (string, error) {
 	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Reserve idempotency key, returns the stored record if the key was already used
+func (s *RedisStorage) StartIdempotentRequest(ctx context.Context, key, fingerprint string, expire int) (*types.IdempotentRecord, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.StartIdempotentRequest")
+	defer span.Finish()
+
+	recordBytes, err := json.Marshal(&types.IdempotentRecord{
+		Fingerprint: fingerprint,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.redis.SetNX(ctx, key, recordBytes, time.Second*time.Duration(expire)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, nil
+	}
+	recordBytes, err = s.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	record := &types.IdempotentRecord{}
+	if err := json.Unmarshal(recordBytes, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Save response of the request made with idempotency key
+func (s *RedisStorage) FinishIdempotentRequest(ctx context.Context, key string, record *types.IdempotentRecord, expire int) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.FinishIdempotentRequest")
+	defer span.Finish()
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := s.redis.Set(ctx, key, recordBytes, time.Second*time.Duration(expire)).Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Release idempotency key so the request can be retried
+func (s *RedisStorage) DeleteIdempotentRequest(ctx context.Context, key string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.DeleteIdempotentRequest")
+	defer span.Finish()
+
+	if err := s.redis.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Remember the request nonce, false if it was already used
+func (s *RedisStorage) SaveNonce(ctx context.Context, key string, expire int) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.SaveNonce")
+	defer span.Finish()
 
-	s := rand.NewSource(time.Now().Unix())
-	r := rand.New(s)
+	return s.redis.SetNX(ctx, key, 1, time.Second*time.Duration(expire)).Result()
+}
+
+// Create single use password reset token of the account
+func (s *RedisStorage) CreateResetToken(ctx context.Context, id uuid.UUID, expire int) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.CreateResetToken")
+	defer span.Finish()
+
+	return s.createToken(ctx, "password-reset:", id, expire)
+}
+
+// Account id of the reset token, the token can't be used again
+func (s *RedisStorage) ConsumeResetToken(ctx context.Context, token string) (uuid.UUID, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.ConsumeResetToken")
+	defer span.Finish()
+
+	return s.consumeToken(ctx, "password-reset:", token)
+}
+
+// Create single use challenge of the sign-in waiting for the second factor
+func (s *RedisStorage) CreateSignInChallenge(ctx context.Context, id uuid.UUID, expire int) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.CreateSignInChallenge")
+	defer span.Finish()
+
+	return s.createToken(ctx, "sign-in-challenge:", id, expire)
+}
+
+// Account id of the sign-in challenge, the challenge can't be used again
+func (s *RedisStorage) ConsumeSignInChallenge(ctx context.Context, challenge string) (uuid.UUID, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Redis.ConsumeSignInChallenge")
+	defer span.Finish()
+
+	return s.consumeToken(ctx, "sign-in-challenge:", challenge)
+}
 
-	_, err := r.Read(b)
+// Random token of the account, stored hashed,
+// so a read of redis doesn't give tokens away
+func (s *RedisStorage) createToken(ctx context.Context, prefix string, id uuid.UUID, expire int) (string, error) {
+	token, err := newToken()
 	if err != nil {
-		return ""
+		return "", err
+	}
+	if err := s.redis.Set(ctx, tokenKey(prefix, token), id.String(), time.Second*time.Duration(expire)).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *RedisStorage) consumeToken(ctx context.Context, prefix, token string) (uuid.UUID, error) {
+	key := tokenKey(prefix, token)
+	id, err := s.redis.Get(ctx, key).Result()
+	if err != nil {
+		return uuid.Nil, err
 	}
+	// another request has already used the token
+	deleted, err := s.redis.Del(ctx, key).Result()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if deleted == 0 {
+		return uuid.Nil, redis.Nil
+	}
+	return uuid.Parse(id)
+}
+
+func tokenKey(prefix, token string) string {
+	return prefix + hashToken(token)
+}
 
-	return fmt.Sprintf("%x", b)
-}
\ No newline at end of file
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -3,6 +3,7 @@ package redisrepo
 import (
 	"context"
 	"log"
+	"sync"
 	"testing"
 
 	"github.com/Edbeer/paymentapi/types"
@@ -31,7 +32,8 @@ func TestRedis_CreateSession(t *testing.T) {
 	sessionRedisStorage := SetupSessionRedis()
 
 	t.Run("CreateSession", func(t *testing.T) {
-		refreshToken := newRefreshToken()
+		refreshToken, err := newToken()
+		require.NoError(t, err)
 		session := &types.Session{
 			RefreshToken: refreshToken,
 		}
@@ -40,30 +42,190 @@ func TestRedis_CreateSession(t *testing.T) {
 		require.NoError(t, err)
 		require.NotEqual(t, s, "")
 		require.Equal(t, s, session.RefreshToken)
+		require.NotEqual(t, refreshToken, s)
+		require.Len(t, s, 64)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		const sessions = 100
+		userId := uuid.New()
+		tokens := make(chan string, sessions)
+		errs := make(chan error, sessions)
+		var wg sync.WaitGroup
+		for i := 0; i < sessions; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := sessionRedisStorage.CreateSession(context.Background(), &types.Session{UserID: userId}, 10)
+				errs <- err
+				tokens <- token
+			}()
+		}
+		wg.Wait()
+		close(tokens)
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		unique := map[string]bool{}
+		for token := range tokens {
+			require.False(t, unique[token], "duplicate refresh token")
+			unique[token] = true
+		}
+		require.Len(t, unique, sessions)
+
+		created, err := sessionRedisStorage.GetSessions(context.Background(), userId)
+		require.NoError(t, err)
+		require.Len(t, created, sessions)
+	})
+
+	t.Run("Hashed", func(t *testing.T) {
+		ctx := context.Background()
+		token, err := sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: uuid.New()}, 10)
+		require.NoError(t, err)
+
+		// neither keys nor values contain the token
+		keys, err := sessionRedisStorage.redis.Keys(ctx, "*").Result()
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NotContains(t, key, token)
+			if value, err := sessionRedisStorage.redis.Get(ctx, key).Result(); err == nil {
+				require.NotContains(t, value, token)
+			}
+		}
+		require.NoError(t, sessionRedisStorage.redis.Get(ctx, tokenKey(refreshTokenPrefix, token)).Err())
 	})
 }
 
-func TestRedis_GetUserID(t *testing.T) {
+func TestRedis_RotateSession(t *testing.T) {
 	t.Parallel()
 
 	sessionRedisStorage := SetupSessionRedis()
+	ctx := context.Background()
 
-	t.Run("GetSessionByID", func(t *testing.T) {
+	t.Run("Rotate", func(t *testing.T) {
 		userId := uuid.New()
-		refreshToken := newRefreshToken()
 		session := &types.Session{
-			RefreshToken: refreshToken,
-			UserID: userId,
+			UserID:    userId,
+			UserAgent: "curl/8.0",
 		}
+		refreshToken, err := sessionRedisStorage.CreateSession(ctx, session, 10)
+		require.NoError(t, err)
 
-		createdSession, err := sessionRedisStorage.CreateSession(context.Background(), session, 10)
+		rotated, err := sessionRedisStorage.RotateSession(ctx, refreshToken, 10)
 		require.NoError(t, err)
-		require.NotEqual(t, createdSession, "")
+		require.Equal(t, session.ID, rotated.ID)
+		require.Equal(t, userId, rotated.UserID)
+		require.Equal(t, "curl/8.0", rotated.UserAgent)
+		require.NotEqual(t, refreshToken, rotated.RefreshToken)
+
+		_, err = sessionRedisStorage.RotateSession(ctx, rotated.RefreshToken, 10)
+		require.NoError(t, err)
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		userId := uuid.New()
+		refreshToken, err := sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: userId}, 10)
+		require.NoError(t, err)
+		rotated, err := sessionRedisStorage.RotateSession(ctx, refreshToken, 10)
+		require.NoError(t, err)
+
+		// the replaced token revokes the session with the current token
+		_, err = sessionRedisStorage.RotateSession(ctx, refreshToken, 10)
+		require.ErrorIs(t, err, ErrRefreshTokenReused)
+		_, err = sessionRedisStorage.RotateSession(ctx, rotated.RefreshToken, 10)
+		require.ErrorIs(t, err, redis.Nil)
 
-		s, err := sessionRedisStorage.GetUserID(context.Background(), createdSession)
+		sessions, err := sessionRedisStorage.GetSessions(ctx, userId)
 		require.NoError(t, err)
-		require.NotNil(t, s)
+		require.Empty(t, sessions)
 	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		_, err := sessionRedisStorage.RotateSession(ctx, "unknown", 10)
+		require.ErrorIs(t, err, redis.Nil)
+	})
+}
+
+func TestRedis_Sessions(t *testing.T) {
+	t.Parallel()
+
+	sessionRedisStorage := SetupSessionRedis()
+	ctx := context.Background()
+	userId := uuid.New()
+
+	first := &types.Session{UserID: userId, UserAgent: "first"}
+	firstToken, err := sessionRedisStorage.CreateSession(ctx, first, 10)
+	require.NoError(t, err)
+	second := &types.Session{UserID: userId, UserAgent: "second"}
+	_, err = sessionRedisStorage.CreateSession(ctx, second, 10)
+	require.NoError(t, err)
+	_, err = sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: uuid.New()}, 10)
+	require.NoError(t, err)
+
+	// the refreshed session is the last used
+	_, err = sessionRedisStorage.RotateSession(ctx, firstToken, 10)
+	require.NoError(t, err)
+	sessions, err := sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	require.Equal(t, first.ID, sessions[0].ID)
+	require.Equal(t, second.ID, sessions[1].ID)
+
+	require.NoError(t, sessionRedisStorage.RevokeSession(ctx, userId, second.ID))
+	require.ErrorIs(t, sessionRedisStorage.RevokeSession(ctx, userId, second.ID), redis.Nil)
+	require.ErrorIs(t, sessionRedisStorage.RevokeSession(ctx, uuid.New(), first.ID), redis.Nil)
+	sessions, err = sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	require.NoError(t, sessionRedisStorage.RevokeSessions(ctx, userId))
+	sessions, err = sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func TestRedis_RevokeOtherSessions(t *testing.T) {
+	t.Parallel()
+
+	sessionRedisStorage := SetupSessionRedis()
+	ctx := context.Background()
+	userId := uuid.New()
+
+	current := &types.Session{UserID: userId}
+	currentToken, err := sessionRedisStorage.CreateSession(ctx, current, 10)
+	require.NoError(t, err)
+	otherToken, err := sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: userId}, 10)
+	require.NoError(t, err)
+	strangerToken, err := sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: uuid.New()}, 10)
+	require.NoError(t, err)
+
+	require.NoError(t, sessionRedisStorage.RevokeOtherSessions(ctx, userId, currentToken))
+	sessions, err := sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, current.ID, sessions[0].ID)
+	_, err = sessionRedisStorage.RotateSession(ctx, otherToken, 10)
+	require.ErrorIs(t, err, redis.Nil)
+
+	// a replaced token doesn't keep its session
+	_, err = sessionRedisStorage.RotateSession(ctx, currentToken, 10)
+	require.NoError(t, err)
+	require.NoError(t, sessionRedisStorage.RevokeOtherSessions(ctx, userId, currentToken))
+	sessions, err = sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	// the session of another account isn't kept or revoked
+	_, err = sessionRedisStorage.CreateSession(ctx, &types.Session{UserID: userId}, 10)
+	require.NoError(t, err)
+	require.NoError(t, sessionRedisStorage.RevokeOtherSessions(ctx, userId, strangerToken))
+	sessions, err = sessionRedisStorage.GetSessions(ctx, userId)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+	_, err = sessionRedisStorage.RotateSession(ctx, strangerToken, 10)
+	require.NoError(t, err)
 }
 
 func TestRedis_DeleteSession(t *testing.T) {
@@ -73,9 +235,7 @@ func TestRedis_DeleteSession(t *testing.T) {
 
 	t.Run("DeleteSession", func(t *testing.T) {
 		userId := uuid.New()
-		refreshToken := newRefreshToken()
 		session := &types.Session{
-			RefreshToken: refreshToken,
 			UserID: userId,
 		}
 
@@ -86,5 +246,103 @@ func TestRedis_DeleteSession(t *testing.T) {
 		err = sessionRedisStorage.DeleteSession(context.Background(), createdSession)
 		require.NoError(t, err)
 		require.Nil(t, err)
+
+		_, err = sessionRedisStorage.RotateSession(context.Background(), createdSession, 10)
+		require.ErrorIs(t, err, redis.Nil)
+	})
+}
+func TestRedis_IdempotentRequest(t *testing.T) {
+	t.Parallel()
+
+	redisStorage := SetupSessionRedis()
+
+	t.Run("IdempotentRequest", func(t *testing.T) {
+		key := "idempotency::" + uuid.NewString()
+
+		record, err := redisStorage.StartIdempotentRequest(context.Background(), key, "fingerprint", 10)
+		require.NoError(t, err)
+		require.Nil(t, record)
+
+		record, err = redisStorage.StartIdempotentRequest(context.Background(), key, "fingerprint", 10)
+		require.NoError(t, err)
+		require.NotNil(t, record)
+		require.True(t, record.InProgress())
+
+		err = redisStorage.FinishIdempotentRequest(context.Background(), key, &types.IdempotentRecord{
+			Fingerprint: "fingerprint",
+			Status:      200,
+			Body:        []byte(`{"status":"Approved"}`),
+		}, 10)
+		require.NoError(t, err)
+
+		record, err = redisStorage.StartIdempotentRequest(context.Background(), key, "fingerprint", 10)
+		require.NoError(t, err)
+		require.False(t, record.InProgress())
+		require.Equal(t, 200, record.Status)
+		require.Equal(t, []byte(`{"status":"Approved"}`), record.Body)
+	})
+
+	t.Run("DeleteIdempotentRequest", func(t *testing.T) {
+		key := "idempotency::" + uuid.NewString()
+
+		_, err := redisStorage.StartIdempotentRequest(context.Background(), key, "fingerprint", 10)
+		require.NoError(t, err)
+		err = redisStorage.DeleteIdempotentRequest(context.Background(), key)
+		require.NoError(t, err)
+
+		record, err := redisStorage.StartIdempotentRequest(context.Background(), key, "fingerprint", 10)
+		require.NoError(t, err)
+		require.Nil(t, record)
 	})
-}
\ No newline at end of file
+}
+
+func TestRedis_SaveNonce(t *testing.T) {
+	t.Parallel()
+
+	redisStorage := SetupSessionRedis()
+
+	key := "nonce:" + uuid.NewString()
+	ok, err := redisStorage.SaveNonce(context.Background(), key, 10)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = redisStorage.SaveNonce(context.Background(), key, 10)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestRedis_ResetToken(t *testing.T) {
+	t.Parallel()
+
+	redisStorage := SetupSessionRedis()
+
+	id := uuid.New()
+	token, err := redisStorage.CreateResetToken(context.Background(), id, 10)
+	require.NoError(t, err)
+	require.Len(t, token, 64)
+
+	accountID, err := redisStorage.ConsumeResetToken(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, id, accountID)
+
+	_, err = redisStorage.ConsumeResetToken(context.Background(), token)
+	require.Error(t, err)
+}
+
+func TestRedis_SignInChallenge(t *testing.T) {
+	t.Parallel()
+
+	redisStorage := SetupSessionRedis()
+
+	id := uuid.New()
+	challenge, err := redisStorage.CreateSignInChallenge(context.Background(), id, 10)
+	require.NoError(t, err)
+
+	// reset tokens and challenges don't mix
+	_, err = redisStorage.ConsumeResetToken(context.Background(), challenge)
+	require.Error(t, err)
+
+	accountID, err := redisStorage.ConsumeSignInChallenge(context.Background(), challenge)
+	require.NoError(t, err)
+	require.Equal(t, id, accountID)
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,47 +9,99 @@ import (
 
 // Account
 type Account struct {
-	ID               uuid.UUID `json:"id"`
-	FirstName        string    `json:"first_name"`
-	LastName         string    `json:"last_name"`
-	CardNumber       string    `json:"card_number"`
-	CardExpiryMonth  string    `json:"card_expiry_month"`
-	CardExpiryYear   string    `json:"card_expiry_year"`
-	CardSecurityCode string    `json:"card_security_code"`
-	Balance          uint64    `json:"balance"`
-	BlockedMoney     uint64    `json:"blocked_money"`
-	Statement        []string  `json:"statement"`
-	CreatedAt        time.Time `json:"created_at"`
+	ID               uuid.UUID  `json:"id"`
+	FirstName        string     `json:"first_name"`
+	LastName         string     `json:"last_name"`
+	CardToken        string     `json:"card_token"`
+	CardNumber       string     `json:"card_number"`
+	CardExpiryMonth  string     `json:"card_expiry_month"`
+	CardExpiryYear   string     `json:"card_expiry_year"`
+	Currency         string     `json:"currency"`
+	AuthorizationTTL uint32     `json:"authorization_ttl"`
+	Role             string     `json:"role"`
+	Balances         []*Balance `json:"balances,omitempty"`
+	Statement        []string   `json:"statement"`
+	CreatedAt        time.Time  `json:"created_at"`
 }
 
-func NewAccount(req *RequestCreate) *Account {
+// New account of the request, the card is referenced by its vault token
+func NewAccount(req *RequestCreate, card *Card) *Account {
+	currency := req.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	role := req.Role
+	if role == "" {
+		role = RoleCustomer
+	}
 	return &Account{
-		FirstName:        req.FirstName,
-		LastName:         req.LastName,
-		CardNumber:       req.CardNumber,
-		CardExpiryMonth:  req.CardExpiryMonth,
-		CardExpiryYear:   req.CardExpiryYear,
-		CardSecurityCode: req.CardSecurityCode,
-		Balance:          0,
-		BlockedMoney:     0,
-		Statement:        []string{},
-		CreatedAt:        time.Now(),
+		FirstName:       req.FirstName,
+		LastName:        req.LastName,
+		CardToken:       card.Token,
+		CardNumber:      card.CardNumber,
+		CardExpiryMonth: req.CardExpiryMonth,
+		CardExpiryYear:  req.CardExpiryYear,
+		Currency:        currency,
+		Role:            role,
+		Statement:       []string{},
+		CreatedAt:       time.Now(),
 	}
 }
 
+// Account with the masked card number
+func (a Account) MarshalJSON() ([]byte, error) {
+	type account Account
+	masked := account(a)
+	masked.CardNumber = MaskPAN(a.CardNumber)
+	return json.Marshal(&masked)
+}
+
+// Balance of the account in the currency
+func (a *Account) Wallet(currency string) (*Balance, bool) {
+	for _, balance := range a.Balances {
+		if balance.Currency == currency {
+			return balance, true
+		}
+	}
+	return nil, false
+}
+
+// Account balance in one currency, amounts in minor units
+type Balance struct {
+	AccountID    uuid.UUID `json:"account_id"`
+	Currency     string    `json:"currency"`
+	Balance      uint64    `json:"balance"`
+	BlockedMoney uint64    `json:"blocked_money"`
+}
+
+// Signed change of account balances
+type BalanceDelta struct {
+	Balance      int64
+	BlockedMoney int64
+}
+
+// Request for deposit, currency defaults to the account currency
 type RequestDeposit struct {
 	CardNumber string `json:"card_number"`
+	Currency   string `json:"currency"`
 	Balance    uint64 `json:"balance"`
 }
 
+// Request for open account balance in a new currency
+type RequestBalance struct {
+	Currency string `json:"currency"`
+}
+
 // Request for update account
 type RequestUpdate struct {
-	FirstName        string `json:"first_name"`
-	LastName         string `json:"last_name"`
-	CardNumber       string `json:"card_number"`
-	CardExpiryMonth  string `json:"card_expiry_month"`
-	CardExpiryYear   string `json:"card_expiry_year"`
-	CardSecurityCode string `json:"card_security_code"`
+	FirstName       string `json:"first_name"`
+	LastName        string `json:"last_name"`
+	CardNumber      string `json:"card_number"`
+	CardExpiryMonth string `json:"card_expiry_month"`
+	CardExpiryYear  string `json:"card_expiry_year"`
+	// lifetime of merchant authorizations in hours, unchanged if missing,
+	// 0 goes back to the config or default lifetime
+	AuthorizationTTL *uint32 `json:"authorization_ttl"`
 }
 
 // Request for create account
@@ -58,17 +111,29 @@ type RequestCreate struct {
 	CardNumber       string `json:"card_number"`
 	CardExpiryMonth  string `json:"card_expiry_month"`
 	CardExpiryYear   string `json:"card_expiry_year"`
-	CardSecurityCode string `json:"card_security_code"`
+	CardSecurityCode string `json:"card_security_code"` // verified, never stored
+	Currency         string `json:"currency"`
+	// optional, can be set later
+	Password string `json:"password"`
+	// customer by default or merchant
+	Role string `json:"role"`
 }
 
 type LoginRequest struct {
-	ID uuid.UUID `json:"id"`
+	ID       uuid.UUID `json:"id"`
+	Password string    `json:"password"`
 }
 
-// Session model
+// Session of a sign-in. Its refresh tokens form a family: every refresh
+// replaces the token, reuse of a replaced token revokes the session
 type Session struct {
-	RefreshToken string    `json:"refresh_token" redis:"refresh_token"`
-	UserID       uuid.UUID `json:"id" redis:"id"`
+	ID           uuid.UUID `json:"id"`
+	RefreshToken string    `json:"-"`
+	UserID       uuid.UUID `json:"account_id"`
+	UserAgent    string    `json:"user_agent"`
+	IP           string    `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastUsedAt   time.Time `json:"last_used_at"`
 }
 
 type RefreshRequest struct {
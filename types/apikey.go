@@ -0,0 +1,45 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key types: secret keys are used by merchant servers,
+// publishable keys only authorize payments
+const (
+	APIKeySecret      = "secret"
+	APIKeyPublishable = "publishable"
+)
+
+// API key mode, every balance is live, so there are no test keys
+const APIKeyModeLive = "live"
+
+// Merchant API key, only the hash of the key is stored
+type APIKey struct {
+	ID        uuid.UUID  `json:"id"`
+	AccountID uuid.UUID  `json:"account_id"`
+	Type      string     `json:"type"`
+	Mode      string     `json:"mode"`
+	Prefix    string     `json:"prefix"`
+	Last4     string     `json:"last4"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// secret keys sign requests with it
+	SigningSecret string `json:"-"`
+}
+
+// Request for create API key, secret live key by default
+type RequestAPIKey struct {
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+// Created API key, the key and its signing secret are shown only once
+type APIKeyResponse struct {
+	*APIKey
+	Key           string `json:"key"`
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
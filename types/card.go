@@ -0,0 +1,24 @@
+package types
+
+import "strings"
+
+// Card of the vault, responses show only the masked number
+type Card struct {
+	Token      string
+	CardNumber string
+}
+
+// Card number with all digits but the first 6 and the last 4 masked,
+// shorter numbers keep only the last 4
+func MaskPAN(pan string) string {
+	if pan == "" {
+		return ""
+	}
+	if len(pan) < 14 {
+		if len(pan) <= 4 {
+			return strings.Repeat("*", len(pan))
+		}
+		return strings.Repeat("*", len(pan)-4) + pan[len(pan)-4:]
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
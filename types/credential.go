@@ -0,0 +1,40 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Account password, only the hash is stored
+type Credential struct {
+	AccountID    uuid.UUID
+	PasswordHash string
+	// failed sign-ins since the last successful one or lockout
+	FailedLogins uint32
+	LockedUntil  *time.Time
+	UpdatedAt    time.Time
+}
+
+// Check if sign-in is locked after too many failures
+func (c *Credential) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && now.Before(*c.LockedUntil)
+}
+
+// Request for set or change password,
+// the current password is required to change it
+type RequestPassword struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+// Request for password reset token
+type RequestPasswordReset struct {
+	ID uuid.UUID `json:"id"`
+}
+
+// Request for set password with the reset token
+type RequestPasswordResetConfirm struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ISO 4217 currency. Amounts are stored in minor units,
+// exponent is the number of minor unit digits
+type Currency struct {
+	Code     string `json:"code"`
+	Number   string `json:"number"`
+	Exponent int    `json:"exponent"`
+}
+
+// Supported currencies
+var currencies = map[string]Currency{
+	"AED": {Code: "AED", Number: "784", Exponent: 2},
+	"AMD": {Code: "AMD", Number: "051", Exponent: 2},
+	"BHD": {Code: "BHD", Number: "048", Exponent: 3},
+	"BYN": {Code: "BYN", Number: "933", Exponent: 2},
+	"CHF": {Code: "CHF", Number: "756", Exponent: 2},
+	"CNY": {Code: "CNY", Number: "156", Exponent: 2},
+	"CZK": {Code: "CZK", Number: "203", Exponent: 2},
+	"EUR": {Code: "EUR", Number: "978", Exponent: 2},
+	"GBP": {Code: "GBP", Number: "826", Exponent: 2},
+	"GEL": {Code: "GEL", Number: "981", Exponent: 2},
+	"JPY": {Code: "JPY", Number: "392", Exponent: 0},
+	"KRW": {Code: "KRW", Number: "410", Exponent: 0},
+	"KWD": {Code: "KWD", Number: "414", Exponent: 3},
+	"KZT": {Code: "KZT", Number: "398", Exponent: 2},
+	"PLN": {Code: "PLN", Number: "985", Exponent: 2},
+	"RUB": {Code: "RUB", Number: "643", Exponent: 2},
+	"TRY": {Code: "TRY", Number: "949", Exponent: 2},
+	"UAH": {Code: "UAH", Number: "980", Exponent: 2},
+	"USD": {Code: "USD", Number: "840", Exponent: 2},
+	"UZS": {Code: "UZS", Number: "860", Exponent: 2},
+}
+
+// Find currency by alphabetic code
+func LookupCurrency(code string) (Currency, bool) {
+	currency, ok := currencies[code]
+	return currency, ok
+}
+
+// Exchange rate locked for a payment, markup applied.
+// Rate is a decimal: units of To currency per unit of From currency
+type ExchangeRate struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Rate     string    `json:"rate"`
+	QuotedAt time.Time `json:"quoted_at"`
+}
+
+// Same currency, nothing to convert
+func IdentityRate(currency string, quotedAt time.Time) *ExchangeRate {
+	return &ExchangeRate{
+		From:     currency,
+		To:       currency,
+		Rate:     "1",
+		QuotedAt: quotedAt,
+	}
+}
+
+// Convert amount in minor units of From currency to minor units of To currency.
+// The result is rounded down
+func (r *ExchangeRate) Convert(amount uint64) (uint64, error) {
+	from, ok := LookupCurrency(r.From)
+	if !ok {
+		return 0, fmt.Errorf("unknown currency %q", r.From)
+	}
+	to, ok := LookupCurrency(r.To)
+	if !ok {
+		return 0, fmt.Errorf("unknown currency %q", r.To)
+	}
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return 0, fmt.Errorf("invalid exchange rate %q", r.Rate)
+	}
+	// amount / 10^from.Exponent * rate * 10^to.Exponent
+	value := new(big.Rat).SetInt(new(big.Int).SetUint64(amount))
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetFrac(pow10(to.Exponent), pow10(from.Exponent)))
+	result := new(big.Int).Quo(value.Num(), value.Denom())
+	if !result.IsUint64() {
+		return 0, errors.New("converted amount is too large")
+	}
+	return result.Uint64(), nil
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
@@ -0,0 +1,45 @@
+package types
+
+// Machine codes of domain errors, stable for clients
+const (
+	CodeInvalid           = "invalid_request"
+	CodeNotFound          = "not_found"
+	CodeInsufficientFunds = "insufficient_funds"
+	CodeCardDeclined      = "card_declined"
+	CodeConflict          = "conflict"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeInternal          = "internal"
+)
+
+// Domain error. The message is shown to clients, the cause isn't
+type Error struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error of the code caused by err
+func WrapError(code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Request can't be read, the cause is the message
+func InvalidError(err error) *Error {
+	return &Error{Code: CodeInvalid, Message: err.Error()}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
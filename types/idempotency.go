@@ -0,0 +1,14 @@
+package types
+
+// Stored result of a request made with an Idempotency-Key header
+type IdempotentRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	// zero while the first request is still in progress
+	Status int    `json:"status"`
+	Body   []byte `json:"body"`
+}
+
+// First request with the key has not finished yet
+func (r *IdempotentRecord) InProgress() bool {
+	return r.Status == 0
+}
@@ -0,0 +1,19 @@
+package types
+
+// Public key of access tokens in JWK format (RFC 7517),
+// RSA keys have n and e, Ed25519 keys have crv and x
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Public keys to verify access tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
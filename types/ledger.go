@@ -0,0 +1,170 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Default currency of accounts and payments
+const DefaultCurrency = "RUB"
+
+// Ledger books. Every account has an available and a blocked book,
+// the service itself owns the funding, clearing and settlement books.
+const (
+	BookAvailable  = "available"
+	BookBlocked    = "blocked"
+	BookFunding    = "funding"
+	BookClearing   = "clearing"
+	BookSettlement = "settlement"
+)
+
+// Account id of the service books
+var SystemAccountID = uuid.Nil
+
+// Ledger account: book of an account
+type LedgerAccount struct {
+	AccountID uuid.UUID
+	Book      string
+}
+
+// Available money of the account
+func Available(id uuid.UUID) LedgerAccount {
+	return LedgerAccount{AccountID: id, Book: BookAvailable}
+}
+
+// Blocked money of the account
+func Blocked(id uuid.UUID) LedgerAccount {
+	return LedgerAccount{AccountID: id, Book: BookBlocked}
+}
+
+// Money that entered the service from outside (deposits)
+func Funding() LedgerAccount {
+	return LedgerAccount{AccountID: SystemAccountID, Book: BookFunding}
+}
+
+// Counterpart of the merchant blocked money until capture or cancel
+func Clearing() LedgerAccount {
+	return LedgerAccount{AccountID: SystemAccountID, Book: BookClearing}
+}
+
+// Counterpart of captured and refunded money
+func Settlement() LedgerAccount {
+	return LedgerAccount{AccountID: SystemAccountID, Book: BookSettlement}
+}
+
+// Journal entry: balanced set of ledger lines
+type JournalEntry struct {
+	ID          uuid.UUID     `json:"id"`
+	PaymentID   uuid.UUID     `json:"payment_id"`
+	Description string        `json:"description"`
+	Lines       []*LedgerLine `json:"lines"`
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// Ledger line. Account books are credit-normal:
+// balance of a book = sum(credit) - sum(debit)
+type LedgerLine struct {
+	EntryID   uuid.UUID `json:"entry_id"`
+	AccountID uuid.UUID `json:"account_id"`
+	Book      string    `json:"book"`
+	Currency  string    `json:"currency"`
+	Debit     uint64    `json:"debit"`
+	Credit    uint64    `json:"credit"`
+}
+
+func NewJournalEntry(paymentID uuid.UUID, description string) *JournalEntry {
+	return &JournalEntry{
+		ID:          uuid.New(),
+		PaymentID:   paymentID,
+		Description: description,
+		Lines:       []*LedgerLine{},
+		CreatedAt:   time.Now(),
+	}
+}
+
+// Move amount from debit book to credit book
+func (e *JournalEntry) Move(currency string, amount uint64, debit, credit LedgerAccount) *JournalEntry {
+	if amount == 0 {
+		return e
+	}
+	e.Lines = append(e.Lines,
+		&LedgerLine{
+			EntryID:   e.ID,
+			AccountID: debit.AccountID,
+			Book:      debit.Book,
+			Currency:  currency,
+			Debit:     amount,
+		},
+		&LedgerLine{
+			EntryID:   e.ID,
+			AccountID: credit.AccountID,
+			Book:      credit.Book,
+			Currency:  currency,
+			Credit:    amount,
+		},
+	)
+	return e
+}
+
+// Debits equal credits in every currency
+func (e *JournalEntry) Balanced() bool {
+	sums := map[string]int64{}
+	for _, line := range e.Lines {
+		sums[line.Currency] += int64(line.Debit) - int64(line.Credit)
+	}
+	for _, sum := range sums {
+		if sum != 0 {
+			return false
+		}
+	}
+	return len(e.Lines) > 0
+}
+
+// deposit money to account
+func DepositEntry(accountID uuid.UUID, currency string, amount uint64) *JournalEntry {
+	return NewJournalEntry(uuid.Nil, "Deposit").
+		Move(currency, amount, Funding(), Available(accountID))
+}
+
+// Buyer side moves the amount in the payment currency, merchant side moves
+// the settlement amount in the settlement currency. The system books take
+// the exchange difference.
+
+// block buyer money and merchant pending money
+func AuthorizationEntry(payment *Payment, buyerID, merchantID uuid.UUID) *JournalEntry {
+	return NewJournalEntry(payment.ID, payment.Operation).
+		Move(payment.Currency, payment.Amount, Available(buyerID), Blocked(buyerID)).
+		Move(payment.SettlementCurrency, payment.SettlementAmount, Clearing(), Blocked(merchantID))
+}
+
+// release blocked money to the merchant
+func CaptureEntry(payment *Payment, buyerID, merchantID uuid.UUID) *JournalEntry {
+	return NewJournalEntry(payment.ID, payment.Operation).
+		Move(payment.Currency, payment.Amount, Blocked(buyerID), Settlement()).
+		Move(payment.SettlementCurrency, payment.SettlementAmount, Blocked(merchantID), Clearing()).
+		Move(payment.SettlementCurrency, payment.SettlementAmount, Settlement(), Available(merchantID))
+}
+
+// return captured money to the buyer
+func RefundEntry(payment *Payment, buyerID, merchantID uuid.UUID) *JournalEntry {
+	return NewJournalEntry(payment.ID, payment.Operation).
+		Move(payment.SettlementCurrency, payment.SettlementAmount, Available(merchantID), Settlement()).
+		Move(payment.Currency, payment.Amount, Settlement(), Available(buyerID))
+}
+
+// return blocked money to the buyer
+func CancelEntry(payment *Payment, buyerID, merchantID uuid.UUID) *JournalEntry {
+	return NewJournalEntry(payment.ID, payment.Operation).
+		Move(payment.Currency, payment.Amount, Blocked(buyerID), Available(buyerID)).
+		Move(payment.SettlementCurrency, payment.SettlementAmount, Blocked(merchantID), Clearing())
+}
+
+// Account balance derived from the ledger
+type LedgerBalance struct {
+	AccountID    uuid.UUID `json:"account_id"`
+	Currency     string    `json:"currency"`
+	Balance      int64     `json:"balance"`
+	BlockedMoney int64     `json:"blocked_money"`
+	Reconciled   bool      `json:"reconciled"`
+}
@@ -1,65 +1,172 @@
 package types
 
 import (
+	"encoding/json"
 	"time"
 
+	"github.com/Edbeer/paymentapi/pkg/card"
 	"github.com/google/uuid"
 )
 
-// Payment
+// Payment of the buyer account to the business (merchant) account.
+// Amount is in the payment currency,
+// settlement amount is the amount in the merchant currency at the locked rate.
+// Amounts of operations are counted against the payment,
+// settled amount is their part of the settlement amount
 type Payment struct {
-	ID              uuid.UUID `json:"id"`
-	BusinessId      uuid.UUID `json:"business_id"`
-	OrderId         string    `json:"order_id"`
-	Operation       string    `json:"operation"`
-	Amount          uint64    `json:"amount"`
-	Status          string    `json:"status"`
-	Currency        string    `json:"currency"`
-	CardNumber      string    `json:"card_number"`
-	CardExpiryMonth string    `json:"card_expiry_month"`
-	CardExpiryYear  string    `json:"card_expiry_year"`
-	CreatedAt       time.Time `json:"creation_at"`
+	ID                 uuid.UUID     `json:"id"`
+	BusinessId         uuid.UUID     `json:"business_id"`
+	AccountId          uuid.UUID     `json:"account_id"`
+	OrderId            string        `json:"order_id"`
+	Operation          string        `json:"operation"`
+	Amount             uint64        `json:"amount"`
+	Status             PaymentStatus `json:"status"`
+	Reason             string        `json:"reason,omitempty"`
+	Currency           string        `json:"currency"`
+	CardToken          string        `json:"card_token"`
+	CardNumber         string        `json:"card_number"`
+	CardBrand          string        `json:"card_brand"`
+	CardExpiryMonth    string        `json:"card_expiry_month"`
+	CardExpiryYear     string        `json:"card_expiry_year"`
+	CreatedAt          time.Time     `json:"creation_at"`
+	SettlementAmount   uint64        `json:"settlement_amount"`
+	SettlementCurrency string        `json:"settlement_currency"`
+	FxRate             string        `json:"fx_rate"`
+	FxRateAt           time.Time     `json:"fx_rate_at"`
+	CapturedAmount     uint64        `json:"captured_amount"`
+	ReleasedAmount     uint64        `json:"released_amount"`
+	SettledAmount      uint64        `json:"settled_amount"`
+	ReferenceId        uuid.UUID     `json:"reference_id"`
+	RefundedAmount     uint64        `json:"refunded_amount"`
+	ExpiresAt          *time.Time    `json:"expires_at,omitempty"`
 }
 
-// creating a payment
-func CreateAuthPayment(paymentCreate *PaymentRequest, personalAccount *Account, merchantAccount *Account, status string) *Payment {
+// Payment with the masked card number
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type payment Payment
+	masked := payment(p)
+	masked.CardNumber = MaskPAN(p.CardNumber)
+	return json.Marshal(&masked)
+}
+
+// creating a payment, settles in the payment currency until a rate is locked
+func CreateAuthPayment(paymentCreate *PaymentRequest, personalAccount *Account, merchantAccount *Account, status PaymentStatus) *Payment {
+	createdAt := time.Now()
 	return &Payment{
-		ID:              uuid.New(),
-		BusinessId:      merchantAccount.ID,
-		OrderId:         paymentCreate.OrderId,
-		Operation:       "Authorization",
-		Amount:          paymentCreate.Amount,
-		Status:          status,
-		Currency:        "RUB",
-		CardNumber:      personalAccount.CardNumber,
-		CardExpiryMonth: personalAccount.CardExpiryMonth,
-		CardExpiryYear:  personalAccount.CardExpiryYear,
-		CreatedAt:       time.Now(),
+		ID:                 uuid.New(),
+		BusinessId:         merchantAccount.ID,
+		AccountId:          personalAccount.ID,
+		OrderId:            paymentCreate.OrderId,
+		Operation:          OperationAuthorization,
+		Amount:             paymentCreate.Amount,
+		Status:             status,
+		Currency:           paymentCreate.Currency,
+		CardToken:          personalAccount.CardToken,
+		CardNumber:         personalAccount.CardNumber,
+		CardBrand:          cardBrand(paymentCreate.CardNumber),
+		CardExpiryMonth:    personalAccount.CardExpiryMonth,
+		CardExpiryYear:     personalAccount.CardExpiryYear,
+		CreatedAt:          createdAt,
+		SettlementAmount:   paymentCreate.Amount,
+		SettlementCurrency: paymentCreate.Currency,
+		FxRate:             "1",
+		FxRateAt:           createdAt,
 	}
 }
 
-// creating a complete payment
-func CreateCompletePayment(paidPayment *PaidRequest, referncedPayment *Payment, status string) *Payment {
+// creating a complete payment at the rate of the referenced payment,
+// settlement amount is set with Settle by the operation that moves money
+func CreateCompletePayment(paidPayment *PaidRequest, referncedPayment *Payment, status PaymentStatus) *Payment {
 	return &Payment{
-		ID:              uuid.New(),
-		BusinessId:      referncedPayment.BusinessId,
-		OrderId:         paidPayment.OrderId,
-		Operation:       paidPayment.Operation,
-		Amount:          paidPayment.Amount,
-		Status:          status,
-		Currency:        "RUB",
-		CardNumber:      referncedPayment.CardNumber,
-		CardExpiryMonth: referncedPayment.CardExpiryMonth,
-		CardExpiryYear:  referncedPayment.CardExpiryYear,
-		CreatedAt:       time.Now(),
+		ID:                 uuid.New(),
+		BusinessId:         referncedPayment.BusinessId,
+		AccountId:          referncedPayment.AccountId,
+		OrderId:            paidPayment.OrderId,
+		Operation:          paidPayment.Operation,
+		Amount:             paidPayment.Amount,
+		Status:             status,
+		Currency:           referncedPayment.Currency,
+		CardToken:          referncedPayment.CardToken,
+		CardNumber:         referncedPayment.CardNumber,
+		CardBrand:          referncedPayment.CardBrand,
+		CardExpiryMonth:    referncedPayment.CardExpiryMonth,
+		CardExpiryYear:     referncedPayment.CardExpiryYear,
+		CreatedAt:          time.Now(),
+		SettlementCurrency: referncedPayment.SettlementCurrency,
+		FxRate:             referncedPayment.FxRate,
+		FxRateAt:           referncedPayment.FxRateAt,
+		ReferenceId:        referncedPayment.ID,
 	}
 }
 
+func cardBrand(number string) string {
+	brand, _ := card.Detect(number)
+	return string(brand)
+}
+
+// Authorization holds money until it expires
+func (p *Payment) SetExpiry(ttl time.Duration) {
+	expiresAt := p.CreatedAt.Add(ttl)
+	p.ExpiresAt = &expiresAt
+}
+
+// Amount that isn't captured, released or refunded yet
+func (p *Payment) Remaining() uint64 {
+	return p.Amount - p.CapturedAmount - p.ReleasedAmount - p.RefundedAmount
+}
+
+// Settlement amount that isn't settled yet
+func (p *Payment) RemainingSettlement() uint64 {
+	return p.SettlementAmount - p.SettledAmount
+}
+
+// Lock exchange rate and convert the amount to the settlement currency
+func (p *Payment) LockRate(rate *ExchangeRate) error {
+	amount, err := rate.Convert(p.Amount)
+	if err != nil {
+		return err
+	}
+	p.SettlementAmount = amount
+	p.SettlementCurrency = rate.To
+	p.FxRate = rate.Rate
+	p.FxRateAt = rate.QuotedAt
+	return nil
+}
+
+// Exchange rate locked for the payment
+func (p *Payment) ExchangeRate() *ExchangeRate {
+	return &ExchangeRate{
+		From:     p.Currency,
+		To:       p.SettlementCurrency,
+		Rate:     p.FxRate,
+		QuotedAt: p.FxRateAt,
+	}
+}
+
+// Settlement amount for a part of the remaining amount at the locked rate.
+// The remaining amount settles the remaining settlement amount,
+// so rounding never leaves money behind
+func (p *Payment) Settle(amount uint64) (uint64, error) {
+	if amount == p.Remaining() {
+		return p.RemainingSettlement(), nil
+	}
+	settlement, err := p.ExchangeRate().Convert(amount)
+	if err != nil {
+		return 0, err
+	}
+	if settlement > p.RemainingSettlement() {
+		settlement = p.RemainingSettlement()
+	}
+	return settlement, nil
+}
+
 type PaidRequest struct {
 	OrderId   string    `json:"order_id"`
 	PaymentId uuid.UUID `json:"payment_id"`
 	Operation string    `json:"operation"`
 	Amount    uint64    `json:"amount"`
+	// capture only: release the rest of the hold to the buyer
+	FinalCapture bool `json:"final_capture,omitempty"`
 }
 
 type PaymentRequest struct {
@@ -73,7 +180,17 @@ type PaymentRequest struct {
 	CardSecurityCode string    `json:"card_security_code"`
 }
 
+// Refunds of a capture
+type RefundHistory struct {
+	CaptureID      uuid.UUID  `json:"capture_id"`
+	Currency       string     `json:"currency"`
+	CapturedAmount uint64     `json:"captured_amount"`
+	RefundedAmount uint64     `json:"refunded_amount"`
+	Refunds        []*Payment `json:"refunds"`
+}
+
 type PaymentResponse struct {
-	ID     uuid.UUID `json:"id"`
-	Status string    `json:"status"`
+	ID     uuid.UUID     `json:"id"`
+	Status PaymentStatus `json:"status"`
+	Reason string        `json:"reason,omitempty"`
 }
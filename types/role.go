@@ -0,0 +1,86 @@
+package types
+
+// Account roles: customers pay, merchants accept payments,
+// support views accounts, admins manage everything
+const (
+	RoleCustomer = "customer"
+	RoleMerchant = "merchant"
+	RoleSupport  = "support"
+	RoleAdmin    = "admin"
+)
+
+// Roles accounts can choose at sign-up, others are granted by admins
+var SignUpRoles = []string{RoleCustomer, RoleMerchant}
+
+// Action on accounts
+type Permission string
+
+const (
+	PermissionReadAccount    Permission = "account:read"
+	PermissionWriteAccount   Permission = "account:write"
+	PermissionCredentials    Permission = "credentials:manage"
+	PermissionMoveMoney      Permission = "money:move"
+	PermissionManageAPIKeys  Permission = "api_keys:manage"
+	PermissionAcceptPayments Permission = "payments:accept"
+	PermissionListAccounts   Permission = "accounts:list"
+	PermissionManageRoles    Permission = "roles:manage"
+)
+
+// Accounts the permission applies to
+type Scope int
+
+const (
+	// the account itself
+	ScopeOwn Scope = iota + 1
+	// any account
+	ScopeAny
+)
+
+// Permissions of the roles, passwords and two-factor
+// authentication are managed only by the account itself
+var RolePermissions = map[string]map[Permission]Scope{
+	RoleCustomer: {
+		PermissionReadAccount:  ScopeOwn,
+		PermissionWriteAccount: ScopeOwn,
+		PermissionCredentials:  ScopeOwn,
+		PermissionMoveMoney:    ScopeOwn,
+	},
+	RoleMerchant: {
+		PermissionReadAccount:    ScopeOwn,
+		PermissionWriteAccount:   ScopeOwn,
+		PermissionCredentials:    ScopeOwn,
+		PermissionMoveMoney:      ScopeOwn,
+		PermissionManageAPIKeys:  ScopeOwn,
+		PermissionAcceptPayments: ScopeOwn,
+	},
+	RoleSupport: {
+		PermissionReadAccount:  ScopeAny,
+		PermissionWriteAccount: ScopeOwn,
+		PermissionCredentials:  ScopeOwn,
+	},
+	RoleAdmin: {
+		PermissionReadAccount:  ScopeAny,
+		PermissionWriteAccount: ScopeAny,
+		PermissionCredentials:  ScopeOwn,
+		PermissionMoveMoney:    ScopeAny,
+		PermissionListAccounts: ScopeAny,
+		PermissionManageRoles:  ScopeAny,
+	},
+}
+
+// Check if the role has the permission on its own account or on any account
+func Can(role string, permission Permission, own bool) bool {
+	scope, ok := RolePermissions[role][permission]
+	return ok && (own || scope == ScopeAny)
+}
+
+// Check if the role exists
+func ValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// Request for change account role
+type RequestRole struct {
+	Role string `json:"role"`
+}
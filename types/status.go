@@ -0,0 +1,169 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment status
+type PaymentStatus string
+
+const (
+	StatusAuthorized        PaymentStatus = "Authorized"
+	StatusPartiallyCaptured PaymentStatus = "Partially captured"
+	StatusCaptured          PaymentStatus = "Captured"
+	StatusPartiallyRefunded PaymentStatus = "Partially refunded"
+	StatusRefunded          PaymentStatus = "Refunded"
+	StatusVoided            PaymentStatus = "Voided"
+	StatusExpired           PaymentStatus = "Expired"
+	StatusFailed            PaymentStatus = "Failed"
+)
+
+// Lifetime of authorizations if neither the merchant nor the config set it
+const DefaultAuthorizationTTL = 7 * 24 * time.Hour
+
+// Payment operations
+const (
+	OperationAuthorization = "Authorization"
+	OperationCapture       = "Capture"
+	OperationRefund        = "Refund"
+	OperationCancel        = "Cancel"
+	OperationExpire        = "Expire"
+	OperationIncrement     = "Increment"
+)
+
+// Reasons of failed payments
+const (
+	ReasonWrongRequest      = "wrong payment request"
+	ReasonInsufficientFunds = "Insufficient funds"
+	ReasonInvalidAmount     = "Invalid amount"
+)
+
+// Status change of a payment caused by an operation.
+// Transition from the empty status creates the payment
+type Transition struct {
+	From      PaymentStatus
+	To        PaymentStatus
+	Operation string
+}
+
+// Payment state machine. Captures, increments and cancels apply to authorizations,
+// refunds apply to captures; partial operations keep or move the payment
+// to the partial status, the last one moves it to the final status
+var Transitions = []Transition{
+	{From: "", To: StatusAuthorized, Operation: OperationAuthorization},
+	{From: "", To: StatusFailed, Operation: OperationAuthorization},
+
+	{From: StatusAuthorized, To: StatusPartiallyCaptured, Operation: OperationCapture},
+	{From: StatusAuthorized, To: StatusCaptured, Operation: OperationCapture},
+	{From: StatusPartiallyCaptured, To: StatusPartiallyCaptured, Operation: OperationCapture},
+	{From: StatusPartiallyCaptured, To: StatusCaptured, Operation: OperationCapture},
+
+	{From: StatusAuthorized, To: StatusAuthorized, Operation: OperationCancel},
+	{From: StatusAuthorized, To: StatusVoided, Operation: OperationCancel},
+	{From: StatusPartiallyCaptured, To: StatusPartiallyCaptured, Operation: OperationCancel},
+	{From: StatusPartiallyCaptured, To: StatusCaptured, Operation: OperationCancel},
+
+	{From: StatusAuthorized, To: StatusAuthorized, Operation: OperationIncrement},
+	{From: StatusPartiallyCaptured, To: StatusPartiallyCaptured, Operation: OperationIncrement},
+
+	{From: StatusAuthorized, To: StatusExpired, Operation: OperationExpire},
+	{From: StatusPartiallyCaptured, To: StatusExpired, Operation: OperationExpire},
+
+	{From: StatusCaptured, To: StatusPartiallyRefunded, Operation: OperationRefund},
+	{From: StatusCaptured, To: StatusRefunded, Operation: OperationRefund},
+	{From: StatusPartiallyRefunded, To: StatusPartiallyRefunded, Operation: OperationRefund},
+	{From: StatusPartiallyRefunded, To: StatusRefunded, Operation: OperationRefund},
+}
+
+// Operation the referenced payment must be created by
+var referencedOperations = map[string]string{
+	OperationCapture:   OperationAuthorization,
+	OperationCancel:    OperationAuthorization,
+	OperationExpire:    OperationAuthorization,
+	OperationIncrement: OperationAuthorization,
+	OperationRefund:    OperationCapture,
+}
+
+// Transition isn't allowed by the state machine
+type TransitionError struct {
+	PaymentID uuid.UUID     `json:"payment_id"`
+	Operation string        `json:"operation"`
+	From      PaymentStatus `json:"from"`
+	To        PaymentStatus `json:"to"`
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s isn't allowed for payment %s in status %q", e.Operation, e.PaymentID, e.From)
+}
+
+// Payment is declined, the failed payment is saved with the reason
+type DeclineError struct {
+	Code    string
+	Payment *Payment
+}
+
+func (e *DeclineError) Error() string {
+	return "payment declined: " + e.Payment.Reason
+}
+
+// Check if the operation can move a payment from one status to another
+func CanTransition(from, to PaymentStatus, operation string) bool {
+	for _, transition := range Transitions {
+		if transition.From == from && transition.To == to && transition.Operation == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply operation to the payment and move it to the status
+func (p *Payment) Transition(operation string, to PaymentStatus) error {
+	if referencedOperations[operation] != p.Operation || !CanTransition(p.Status, to, operation) {
+		return &TransitionError{
+			PaymentID: p.ID,
+			Operation: operation,
+			From:      p.Status,
+			To:        to,
+		}
+	}
+	p.Status = to
+	return nil
+}
+
+// Status the operation moves the payment to,
+// amount is the part of the remaining payment amount
+func (p *Payment) NextStatus(operation string, amount uint64) PaymentStatus {
+	full := amount >= p.Remaining()
+	switch operation {
+	case OperationCapture:
+		if full {
+			return StatusCaptured
+		}
+		return StatusPartiallyCaptured
+	case OperationRefund:
+		if full {
+			return StatusRefunded
+		}
+		return StatusPartiallyRefunded
+	case OperationCancel:
+		if !full {
+			return p.Status
+		}
+		// captured part stays captured
+		if p.Status == StatusPartiallyCaptured {
+			return StatusCaptured
+		}
+		return StatusVoided
+	case OperationExpire:
+		return StatusExpired
+	}
+	return p.Status
+}
+
+// Apply the operation for the amount
+func (p *Payment) Apply(operation string, amount uint64) error {
+	return p.Transition(operation, p.NextStatus(operation, amount))
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTP two-factor authentication of the account,
+// recovery codes are stored hashed
+type TOTP struct {
+	AccountID     uuid.UUID
+	Secret        string
+	RecoveryCodes []string
+	// time step of the last accepted code, codes can't be reused
+	LastCounter int64
+	ConfirmedAt *time.Time
+	CreatedAt   time.Time
+}
+
+// Check if the enrollment is confirmed
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+// Enrollment for authenticator apps, recovery codes are shown only once
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Request for TOTP enrollment, the current password is required
+type RequestTOTPEnrollment struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// Request with the authenticator code
+type RequestTOTP struct {
+	Code string `json:"code"`
+}
+
+// Sign-in of accounts with two-factor authentication
+// continues with the challenge
+type SignInChallenge struct {
+	Challenge string `json:"challenge"`
+}
+
+// Second sign-in step with the authenticator code or a recovery code
+type RequestSignInTOTP struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
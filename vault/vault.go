@@ -0,0 +1,178 @@
+// Package vault keeps card numbers out of the service: cards are stored
+// encrypted with AES-256-GCM and referenced everywhere else by opaque tokens
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/opentracing/opentracing-go"
+)
+
+// Prefix of card tokens
+const TokenPrefix = "tok_"
+
+var (
+	errKey    = errors.New("vault key must be 32 hex encoded bytes")
+	errSealed = errors.New("malformed encrypted card")
+	// card numbers stored before the vault are still in accounts or payments
+	ErrUntokenized = errors.New("accounts or payments store card numbers, run make tokenize")
+)
+
+// Card vault
+type Vault struct {
+	db          *sql.DB
+	aead        cipher.AEAD
+	fingerprint []byte
+}
+
+// Constructor, the encryption and fingerprint keys are derived from the vault key
+func NewVault(db *sql.DB, cfg config.Vault) (*Vault, error) {
+	key, err := hex.DecodeString(cfg.Key)
+	if err != nil || len(key) != 32 {
+		return nil, errKey
+	}
+	block, err := aes.NewCipher(deriveKey(key, "card encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{
+		db:          db,
+		aead:        aead,
+		fingerprint: deriveKey(key, "card fingerprint"),
+	}, nil
+}
+
+// Token and masked number of the card, the same card always gets the same token
+func (v *Vault) Tokenize(ctx context.Context, pan string) (*types.Card, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Vault.Tokenize")
+	defer span.Finish()
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := v.seal(token, pan)
+	if err != nil {
+		return nil, err
+	}
+	// keeps the token of a tokenized card
+	query := `INSERT INTO card_vault (token, fingerprint, pan, card_number, created_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (fingerprint) DO UPDATE
+			SET card_number = EXCLUDED.card_number
+		RETURNING token, card_number`
+	card := &types.Card{}
+	if err := v.db.QueryRowContext(
+		ctx, query,
+		token,
+		v.Fingerprint(pan),
+		sealed,
+		types.MaskPAN(pan),
+	).Scan(&card.Token, &card.CardNumber); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// Token of a tokenized card, sql.ErrNoRows if the vault doesn't have the card
+func (v *Vault) Lookup(ctx context.Context, pan string) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Vault.Lookup")
+	defer span.Finish()
+
+	query := `SELECT token FROM card_vault WHERE fingerprint = $1`
+	var token string
+	if err := v.db.QueryRowContext(ctx, query, v.Fingerprint(pan)).Scan(&token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Card number of the token
+func (v *Vault) Detokenize(ctx context.Context, token string) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Vault.Detokenize")
+	defer span.Finish()
+
+	query := `SELECT pan FROM card_vault WHERE token = $1`
+	var sealed []byte
+	if err := v.db.QueryRowContext(ctx, query, token).Scan(&sealed); err != nil {
+		return "", err
+	}
+	return v.open(token, sealed)
+}
+
+// Accounts and payments reference cards only by tokens,
+// ErrUntokenized until cmd/tokenize moved older card numbers into the vault
+func (v *Vault) CheckTokenized(ctx context.Context) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Vault.CheckTokenized")
+	defer span.Finish()
+
+	query := `SELECT EXISTS (SELECT 1 FROM account
+				WHERE card_token NOT LIKE 'tok\_%' AND card_token <> '')
+			OR EXISTS (SELECT 1 FROM payment
+				WHERE card_token NOT LIKE 'tok\_%' AND card_token <> '')`
+	var untokenized bool
+	if err := v.db.QueryRowContext(ctx, query).Scan(&untokenized); err != nil {
+		return err
+	}
+	if untokenized {
+		return ErrUntokenized
+	}
+	return nil
+}
+
+// Keyed hash of the card number, finds the card without decrypting the vault
+func (v *Vault) Fingerprint(pan string) string {
+	mac := hmac.New(sha256.New, v.fingerprint)
+	mac.Write([]byte(pan))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypted card number with the nonce in front,
+// bound to the token so it can't be moved to another token
+func (v *Vault) seal(token, pan string) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, []byte(pan), []byte(token)), nil
+}
+
+func (v *Vault) open(token string, sealed []byte) (string, error) {
+	if len(sealed) < v.aead.NonceSize() {
+		return "", errSealed
+	}
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	pan, err := v.aead.Open(nil, nonce, ciphertext, []byte(token))
+	if err != nil {
+		return "", err
+	}
+	return string(pan), nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return TokenPrefix + hex.EncodeToString(b), nil
+}
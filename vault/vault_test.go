@@ -0,0 +1,175 @@
+package vault
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Edbeer/paymentapi/config"
+	"github.com/Edbeer/paymentapi/types"
+	"github.com/stretchr/testify/require"
+)
+
+const pan = "4444333322221111"
+
+func newTestVault(t *testing.T) (*Vault, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	vault, err := NewVault(db, config.Vault{Key: strings.Repeat("0f", 32)})
+	require.NoError(t, err)
+	return vault, mock
+}
+
+// token passed to the query
+type tokenArg struct {
+	token string
+}
+
+func (a *tokenArg) Match(v driver.Value) bool {
+	token, ok := v.(string)
+	a.token = token
+	return ok && strings.HasPrefix(token, TokenPrefix)
+}
+
+// card encrypted with the token
+type sealedArg struct {
+	vault *Vault
+	token *tokenArg
+}
+
+func (a *sealedArg) Match(v driver.Value) bool {
+	sealed, ok := v.([]byte)
+	if !ok || strings.Contains(string(sealed), pan) {
+		return false
+	}
+	opened, err := a.vault.open(a.token.token, sealed)
+	return err == nil && opened == pan
+}
+
+func Test_NewVault(t *testing.T) {
+	t.Parallel()
+
+	for _, key := range []string{"", "0f0f", strings.Repeat("zz", 32)} {
+		_, err := NewVault(nil, config.Vault{Key: key})
+		require.ErrorIs(t, err, errKey)
+	}
+}
+
+func Test_Tokenize(t *testing.T) {
+	t.Parallel()
+
+	vault, mock := newTestVault(t)
+
+	t.Run("Tokenize", func(t *testing.T) {
+		token := &tokenArg{}
+		mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO card_vault (token, fingerprint, pan, card_number, created_at)
+			VALUES ($1, $2, $3, $4, now())
+			ON CONFLICT (fingerprint) DO UPDATE
+				SET card_number = EXCLUDED.card_number
+			RETURNING token, card_number`)).WithArgs(
+			token,
+			vault.Fingerprint(pan),
+			&sealedArg{vault: vault, token: token},
+			"444433******1111",
+		).WillReturnRows(sqlmock.NewRows([]string{"token", "card_number"}).AddRow("tok_1", "444433******1111"))
+
+		// the token of the vault row is returned with the masked number
+		card, err := vault.Tokenize(context.Background(), pan)
+		require.NoError(t, err)
+		require.Equal(t, &types.Card{Token: "tok_1", CardNumber: "444433******1111"}, card)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT token FROM card_vault WHERE fingerprint = $1`)).
+			WithArgs(vault.Fingerprint(pan)).
+			WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow("tok_1"))
+		cardToken, err := vault.Lookup(context.Background(), pan)
+		require.NoError(t, err)
+		require.Equal(t, "tok_1", cardToken)
+
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT token FROM card_vault WHERE fingerprint = $1`)).
+			WithArgs(vault.Fingerprint("5555555555554444")).
+			WillReturnError(sql.ErrNoRows)
+		_, err = vault.Lookup(context.Background(), "5555555555554444")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func Test_Detokenize(t *testing.T) {
+	t.Parallel()
+
+	vault, mock := newTestVault(t)
+	sealed, err := vault.seal("tok_1", pan)
+	require.NoError(t, err)
+
+	query := regexp.QuoteMeta(`SELECT pan FROM card_vault WHERE token = $1`)
+
+	t.Run("Detokenize", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs("tok_1").
+			WillReturnRows(sqlmock.NewRows([]string{"pan"}).AddRow(sealed))
+		card, err := vault.Detokenize(context.Background(), "tok_1")
+		require.NoError(t, err)
+		require.Equal(t, pan, card)
+	})
+
+	t.Run("Moved to another token", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs("tok_2").
+			WillReturnRows(sqlmock.NewRows([]string{"pan"}).AddRow(sealed))
+		_, err := vault.Detokenize(context.Background(), "tok_2")
+		require.Error(t, err)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte{}, sealed...)
+		tampered[len(tampered)-1] ^= 1
+		mock.ExpectQuery(query).WithArgs("tok_1").
+			WillReturnRows(sqlmock.NewRows([]string{"pan"}).AddRow(tampered))
+		_, err := vault.Detokenize(context.Background(), "tok_1")
+		require.Error(t, err)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		mock.ExpectQuery(query).WithArgs("tok_3").WillReturnError(sql.ErrNoRows)
+		_, err := vault.Detokenize(context.Background(), "tok_3")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+	})
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_CheckTokenized(t *testing.T) {
+	t.Parallel()
+
+	vault, mock := newTestVault(t)
+	query := regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM account
+			WHERE card_token NOT LIKE 'tok\_%' AND card_token <> '')
+		OR EXISTS (SELECT 1 FROM payment
+			WHERE card_token NOT LIKE 'tok\_%' AND card_token <> '')`)
+
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	require.NoError(t, vault.CheckTokenized(context.Background()))
+
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	require.ErrorIs(t, vault.CheckTokenized(context.Background()), ErrUntokenized)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_Fingerprint(t *testing.T) {
+	t.Parallel()
+
+	vault, _ := newTestVault(t)
+	other, err := NewVault(nil, config.Vault{Key: strings.Repeat("f0", 32)})
+	require.NoError(t, err)
+
+	require.Equal(t, vault.Fingerprint(pan), vault.Fingerprint(pan))
+	require.NotEqual(t, vault.Fingerprint(pan), vault.Fingerprint("5555555555554444"))
+	// keyed, can't be matched without the vault key
+	require.NotEqual(t, vault.Fingerprint(pan), other.Fingerprint(pan))
+}